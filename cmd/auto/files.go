@@ -2,70 +2,86 @@ package main
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/dgraph-io/badger/v3"
 	"github.com/kmulvey/path"
+	"github.com/kmulvey/realesrgan-scheduler/internal/app/realesrgan/local"
+	"github.com/kmulvey/realesrgan-scheduler/internal/cache"
 	"github.com/kmulvey/realesrgan-scheduler/internal/fs"
+	"github.com/kmulvey/realesrgan-scheduler/internal/pathmap"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/geometry"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
 	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
 )
 
-func findFilesToUpsize(upsizeDirs []path.Entry, originalsDir string, skipDirs map[string]struct{}, skipImages map[string]struct{}) ([]*realesrgan.ImageConfig, error) {
+// findFilesToUpsize returns the originals that mapper has no upsized image for. Their directories are mirrored in the
+// upsized root when they are upsized, only the root itself has to exist. Originals under a directory named in skipDirs,
+// at any depth, and the ones that already meet target are left out.
+func findFilesToUpsize(mapper pathmap.Mapper, maxDepth int, target geometry.Target, skipDirs map[string]struct{}, skipImages map[string]struct{}) ([]*realesrgan.ImageConfig, error) {
 
-	var allImages []*realesrgan.ImageConfig
-	for _, upsizedDir := range upsizeDirs {
+	if _, err := os.Stat(mapper.UpsizedRoot); err != nil {
+		return nil, fmt.Errorf("error finding upsized dir: %s, err: %w", mapper.UpsizedRoot, err)
+	}
 
-		if _, ok := skipDirs[upsizedDir.FileInfo.Name()]; ok {
-			continue
-		}
+	var originalImages, err = fs.Walk(mapper.OriginalsRoot, maxDepth, fs.IsImage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting existing original images: %s, err: %w", mapper.OriginalsRoot, err)
+	}
 
-		var upsizedBase = filepath.Base(upsizedDir.AbsolutePath)
-		var originalsDir = filepath.Join(originalsDir, upsizedBase)
+	// skipped images still keep their names so nothing else can be upsized over them
+	index, err := mapper.Index(path.OnlyNames(originalImages))
+	if err != nil {
+		return nil, err
+	}
 
-		originalImages, err := path.List(originalsDir, 2, false, path.NewRegexEntitiesFilter(fs.ImageExtensionRegex))
-		if err != nil {
-			if !errors.Is(err, os.ErrNotExist) {
-				return nil, fmt.Errorf("error getting existing original images: %s, err: %w", originalsDir, err)
-			}
+	var allImages []*realesrgan.ImageConfig
+	for _, image := range originalImages {
+		if _, ok := skipImages[image.AbsolutePath]; ok || inSkipDir(mapper.OriginalsRoot, image.AbsolutePath, skipDirs) {
+			continue
 		}
 
-		var originalsIndex = make(map[string]path.Entry, len(originalImages))
-		for _, image := range originalImages {
-			if _, ok := skipImages[image.AbsolutePath]; !ok {
-				originalsIndex[image.FileInfo.Name()] = image
-			}
+		source, err := imagetype.Sniff(image.AbsolutePath)
+		if err != nil {
+			continue
 		}
 
-		existingUpsizedImages, err := path.List(upsizedDir.AbsolutePath, 2, false, path.NewRegexEntitiesFilter(fs.ImageExtensionRegex))
+		upsizedFile, err := index.Map(image.AbsolutePath)
 		if err != nil {
-			if !errors.Is(err, os.ErrNotExist) {
-
-				return nil, fmt.Errorf("error getting existing upsized images: %s, err: %w", upsizedDir.AbsolutePath, err)
-			}
+			return nil, err
 		}
 
-		for _, image := range existingUpsizedImages {
-			delete(originalsIndex, image.FileInfo.Name())
+		if _, exists := mapper.Existing(image.AbsolutePath, upsizedFile); exists || local.MeetsTarget(image.AbsolutePath, target) {
+			continue
 		}
 
-		if len(originalsIndex) > 0 {
-			for _, entry := range originalsIndex {
-				var img = &realesrgan.ImageConfig{
-					SourceFile:  entry.AbsolutePath,
-					UpsizedFile: filepath.Join(upsizedDir.AbsolutePath, entry.FileInfo.Name()),
-				}
-				allImages = append(allImages, img)
-			}
-		}
+		allImages = append(allImages, &realesrgan.ImageConfig{
+			SourceFile:  image.AbsolutePath,
+			UpsizedFile: mapper.Resolve(image.AbsolutePath, upsizedFile),
+			Source:      source,
+		})
 	}
 
 	return allImages, nil
 }
 
+// inSkipDir reports whether any directory between root and image is named in skipDirs.
+func inSkipDir(root, image string, skipDirs map[string]struct{}) bool {
+	var rel, err = filepath.Rel(root, filepath.Dir(image))
+	if err != nil {
+		return false
+	}
+
+	for _, dir := range strings.Split(filepath.ToSlash(rel), "/") {
+		if _, ok := skipDirs[dir]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 func makeSkipMap(skipFile string) (map[string]struct{}, error) {
 
 	var skipMap = make(map[string]struct{})
@@ -86,29 +102,17 @@ func makeSkipMap(skipFile string) (map[string]struct{}, error) {
 	return skipMap, nil
 }
 
-func getSkipFiles(dbPath string) (map[string]struct{}, error) {
+func getSkipFiles(db cache.Store) (map[string]struct{}, error) {
 
-	db, err := badger.Open(badger.DefaultOptions(dbPath))
+	var failures, err = db.ListFailures()
 	if err != nil {
-		return nil, fmt.Errorf("error to open skip file db: %s, err: %w", dbPath, err)
+		return nil, fmt.Errorf("error listing skip files: %w", err)
 	}
-	defer db.Close()
-
-	var images = make(map[string]struct{})
-	err = db.View(func(txn *badger.Txn) error {
-
-		var opts = badger.DefaultIteratorOptions
-		opts.PrefetchSize = 20
-		it := txn.NewIterator(opts)
-		defer it.Close()
 
-		for it.Rewind(); it.Valid(); it.Next() {
-			images[string(it.Item().Key())] = struct{}{}
-		}
-
-		it.Close()
-		return nil
-	})
+	var images = make(map[string]struct{}, len(failures))
+	for _, failure := range failures {
+		images[failure.Path] = struct{}{}
+	}
 
-	return images, err
+	return images, nil
 }
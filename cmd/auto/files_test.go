@@ -1,11 +1,18 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/kmulvey/path"
 	"github.com/kmulvey/realesrgan-scheduler/internal/app/realesrgan/local"
+	"github.com/kmulvey/realesrgan-scheduler/internal/cache"
+	"github.com/kmulvey/realesrgan-scheduler/internal/pathmap"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/geometry"
 	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	"github.com/kmulvey/realesrgan-scheduler/testimages"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -15,13 +22,17 @@ func TestFiles(t *testing.T) {
 	var skipDirs, err = makeSkipMap("./skip.txt")
 	assert.NoError(t, err)
 
-	skipImages, err := getSkipFiles("../auto/skipcache")
+	db, err := cache.New("../auto/skipcache")
 	assert.NoError(t, err)
+	defer db.Close()
 
-	upsizedDirs, err := path.List("/home/kmulvey/empyrean/backup/upscayl", 2, false, path.NewDirEntitiesFilter())
+	skipImages, err := getSkipFiles(&db)
 	assert.NoError(t, err)
 
-	images, err := findFilesToUpsize(upsizedDirs, "/home/kmulvey/Documents", skipDirs, skipImages)
+	var mapper = pathmap.Mapper{OriginalsRoot: "/home/kmulvey/Documents", UpsizedRoot: upsizedRoot}
+	assert.NoError(t, mapper.Validate())
+
+	images, err := findFilesToUpsize(mapper, 0, geometry.Target{}, skipDirs, skipImages)
 	assert.NoError(t, err)
 
 	//////////////////
@@ -37,10 +48,72 @@ func TestFiles(t *testing.T) {
 		}
 	}()
 
-	rl, err := local.NewRealesrganLocal(promNamespace, "/home/kmulvey/src/realesrgan-ncnn-vulkan-20220424-ubuntu/realesrgan-ncnn-vulkan", "realesrgan-x4plus", 2, true, files)
+	rl, err := local.NewRealesrganLocal(promNamespace, "/home/kmulvey/src/realesrgan-ncnn-vulkan-20220424-ubuntu/realesrgan-ncnn-vulkan", "realesrgan-x4plus", 2, true, &db, files)
 	assert.NoError(t, err)
 
 	err = rl.Run(images...)
 	assert.NoError(t, err)
 
 }
+
+func TestGetSkipFiles(t *testing.T) {
+	t.Parallel()
+
+	var db = cache.NewMemory()
+	assert.NoError(t, db.AddImage(path.Entry{AbsolutePath: "/photos/bad.jpg"}))
+	assert.NoError(t, db.RecordOutput("/upscaled/good.jpg", cache.Output{SourceFile: "/photos/good.jpg"}))
+
+	var skipImages, err = getSkipFiles(db)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"/photos/bad.jpg": {}}, skipImages)
+}
+
+func TestFindFilesToUpsize(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var mapper = pathmap.Mapper{OriginalsRoot: filepath.Join(dir, "originals"), UpsizedRoot: filepath.Join(dir, "upsized"), Suffix: "_4x"}
+	assert.NoError(t, mapper.Validate())
+
+	for _, file := range []string{"originals/trip/done.png", "originals/trip/new.png", "originals/trip/bad.jpg", "upsized/trip/done_4x.png",
+		"originals/trip/day1/deep.jpg", "originals/skip/trip/skipped.jpg", "upsized/skip/trip/", "originals/later/new.jpg"} {
+		if strings.HasSuffix(file, "/") {
+			assert.NoError(t, os.MkdirAll(filepath.Join(dir, file), 0750))
+		} else {
+			assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, file)), 0750))
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, file), testimages.FoxJPG, 0600))
+		}
+	}
+	// not an image whatever its name says
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "originals/trip/fake.jpg"), testimages.NotAnImage, 0600))
+	// realesrgan cannot read avif, it is converted when upsized
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "originals/trip/fox.avif"), testimages.FoxAVIF, 0600))
+
+	var skipImages = map[string]struct{}{filepath.Join(dir, "originals/trip/bad.jpg"): {}}
+	images, err := findFilesToUpsize(mapper, 0, geometry.Target{}, map[string]struct{}{"skip": {}}, skipImages)
+	assert.NoError(t, err)
+
+	var upsized = make(map[string]string, len(images))
+	for _, image := range images {
+		upsized[image.SourceFile] = image.UpsizedFile
+	}
+	assert.Equal(t, map[string]string{
+		filepath.Join(dir, "originals/trip/new.png"):       filepath.Join(dir, "upsized/trip/new_4x.png"),
+		filepath.Join(dir, "originals/trip/day1/deep.jpg"): filepath.Join(dir, "upsized/trip/day1/deep_4x.jpg"),
+		filepath.Join(dir, "originals/trip/fox.avif"):      filepath.Join(dir, "upsized/trip/fox_4x.png"),
+		filepath.Join(dir, "originals/later/new.jpg"):      filepath.Join(dir, "upsized/later/new_4x.jpg"),
+	}, upsized)
+
+	// images already as big as the target are not listed, so they are not found again by every scan
+	target, err := geometry.Parse("long:100")
+	assert.NoError(t, err)
+	images, err = findFilesToUpsize(mapper, 0, target, map[string]struct{}{"skip": {}}, skipImages)
+	assert.NoError(t, err)
+	assert.Empty(t, images)
+
+	// the mirrors of new dirs are made when their images are upsized, only the upsized root has to exist
+	assert.NoDirExists(t, filepath.Join(dir, "upsized/trip/day1"))
+	mapper.UpsizedRoot = filepath.Join(dir, "missing")
+	_, err = findFilesToUpsize(mapper, 0, geometry.Target{}, nil, nil)
+	assert.Error(t, err)
+}
@@ -7,8 +7,13 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/kmulvey/path"
 	"github.com/kmulvey/realesrgan-scheduler/internal/app/realesrgan/local"
+	"github.com/kmulvey/realesrgan-scheduler/internal/cache"
+	"github.com/kmulvey/realesrgan-scheduler/internal/gpu"
+	"github.com/kmulvey/realesrgan-scheduler/internal/pathmap"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/encode"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/geometry"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/provenance"
 	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
 	log "github.com/sirupsen/logrus"
 
@@ -18,6 +23,8 @@ import (
 
 const promNamespace = "realesrgan_scheduler"
 
+const upsizedRoot = "/home/kmulvey/empyrean/backup/upscayl"
+
 type imageStatus struct {
 	Name     string
 	Size     string
@@ -148,8 +155,55 @@ func (m model) View() string {
 func main() {
 	originalsDir := flag.String("originals", "", "Root directory containing already upsized directories to scan")
 	listOnly := flag.Bool("list-only", false, "List images to upsize without processing them")
+	maxDepth := flag.Int("max-depth", 0, "How many directories deep to look for originals, 0 for no limit")
+	targetSize := flag.String("target", "", "Resample upsized images to fit:WxH, fill:WxH, crop:WxH or long:PIXELS, images already that big are skipped")
+	numGPUs := flag.Int("num-gpus", 0, "How many gpus to use, as ids 0 to num-gpus-1, 0 asks realesrgan which gpus there are")
+	softwareGPUs := flag.Bool("software-gpus", false, "When asking realesrgan for the gpus, also use software renderers like llvmpipe")
+	var gpus gpu.Devices
+	flag.Var(&gpus, "gpu", "Capacity of a gpu as id:vram=MiB,speed=N,jobs=N,max-mp=N, once for each gpu, big images only go to gpus that allow them, by max-mp or 4 megapixels per GiB of vram")
+	passes := flag.Int("passes", 1, "Run realesrgan up to this many times on each image, stopping early once -target is reached")
+	tileSize := flag.Int("tile-size", 0, "Split images wider or taller than this into tiles upsized on any free gpu and stitched back together, 0 never splits")
+	tileOverlap := flag.Int("tile-overlap", local.DefaultTileOverlap, "How many pixels tiles share with their neighbours, blended to hide the seams")
+	tileMaxPixels := flag.Int("tile-max-pixels", local.DefaultTileMaxPixels, "The biggest image, in pixels, that is tiled, tiles are stitched in memory at 4 bytes per upsized pixel")
+	batchSize := flag.Int("batch-size", 0, "Upsize up to this many small images with one run of realesrgan so the model is only loaded once, 0 or 1 upsizes each on its own")
+	batchMaxPixels := flag.Int("batch-max-pixels", local.DefaultBatchMaxPixels, "The biggest image, in pixels, that is upsized in a batch")
+	scratchDir := flag.String("scratch-dir", "", "Where to keep intermediate and prefetched files while upsizing, e.g. a tmpfs or ssd, defaults to the system temp dir")
+	prefetch := flag.Int("prefetch", 0, "Copy this many of the next images to the scratch dir while the gpus are busy and move upsized images out of it in the background, 0 reads and writes them in place")
+	scratchLimit := flag.Int64("scratch-limit", local.DefaultScratchLimit, "Most bytes of prefetched and upsized images kept in the scratch dir")
+	passModels := flag.String("pass-models", "", "Comma separated models for each pass, the last one is used for the passes after it")
+	sidecarFormat := flag.String("sidecar", "none", "Write how each image was upsized next to it: json, xmp or none")
+	var mapper pathmap.Mapper
+	var encoding encode.Options
+	mapper.RegisterFlags(flag.CommandLine)
+	encoding.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
+	mapper.OriginalsRoot = *originalsDir
+	mapper.UpsizedRoot = upsizedRoot
+	if err := mapper.Validate(); err != nil {
+		fmt.Println("invalid output options:", err)
+		os.Exit(1)
+	}
+
+	sidecar, err := provenance.ParseFormat(*sidecarFormat)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	target, err := geometry.Parse(*targetSize)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if encoding.Enabled() {
+		if err := encoding.Validate(); err != nil {
+			fmt.Println("invalid encoding options:", err)
+			os.Exit(1)
+		}
+	}
+
 	// Open log file
 	logFile, err := os.OpenFile("scheduler.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
@@ -164,17 +218,19 @@ func main() {
 		log.Fatal(err)
 	}
 
-	skipImages, err := getSkipFiles("../auto/skipcache")
+	db, err := cache.New("../auto/skipcache")
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer db.Close()
 
-	upsizedDirs, err := path.List("/home/kmulvey/empyrean/backup/upscayl", 2, false, path.NewDirEntitiesFilter())
+	skipImages, err := getSkipFiles(&db)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	images, err := findFilesToUpsize(upsizedDirs, *originalsDir, skipDirs, skipImages)
+	mapper.Owner = cache.Owner(&db)
+	images, err := findFilesToUpsize(mapper, *maxDepth, target, skipDirs, skipImages)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -213,10 +269,36 @@ func main() {
 		}
 	}()
 
-	rl, err := local.NewRealesrganLocal(promNamespace, "/home/kmulvey/src/realesrgan-ncnn-vulkan-20220424-ubuntu/realesrgan-ncnn-vulkan", "realesrgan-x4plus", 2, true, files)
+	rl, err := local.NewRealesrganLocal(promNamespace, "/home/kmulvey/src/realesrgan-ncnn-vulkan-20220424-ubuntu/realesrgan-ncnn-vulkan", "realesrgan-x4plus", uint8(*numGPUs), true, &db, files)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *numGPUs == 0 {
+		if err := rl.DiscoverGPUs(*softwareGPUs); err != nil && len(gpus) == 0 {
+			log.Fatalf("error discovering gpus, set -num-gpus or -gpu instead: %s", err)
+		} else if err != nil {
+			log.Warnf("error discovering gpus, using the ones given with -gpu: %s", err)
+		}
+	}
+	if len(gpus) > 0 {
+		rl.ConfigureGPUs(gpus)
+	}
+	rl.Sidecar = sidecar
+	rl.Alpha = mapper.Alpha
+	rl.Encoding = encoding
+	rl.Target = target
+	rl.Passes = *passes
+	rl.TileSize = *tileSize
+	rl.TileOverlap = *tileOverlap
+	rl.TileMaxPixels = *tileMaxPixels
+	rl.BatchSize = *batchSize
+	rl.BatchMaxPixels = *batchMaxPixels
+	rl.ScratchDir = *scratchDir
+	rl.Prefetch = *prefetch
+	rl.ScratchLimit = *scratchLimit
+	if *passModels != "" {
+		rl.PassModels = strings.Split(*passModels, ",")
+	}
 
 	go func() {
 		err = rl.Run(images...)
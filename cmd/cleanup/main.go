@@ -1,32 +1,46 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 	"go.szostok.io/version"
 	"go.szostok.io/version/printer"
 
 	"github.com/kmulvey/path"
+	"github.com/kmulvey/realesrgan-scheduler/internal/app/realesrgan/local"
+	"github.com/kmulvey/realesrgan-scheduler/internal/cache"
+	"github.com/kmulvey/realesrgan-scheduler/internal/fs"
+	"github.com/kmulvey/realesrgan-scheduler/internal/pathmap"
 	"github.com/kmulvey/realesrgan-scheduler/internal/pkg/ignoreregex"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/provenance"
 )
 
 func main() {
 	var originalImages path.Entry
 	var upscaledImagesDir path.Entry
 	var skipFile string
+	var cacheDir path.Entry
 	var dryRun, v, h bool
+	var maxDepth int
+	var mapper pathmap.Mapper
 
 	flag.Var(&originalImages, "originals-dir", "")
 	flag.Var(&upscaledImagesDir, "upscaled-dir", "")
 	flag.StringVar(&skipFile, "skip-file", "", "file with directories to skip, one per line")
+	flag.Var(&cacheDir, "cache-dir", "the cache of the upsizer, to tell which original an upsized image without a sidecar was made from")
 	flag.BoolVar(&dryRun, "dry-run", false, "")
+	flag.IntVar(&maxDepth, "max-depth", 0, "how many directories deep to look, 0 for no limit")
 	flag.BoolVar(&v, "version", false, "print version")
 	flag.BoolVar(&v, "v", false, "print version")
 	flag.BoolVar(&h, "help", false, "print options")
+	mapper.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
 	if h {
@@ -43,61 +57,131 @@ func main() {
 		os.Exit(0)
 	}
 
-	var upscaledImages, err = path.List(upscaledImagesDir.AbsolutePath, 2, false, path.NewDirEntitiesFilter())
-	if err != nil {
-		log.Fatalf("error getting existing upsized dirs: %s", err)
+	mapper.OriginalsRoot = originalImages.AbsolutePath
+	mapper.UpsizedRoot = upscaledImagesDir.AbsolutePath
+	if err := mapper.Validate(); err != nil {
+		log.Fatalf("invalid output options: %s", err)
 	}
 
-	for _, dir := range upscaledImages {
+	var db cache.Store = cache.NewMemory()
+	if cacheDir.String() != "" {
+		// when the daemon is running this talks to it over its control socket
+		var err error
+		if db, err = cache.Open(cacheDir.String()); err != nil {
+			log.Fatalf("error opening cache: %s", err)
+		}
+	}
+	mapper.Owner = cache.Owner(db)
 
-		var upsizedBase = filepath.Base(dir.AbsolutePath)
-		var leafDir = filepath.Join(originalImages.AbsolutePath, upsizedBase)
+	var skipRegex *regexp.Regexp
+	if len(skipFile) > 0 {
+		var err error
+		if skipRegex, err = ignoreregex.SkipFileToRegexp(skipFile); err != nil {
+			log.Fatalf("error creating regex to skip dirs: %s", err)
+		}
+	}
 
-		if len(skipFile) > 0 {
-			var re, err = ignoreregex.SkipFileToRegexp(skipFile)
-			if err != nil {
-				log.Errorf("error creating regex to skip dirs: %s", err)
-				continue
-			}
+	originalFiles, err := fs.Walk(mapper.OriginalsRoot, maxDepth, nil)
+	if err != nil {
+		log.Fatalf("error listing original dir: %s", err)
+	}
 
-			if re.MatchString(leafDir) {
-				log.Infof("skipping %s ...", dir.FileInfo.Name())
+	upscaledFiles, err := fs.Walk(mapper.UpsizedRoot, maxDepth, nil)
+	if err != nil {
+		log.Fatalf("error listing upsized dir: %s", err)
+	}
+
+	var checkFiles = make([]path.Entry, 0, len(upscaledFiles))
+	var sidecars []path.Entry
+	for _, file := range upscaledFiles {
+		// the skip file lists original dirs, so match against the original dir this one mirrors
+		if skipRegex != nil {
+			var rel, err = filepath.Rel(mapper.UpsizedRoot, filepath.Dir(file.AbsolutePath))
+			if err == nil && skipRegex.MatchString(filepath.Join(mapper.OriginalsRoot, rel)) {
 				continue
 			}
 		}
-
-		var baseDir = filepath.Base(dir.AbsolutePath)
-		originalfiles, err := path.List(filepath.Join(originalImages.AbsolutePath, baseDir), 2, false, path.NewFileEntitiesFilter())
-		if err != nil {
-			log.Errorf("error listing original dir: %s", err)
+		if _, ok := provenance.IsSidecar(file.AbsolutePath); ok {
+			sidecars = append(sidecars, file)
 			continue
 		}
-
-		upsizedfiles, err := path.List(dir.AbsolutePath, 2, false, path.NewFileEntitiesFilter())
-		if err != nil {
-			log.Errorf("error listing upsized dir: %s", err)
+		// hidden files are reprocess staging files and encode candidates of images that are still being worked on,
+		// and anything that is not an image is the user's
+		if strings.HasPrefix(filepath.Base(file.AbsolutePath), ".") || !fs.IsImage(file) {
 			continue
 		}
-		processDir(originalfiles, upsizedfiles, dryRun)
+		checkFiles = append(checkFiles, file)
+	}
+
+	// with colliding originals it is not known which upsized image belongs to which, so do not delete anything
+	index, err := mapper.Index(path.OnlyNames(originalFiles))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	processDir(db, index, originalFiles, checkFiles, dryRun)
+	processSidecars(sidecars, dryRun)
+
+	if err := db.Close(); err != nil {
+		log.Errorf("error closing cache: %s", err)
 	}
 }
 
-func processDir(originalImages, upscaledImages []path.Entry, dryRun bool) {
-	var originalsMap = make(map[string]struct{})
+// processDir deletes the upscaled images that index does not map any of the original images to, and their sidecars.
+// Old versions kept by -keep-old-versions stay as long as their current version has an original, db and their sidecars
+// tell which files are old versions.
+func processDir(db cache.Store, index *pathmap.Index, originalImages, upscaledImages []path.Entry, dryRun bool) {
+	var upsizedMap = make(map[string]struct{})
 	for _, image := range originalImages {
-		originalsMap[filepath.Base(image.String())] = struct{}{}
+		if upsized, err := index.Map(image.AbsolutePath); err == nil {
+			upsizedMap[upsized] = struct{}{}
+		}
+		// a transparent original may have been written to its AlphaPath, which only counts when it was made from it
+		if existing, ok := index.Existing(image.AbsolutePath); ok {
+			upsizedMap[existing] = struct{}{}
+		}
 	}
 	for _, upscaledImage := range upscaledImages {
-		if _, found := originalsMap[filepath.Base(upscaledImage.AbsolutePath)]; !found {
+		if _, found := upsizedMap[local.OldVersionOf(db, upscaledImage.AbsolutePath)]; found {
+			continue
+		}
+		if _, found := upsizedMap[upscaledImage.AbsolutePath]; !found {
+			var from string
+			if record, err := provenance.Read(upscaledImage.AbsolutePath); err == nil {
+				from = fmt.Sprintf("(upscaled from %s with %s) ", record.SourceFile, record.ModelName)
+			}
+
 			if !dryRun {
 				var err = os.Remove(upscaledImage.AbsolutePath)
 				if err != nil {
 					log.Fatal(err)
 				}
-				fmt.Printf("deleted: %s \n", upscaledImage.AbsolutePath)
+				if err := provenance.Remove(upscaledImage.AbsolutePath); err != nil {
+					log.Fatal(err)
+				}
+				fmt.Printf("deleted: %s %s\n", upscaledImage.AbsolutePath, from)
 			} else {
-				fmt.Printf("would deleted: %s \n", upscaledImage.AbsolutePath)
+				fmt.Printf("would deleted: %s %s\n", upscaledImage.AbsolutePath, from)
+			}
+		}
+	}
+}
+
+// processSidecars deletes the sidecars whose upscaled image is gone, including the ones processDir just deleted.
+func processSidecars(sidecars []path.Entry, dryRun bool) {
+	for _, sidecar := range sidecars {
+		var image, _ = provenance.IsSidecar(sidecar.AbsolutePath)
+		if _, err := os.Stat(image); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if !dryRun {
+			if err := os.Remove(sidecar.AbsolutePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Fatal(err)
 			}
+			fmt.Printf("deleted orphaned sidecar: %s \n", sidecar.AbsolutePath)
+		} else {
+			fmt.Printf("would deleted orphaned sidecar: %s \n", sidecar.AbsolutePath)
 		}
 	}
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kmulvey/path"
+	"github.com/kmulvey/realesrgan-scheduler/internal/cache"
+	"github.com/kmulvey/realesrgan-scheduler/internal/pathmap"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/provenance"
+	"github.com/kmulvey/realesrgan-scheduler/testimages"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessDirVersions(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var mapper = pathmap.Mapper{OriginalsRoot: filepath.Join(dir, "originals"), UpsizedRoot: filepath.Join(dir, "upscaled")}
+	assert.NoError(t, mapper.Validate())
+	assert.NoError(t, os.MkdirAll(mapper.OriginalsRoot, 0750))
+	assert.NoError(t, os.MkdirAll(mapper.UpsizedRoot, 0750))
+
+	var original = filepath.Join(mapper.OriginalsRoot, "IMG_1.jpg")
+	assert.NoError(t, os.WriteFile(original, testimages.FoxJPG, 0600))
+
+	var upsized = make([]path.Entry, 0, 4)
+	for _, name := range []string{"IMG_1.jpg", "IMG_1.realesrgan-x4plus.jpg", "IMG_1.realesr-animevideov3.jpg", "IMG_1.edited.jpg"} {
+		var file = filepath.Join(mapper.UpsizedRoot, name)
+		assert.NoError(t, os.WriteFile(file, testimages.FoxJPG, 0600))
+		var entry, err = path.NewEntry(file, 0)
+		assert.NoError(t, err)
+		upsized = append(upsized, entry)
+	}
+
+	// one old version is known by its sidecar, the other by the cache
+	assert.NoError(t, provenance.Write(filepath.Join(mapper.UpsizedRoot, "IMG_1.realesrgan-x4plus.jpg"), provenance.JSON, provenance.Record{SourceFile: original, ModelName: "realesrgan-x4plus"}))
+	var db = cache.NewMemory()
+	assert.NoError(t, db.RecordOutput(filepath.Join(mapper.UpsizedRoot, "IMG_1.realesr-animevideov3.jpg"), cache.Output{SourceFile: original, ModelName: "realesr-animevideov3", UpsizedAt: time.Now()}))
+
+	originalEntry, err := path.NewEntry(original, 0)
+	assert.NoError(t, err)
+	index, err := mapper.Index([]string{original})
+	assert.NoError(t, err)
+
+	processDir(db, index, []path.Entry{originalEntry}, upsized, false)
+
+	assert.FileExists(t, filepath.Join(mapper.UpsizedRoot, "IMG_1.jpg"))
+	assert.FileExists(t, filepath.Join(mapper.UpsizedRoot, "IMG_1.realesrgan-x4plus.jpg"))
+	assert.FileExists(t, filepath.Join(mapper.UpsizedRoot, "IMG_1.realesr-animevideov3.jpg"))
+	// the dot in its name is not a model, so it has no original and goes
+	assert.NoFileExists(t, filepath.Join(mapper.UpsizedRoot, "IMG_1.edited.jpg"))
+}
@@ -1,72 +1,116 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"path/filepath"
-	"regexp"
+	"os"
+	"time"
 
 	"github.com/kmulvey/path"
 	"github.com/kmulvey/realesrgan-scheduler/internal/app/realesrgan/local"
+	"github.com/kmulvey/realesrgan-scheduler/internal/cache"
+	"github.com/kmulvey/realesrgan-scheduler/internal/fs"
+	"github.com/kmulvey/realesrgan-scheduler/internal/pathmap"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/provenance"
 )
 
-var jpgRe = regexp.MustCompile(`.*\.jpg$`)
-
 func main() {
-	var upscayl, documents string
-	flag.StringVar(&upscayl, "original-images-dir", "", "path to the original (input) images")
-	flag.StringVar(&documents, "upscaled-images-dir", "", "where to store the upscaled images")
+	var mapper pathmap.Mapper
+	var maxDepth int
+	var cacheDir string
+	flag.StringVar(&mapper.OriginalsRoot, "original-images-dir", "", "path to the original (input) images")
+	flag.StringVar(&mapper.UpsizedRoot, "upscaled-images-dir", "", "where to store the upscaled images")
+	flag.IntVar(&maxDepth, "max-depth", 0, "how many directories deep to compare, 0 for no limit")
+	flag.StringVar(&cacheDir, "cache-dir", "", "the cache of the upsizer, to tell which original an upsized image without a sidecar was made from")
+	mapper.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	photoDirs, err := path.List(upscayl, 1, false, path.NewDirEntitiesFilter())
+	if err := mapper.Validate(); err != nil {
+		fmt.Println("invalid options:", err)
+		os.Exit(1)
+	}
+
+	var db cache.Store = cache.NewMemory()
+	if cacheDir != "" {
+		var err error
+		if db, err = cache.Open(cacheDir); err != nil {
+			fmt.Println("error opening cache:", err)
+			os.Exit(1)
+		}
+	}
+	defer db.Close()
+	mapper.Owner = cache.Owner(db)
+
+	originals, err := fs.Walk(mapper.OriginalsRoot, maxDepth, fs.IsImage)
+	if err != nil {
+		panic(err)
+	}
+
+	upsizedFiles, err := fs.Walk(mapper.UpsizedRoot, maxDepth, fs.IsImage)
 	if err != nil {
 		panic(err)
 	}
 
-	upscaylDirs := getDirImages(upscayl, photoDirs)
-	documentsDirs := getDirImages(documents, photoDirs)
+	index, err := mapper.Index(path.OnlyNames(originals))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var upsized = make(map[string]path.Entry, len(upsizedFiles))
+	for _, entry := range upsizedFiles {
+		upsized[entry.AbsolutePath] = entry
+	}
 
 	var diffs = make(map[string]path.Entry)
 
-	for dirName := range upscaylDirs {
-		if _, ok := documentsDirs[dirName]; !ok {
-			fmt.Println("documents missing dir: ", dirName)
+	for _, original := range originals {
+		var upsizedFile, err = index.Map(original.AbsolutePath)
+		if err != nil {
+			continue
+		}
+		if existing, ok := index.Existing(original.AbsolutePath); ok {
+			upsizedFile = existing
+		}
+
+		if _, ok := upsized[upsizedFile]; !ok {
+			fmt.Printf("upscaled missing file: %s\n", upsizedFile)
+			diffs[original.String()] = original
 		} else {
-			for fileName, fileEntry := range upscaylDirs[dirName] {
-				if _, ok := documentsDirs[dirName][fileName]; !ok {
-					fmt.Printf("documents/%s missing file: %s\n", dirName, fileName)
-					diffs[fileEntry.String()] = fileEntry
-				} else {
-					delete(documentsDirs[dirName], fileName)
-				}
-			}
-
-			for _, fileEntry := range documentsDirs[dirName] {
-				diffs[fileEntry.String()] = fileEntry
-			}
+			delete(upsized, upsizedFile)
+			reportStale(original.AbsolutePath, upsizedFile)
 		}
 	}
 
+	// whatever is left has no original
+	for _, fileEntry := range upsized {
+		diffs[fileEntry.String()] = fileEntry
+	}
+
 	for filename, entry := range diffs {
 		fmt.Printf("Size: %s, Name: %s\n", local.PrettyPrintFileSizes(entry.FileInfo.Size()), filename)
 	}
 }
 
-func getDirImages(baseDir string, dirEntries []path.Entry) map[string]map[string]path.Entry {
-	var dirs = make(map[string]map[string]path.Entry, len(dirEntries))
-
-	for _, dir := range dirEntries {
-		var files = make(map[string]path.Entry)
-		dirJpgs, err := path.List(filepath.Join(baseDir, dir.FileInfo.Name()), 2, false, path.NewRegexEntitiesFilter(jpgRe))
-		if err != nil {
-			continue
-		}
+// reportStale prints when the sidecar of upsizedFile says it was made from something other than original as it is now.
+func reportStale(original, upsizedFile string) {
+	var record, err = provenance.Read(upsizedFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		fmt.Println(err)
+		return
+	}
 
-		for _, entry := range dirJpgs {
-			files[entry.FileInfo.Name()] = entry
-		}
+	if record.SourceFile != original {
+		fmt.Printf("upscaled from a different original: %s, sidecar says: %s\n", upsizedFile, record.SourceFile)
+		return
+	}
 
-		dirs[dir.FileInfo.Name()] = files
+	if changed, err := record.SourceChanged(); err != nil {
+		fmt.Println(err)
+	} else if changed {
+		fmt.Printf("original changed since it was upscaled on %s: %s\n", record.UpsizedAt.Format(time.DateTime), original)
 	}
-	return dirs
 }
@@ -3,14 +3,25 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/kmulvey/path"
 	"github.com/kmulvey/realesrgan-scheduler/internal/app/realesrgan/local"
+	"github.com/kmulvey/realesrgan-scheduler/internal/cache"
 	"github.com/kmulvey/realesrgan-scheduler/internal/fs"
+	"github.com/kmulvey/realesrgan-scheduler/internal/gpu"
+	"github.com/kmulvey/realesrgan-scheduler/internal/pathmap"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/encode"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/geometry"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/metadata"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/provenance"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"go.szostok.io/version"
@@ -41,19 +52,49 @@ func main() {
 
 	// get the user options
 	var originalImages, upscaledImages, cacheDir path.Entry
-	var realesrganPath string
-	var daemon, removeOriginals, h, ver bool
-	var numGPUs int
+	var realesrganPath, modelName, scratchDir, heifDecoder, preserveMetadata, sidecarFormat, targetSize, passModels string
+	var daemon, removeOriginals, reprocess, reprocessUntracked, keepOldVersions, softwareGPUs, h, ver bool
+	var numGPUs, maxDepth, passes, tileSize, tileOverlap, tileMaxPixels, batchSize, batchMaxPixels, prefetch int
+	var scratchLimit int64
+	var cacheGCInterval time.Duration
+	// the flat .jpg names are what this has always written, so existing libraries are still found upsized
+	var mapper = pathmap.Mapper{Ext: ".jpg", Layout: pathmap.Flatten}
+	var gpus gpu.Devices
+	var encoding encode.Options
 
 	flag.Var(&originalImages, "original-images-dir", "path to the original (input) images")
 	flag.Var(&upscaledImages, "upscaled-images-dir", "where to store the upscaled images")
 	flag.Var(&cacheDir, "cache-dir", "where to store the cache file for failed upsizes")
 	flag.StringVar(&realesrganPath, "realesrgan-path", "realesrgan-ncnn-vulkan", "where the realesrgan binary is")
+	flag.StringVar(&modelName, "model-name", "realesrgan-x4plus", "the realesrgan model to upsize with")
+	flag.StringVar(&scratchDir, "scratch-dir", "", "where to keep intermediate files while upsizing, defaults to the system temp dir")
+	flag.StringVar(&heifDecoder, "heif-decoder", local.DefaultHEIFDecoder, "the libheif tool used to convert avif and heic images, run as: heif-decoder input output.png")
+	flag.StringVar(&preserveMetadata, "preserve-metadata", "all", "what to copy from the originals to the upsized images, a comma separated list of: exif, xmp, icc, mtime, atime, all or none")
+	flag.StringVar(&targetSize, "target", "", "resample upsized images to a size: fit:3840x2160, fill:3840x2160, crop:3840x2160 or long:6000 for the long edge, images already that big are skipped")
+	flag.IntVar(&passes, "passes", 1, "run realesrgan up to this many times on each image, each time on the last output, stops early once -target is reached")
+	flag.IntVar(&tileSize, "tile-size", 0, "split images wider or taller than this into tiles that are upsized on any free gpu and stitched back together, 0 never splits")
+	flag.IntVar(&tileOverlap, "tile-overlap", local.DefaultTileOverlap, "how many pixels tiles share with their neighbours, blended to hide the seams")
+	flag.IntVar(&tileMaxPixels, "tile-max-pixels", local.DefaultTileMaxPixels, "the biggest image, in pixels, that is tiled, tiles are stitched in memory at 4 bytes per upsized pixel")
+	flag.IntVar(&batchSize, "batch-size", 0, "upsize up to this many small images with one run of realesrgan so the model is only loaded once, 0 or 1 upsizes each on its own")
+	flag.IntVar(&batchMaxPixels, "batch-max-pixels", local.DefaultBatchMaxPixels, "the biggest image, in pixels, that is upsized in a batch")
+	flag.IntVar(&prefetch, "prefetch", 0, "copy this many of the next images to the scratch dir while the gpus are busy and move upsized images out of it in the background, 0 reads and writes them in place")
+	flag.Int64Var(&scratchLimit, "scratch-limit", local.DefaultScratchLimit, "most bytes of prefetched and upsized images kept in the scratch dir")
+	flag.StringVar(&passModels, "pass-models", "", "comma separated models for each pass, the last one is used for the passes after it, defaults to -model-name")
+	flag.StringVar(&sidecarFormat, "sidecar", "none", "write how each image was upsized to a file next to it: json, xmp or none")
 	flag.BoolVar(&removeOriginals, "remove-originals", false, "delete original images after upsizing")
+	flag.BoolVar(&reprocess, "reprocess", false, "upsize images again that were upsized with a different model")
+	flag.BoolVar(&reprocessUntracked, "reprocess-untracked", false, "with -reprocess, also upsize images whose model was never recorded")
+	flag.BoolVar(&keepOldVersions, "keep-old-versions", false, "with -reprocess, keep the old upsized image with its model in the file name")
 	flag.BoolVar(&daemon, "d", false, "run as a daemon (does not quit)")
-	flag.IntVar(&numGPUs, "num-gpus", 1, "how many gpus to use")
+	flag.IntVar(&numGPUs, "num-gpus", 0, "how many gpus to use, as ids 0 to num-gpus-1, 0 asks realesrgan which gpus there are")
+	flag.BoolVar(&softwareGPUs, "software-gpus", false, "when asking realesrgan for the gpus, also use software renderers like llvmpipe")
+	flag.Var(&gpus, "gpu", "capacity of a gpu as id:vram=MiB,speed=N,jobs=N,max-mp=N, once for each gpu, big images only go to gpus that allow them, by max-mp or 4 megapixels per GiB of vram")
+	flag.IntVar(&maxDepth, "max-depth", 0, "how many directories deep to look for originals, 1 is only the originals dir, 0 for no limit")
+	flag.DurationVar(&cacheGCInterval, "cache-gc-interval", 0, "how often the daemon cleans up and compacts the cache, 0 to never")
 	flag.BoolVar(&ver, "version", false, "print version")
 	flag.BoolVar(&h, "help", false, "print options")
+	mapper.RegisterFlags(flag.CommandLine)
+	encoding.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
 	if h {
@@ -70,36 +111,214 @@ func main() {
 		os.Exit(0)
 	}
 
-	log.Infof("Config: originalImages: %s, upscaledImages: %s, realesrganPath: %s, cacheDir: %s, removeOriginals: %t, daemon: %t",
+	mapper.OriginalsRoot = originalImages.String()
+	mapper.UpsizedRoot = upscaledImages.String()
+	if err := mapper.Validate(); err != nil {
+		log.Fatalf("invalid output options: %s", err)
+	}
+
+	preserve, err := metadata.ParseFields(preserveMetadata)
+	if err != nil {
+		log.Fatalf("invalid -preserve-metadata: %s", err)
+	}
+
+	sidecar, err := provenance.ParseFormat(sidecarFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	target, err := geometry.Parse(targetSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if encoding.Enabled() {
+		if err := encoding.Validate(); err != nil {
+			log.Fatalf("invalid encoding options: %s", err)
+		}
+	}
+
+	log.Infof("Config: originalImages: %s, upscaledImages: %s, realesrganPath: %s, modelName: %s, cacheDir: %s, removeOriginals: %t, reprocess: %t, daemon: %t",
 		originalImages.String(),
 		upscaledImages.String(),
 		realesrganPath,
+		modelName,
 		cacheDir.String(),
 		removeOriginals,
+		reprocess,
 		daemon)
 
-	var images, err = path.List(originalImages.String(), 1, false, path.NewRegexEntitiesFilter(fs.ImageExtensionRegex))
+	images, err := fs.Walk(originalImages.String(), maxDepth, fs.IsImage)
 	if err != nil {
-		log.Fatalf("error getting existing upsized dirs: %s", err)
+		log.Fatalf("error finding original images: %s", err)
 	}
 
-	rl, err := local.NewRealesrganLocal(promNamespace, cacheDir.String(), realesrganPath, upscaledImages.String(), numGPUs, removeOriginals, daemon)
+	// without a cache dir nothing is persisted, which is handy for a dry run
+	var db cache.Store = cache.NewMemory()
+	if cacheDir.String() != "" {
+		badgerDB, err := cache.New(cacheDir.String())
+		if err != nil {
+			log.Fatalf("error opening cache: %s", err)
+		}
+		db = &badgerDB
+	}
+	defer db.Close()
+
+	// two originals must never be upsized to the same file
+	mapper.Owner = cache.Owner(db)
+	index, err := mapper.Index(path.OnlyNames(images))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var files = make(chan *realesrgan.ImageConfig)
+	go logProgress(files)
+
+	rl, err := local.NewRealesrganLocal(promNamespace, realesrganPath, modelName, uint8(numGPUs), removeOriginals, db, files)
 	if err != nil {
 		log.Fatalf("error in: NewRealesrganLocal %s", err)
 	}
+	if numGPUs == 0 {
+		if err := rl.DiscoverGPUs(softwareGPUs); err != nil && len(gpus) == 0 {
+			log.Fatalf("error discovering gpus, set -num-gpus or -gpu instead: %s", err)
+		} else if err != nil {
+			log.Warnf("error discovering gpus, using the ones given with -gpu: %s", err)
+		}
+	}
+	if len(gpus) > 0 {
+		rl.ConfigureGPUs(gpus)
+	}
+	rl.KeepOldVersions = keepOldVersions
+	rl.ScratchDir = scratchDir
+	rl.HEIFDecoder = heifDecoder
+	rl.Preserve = preserve
+	rl.Sidecar = sidecar
+	rl.Alpha = mapper.Alpha
+	rl.Encoding = encoding
+	rl.Target = target
+	rl.Passes = passes
+	rl.TileSize = tileSize
+	rl.TileOverlap = tileOverlap
+	rl.TileMaxPixels = tileMaxPixels
+	rl.BatchSize = batchSize
+	rl.BatchMaxPixels = batchMaxPixels
+	rl.Prefetch = prefetch
+	rl.ScratchLimit = scratchLimit
+	if passModels != "" {
+		rl.PassModels = strings.Split(passModels, ",")
+	}
+
+	if reprocess {
+		for _, image := range images {
+			if !index.Exists(image.AbsolutePath) {
+				continue
+			}
+
+			img, err := newImageConfig(image, index)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+
+			needsReprocess, err := rl.NeedsReprocess(img.UpsizedFile, reprocessUntracked)
+			if err != nil {
+				log.Fatalf("error looking up model for %s: %s", img.UpsizedFile, err)
+			}
+
+			if needsReprocess {
+				if err := rl.AddReprocessImage(img); err != nil {
+					log.Fatalf("error adding image to queue: %s", err)
+				}
+			}
+		}
+
+		if err := rl.Run(); err != nil {
+			log.Errorf("error in Run(): %s", err)
+		}
+		cancel()
+		return
+	}
+
+	// load up existing images
+	for _, image := range images {
+		if index.Exists(image.AbsolutePath) || db.Contains(image) || local.MeetsTarget(image.AbsolutePath, target) {
+			continue
+		}
+
+		img, err := newImageConfig(image, index)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+
+		if err := rl.AddImage(img); err != nil {
+			log.Fatalf("error adding image to queue: %s", err)
+		}
+	}
 
 	if daemon {
+		if cacheGCInterval > 0 {
+			go gcCache(ctx, db, cacheGCInterval)
+		}
+
 		var watchEvents = make(chan path.WatchEvent)
-		go rl.Watch(watchEvents)
+		var retries = make(chan path.Entry)
+		var newImages = make(chan *realesrgan.ImageConfig)
+		go func() {
+			defer close(newImages)
+			for {
+				select {
+				case event, open := <-watchEvents:
+					if !open {
+						return
+					}
+					// like the walk at startup, images are found by their content whatever their name
+					if !fs.WithinDepth(mapper.OriginalsRoot, event.Entry.AbsolutePath, maxDepth) || !fs.IsImage(event.Entry) || index.Exists(event.Entry.AbsolutePath) || db.Contains(event.Entry) || local.MeetsTarget(event.Entry.AbsolutePath, target) {
+						continue
+					}
+					if img, err := newImageConfig(event.Entry, index); err != nil {
+						log.Error(err)
+					} else {
+						newImages <- img
+					}
 
-		// load up existing images
-		for _, image := range images {
-			err = rl.AddImage(image)
-			if err != nil {
-				log.Fatalf("error adding image to queue: %s", err)
+				case entry := <-retries:
+					if img, err := newImageConfig(entry, index); err != nil {
+						log.Error(err)
+					} else {
+						newImages <- img
+					}
+				}
+			}
+		}()
+
+		// badger locks the cache dir, so managecache goes through us while we are running
+		if cacheDir.String() != "" {
+			var retry = func(images []string) error {
+				for _, image := range images {
+					var entry, err = path.NewEntry(image, 0)
+					if err != nil {
+						return fmt.Errorf("error retrying image: %s, err: %w", image, err)
+					}
+
+					select {
+					case retries <- entry:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				return nil
 			}
+
+			go func() {
+				if err := cache.Serve(ctx, db, cache.SocketPath(cacheDir.String()), retry); err != nil {
+					log.Errorf("error serving cache control socket: %s", err)
+				}
+			}()
 		}
 
+		go rl.Watch(newImages)
+
 		var errors = make(chan error)
 		go func() {
 			for err := range errors {
@@ -107,18 +326,10 @@ func main() {
 			}
 		}()
 
-		path.WatchDir(ctx, originalImages.String(), 2, false, watchEvents, errors, path.NewOpWatchFilter(fsnotify.Create), path.NewRegexWatchFilter(fs.ImageExtensionRegex))
+		path.WatchDir(ctx, originalImages.String(), path.MaxDepth, false, watchEvents, errors, path.NewOpWatchFilter(fsnotify.Create))
 
 	} else {
-		// load up existing images
-		for _, image := range images {
-			err = rl.AddImage(image)
-			if err != nil {
-				log.Fatalf("error adding image to queue: %s", err)
-			}
-		}
-
-		err = rl.Run(nil) // images were already added above
+		err = rl.Run() // images were already added above
 		if err != nil {
 			log.Errorf("error in Run(): %s", err)
 		}
@@ -126,3 +337,54 @@ func main() {
 
 	cancel()
 }
+
+// newImageConfig builds the config to upsize the given original to where index puts it.
+func newImageConfig(image path.Entry, index *pathmap.Index) (*realesrgan.ImageConfig, error) {
+	var source, err = imagetype.Sniff(image.AbsolutePath)
+	if err != nil {
+		return nil, err
+	}
+
+	upsizedFile, err := index.Resolve(image.AbsolutePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &realesrgan.ImageConfig{
+		SourceFile:  image.AbsolutePath,
+		UpsizedFile: upsizedFile,
+		Source:      source,
+	}, nil
+}
+
+// gcCache periodically removes stale entries from the cache until ctx is done.
+func gcCache(ctx context.Context, db cache.Store, interval time.Duration) {
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var stats, err = cache.GC(db)
+			if err != nil {
+				log.Errorf("error running cache gc: %s", err)
+				continue
+			}
+			log.Infof("cache gc removed %d entries and reclaimed %d bytes", stats.Entries, stats.ReclaimedBytes)
+		}
+	}
+}
+
+// logProgress logs each image as it starts and drains its progress so the upsize is never blocked.
+func logProgress(files chan *realesrgan.ImageConfig) {
+	for f := range files {
+		log.Infof("processing file: %s, remaining: %d", f.SourceFile, f.Remaining)
+		go func(f *realesrgan.ImageConfig) {
+			for pct := range f.Progress {
+				log.Debugf("%s: %s", f.SourceFile, pct)
+			}
+		}(f)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kmulvey/realesrgan-scheduler/internal/cache"
+	log "github.com/sirupsen/logrus"
+)
+
+// bulk actions that can be run on every image matching a filter.
+const (
+	actionList   = "list"
+	actionRemove = "remove"
+	actionRetry  = "retry"
+	actionExport = "export"
+)
+
+var errAborted = errors.New("aborted, nothing was changed")
+
+// bulkOptions selects images with filter and runs action on them.
+type bulkOptions struct {
+	filter cache.Filter
+	action string
+	dryRun bool
+	yes    bool
+}
+
+// bulk runs the action on every matching image. Destructive actions print what they would do when dryRun is set
+// and otherwise ask for the number of matches to be typed back on in, unless yes is set.
+func bulk(db cache.Store, opts bulkOptions, in io.Reader, out io.Writer) error {
+
+	var matches, err = cache.Select(db, opts.filter)
+	if err != nil {
+		return err
+	}
+
+	switch opts.action {
+	case actionList:
+		for _, failure := range matches {
+			fmt.Fprintf(out, "%s\ttype: %s\tattempts: %d\tlast: %s\n", failure.Path, failure.Type(), len(failure.Attempts), formatTime(failure.LastAttempt()))
+		}
+		return nil
+
+	case actionExport:
+		var encoder = json.NewEncoder(out)
+		for i := range matches {
+			if err := encoder.Encode(cache.Record{Failure: &matches[i]}); err != nil {
+				return fmt.Errorf("error writing record for %s: %w", matches[i].Path, err)
+			}
+		}
+		return nil
+
+	case actionRemove, actionRetry:
+		if opts.dryRun {
+			for _, failure := range matches {
+				fmt.Fprintf(out, "would %s: %s\n", opts.action, failure.Path)
+			}
+			fmt.Fprintf(out, "%d images matched\n", len(matches))
+			return nil
+		}
+
+		if len(matches) == 0 {
+			fmt.Fprintln(out, "no images matched")
+			return nil
+		}
+
+		if !opts.yes {
+			if err := confirm(in, out, opts.action, len(matches)); err != nil {
+				return err
+			}
+		}
+
+		var images = make([]string, len(matches))
+		for i, failure := range matches {
+			if err := db.RemoveImage(failure.Path); err != nil {
+				return fmt.Errorf("error removing image: %s from cache, err: %w", failure.Path, err)
+			}
+			images[i] = failure.Path
+		}
+		log.Infof("removed %d images from the cache", len(images))
+
+		if opts.action == actionRetry {
+			if retrier, ok := db.(cache.Retrier); ok {
+				if err := retrier.Retry(images); err != nil {
+					return fmt.Errorf("error asking the daemon to retry: %w", err)
+				}
+				log.Infof("queued %d images on the daemon", len(images))
+			} else {
+				log.Info("the daemon is not running, the images will be retried on the next run")
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown action: %s, must be one of: %s, %s, %s, %s", opts.action, actionList, actionRemove, actionRetry, actionExport)
+}
+
+// confirm asks for the number of images to be typed back before a destructive action.
+func confirm(in io.Reader, out io.Writer, action string, count int) error {
+
+	fmt.Fprintf(out, "%s %d images? type %d to confirm: ", action, count, count)
+
+	var answer, err = bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error reading confirmation: %w", err)
+	}
+
+	if strings.TrimSpace(answer) != strconv.Itoa(count) {
+		return errAborted
+	}
+
+	return nil
+}
+
+// parseTime accepts a date or an RFC3339 timestamp, an empty string is the zero time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, nil
+	}
+
+	var t, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %s, use %s or %s", value, time.DateOnly, time.RFC3339)
+	}
+	return t, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kmulvey/path"
+	"github.com/kmulvey/realesrgan-scheduler/internal/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulk(t *testing.T) {
+	t.Parallel()
+
+	var db = cache.NewMemory()
+	for _, image := range []string{"/photos/a.jpg", "/photos/b.jpg", "/photos/c.png"} {
+		assert.NoError(t, db.RecordFailure(path.Entry{AbsolutePath: image}, "segmentation fault"))
+	}
+
+	var opts = bulkOptions{filter: cache.Filter{Glob: "/photos/*.jpg"}, action: actionRemove, dryRun: true}
+	var out bytes.Buffer
+	assert.NoError(t, bulk(db, opts, strings.NewReader(""), &out))
+	assert.Contains(t, out.String(), "would remove: /photos/a.jpg")
+	assert.Contains(t, out.String(), "2 images matched")
+	assert.True(t, db.Contains(path.Entry{AbsolutePath: "/photos/a.jpg"}))
+
+	opts.dryRun = false
+	assert.ErrorIs(t, bulk(db, opts, strings.NewReader("3\n"), &out), errAborted)
+	assert.True(t, db.Contains(path.Entry{AbsolutePath: "/photos/a.jpg"}))
+
+	assert.NoError(t, bulk(db, opts, strings.NewReader("2\n"), &out))
+	assert.False(t, db.Contains(path.Entry{AbsolutePath: "/photos/a.jpg"}))
+	assert.False(t, db.Contains(path.Entry{AbsolutePath: "/photos/b.jpg"}))
+	assert.True(t, db.Contains(path.Entry{AbsolutePath: "/photos/c.png"}))
+
+	out.Reset()
+	assert.NoError(t, bulk(db, bulkOptions{action: actionExport}, strings.NewReader(""), &out))
+	assert.Equal(t, 1, strings.Count(out.String(), "\n"))
+	assert.Contains(t, out.String(), "/photos/c.png")
+
+	assert.Error(t, bulk(db, bulkOptions{action: "rename"}, strings.NewReader(""), &out))
+}
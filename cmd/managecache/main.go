@@ -4,8 +4,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -18,15 +20,32 @@ import (
 func main() {
 	// get the user options
 	var cacheDir path.Entry
-	var searchTerm, addImage, removeImage string
-	var listKeys, purge, h, ver bool
+	var searchTerm, addImage, removeImage, exportFile, importFile, mergePolicy string
+	var matchRegex, since, until, action string
+	var bulkOpts bulkOptions
+	var listKeys, purge, gc, schemaVersion, h, ver bool
 
 	flag.Var(&cacheDir, "cache-dir", "where to store the cache file for failed upsizes")
 	flag.StringVar(&searchTerm, "search", "", "search term")
 	flag.StringVar(&addImage, "add-image", "", "image to add to cache")
 	flag.StringVar(&removeImage, "remove-image", "", "remove image from cache")
+	flag.StringVar(&exportFile, "export", "", "write the cache to this file as JSON Lines, - for stdout")
+	flag.StringVar(&importFile, "import", "", "merge a JSON Lines export into the cache, - for stdin")
+	flag.StringVar(&mergePolicy, "merge", string(cache.MergeNewest), "how -import resolves images already in the cache: newest or union")
 	flag.BoolVar(&listKeys, "list-keys", false, "list all keys")
 	flag.BoolVar(&purge, "purge", false, "delete all keys")
+	flag.BoolVar(&gc, "gc", false, "drop entries for images that are gone or changed and compact the cache")
+	flag.BoolVar(&schemaVersion, "schema-version", false, "print the layout version of the cache")
+	flag.StringVar(&matchRegex, "match-regex", "", "select images whose path matches this regex")
+	flag.StringVar(&bulkOpts.filter.Glob, "match-glob", "", "select images whose path matches this glob, * does not cross directories")
+	flag.StringVar(&bulkOpts.filter.Type, "failure-type", "", "select images whose last failure was this type: "+strings.Join(cache.FailureTypes, ", "))
+	flag.StringVar(&since, "since", "", "select images that last failed on or after this date or RFC3339 time")
+	flag.StringVar(&until, "until", "", "select images that last failed on or before this date or RFC3339 time")
+	flag.IntVar(&bulkOpts.filter.MinAttempts, "min-attempts", 0, "select images that failed at least this many times")
+	flag.IntVar(&bulkOpts.filter.MaxAttempts, "max-attempts", 0, "select images that failed at most this many times, 0 for no limit")
+	flag.StringVar(&action, "action", "", "what to do with the selected images: list, remove, retry or export")
+	flag.BoolVar(&bulkOpts.dryRun, "dry-run", false, "show what -action remove or retry would do without changing anything")
+	flag.BoolVar(&bulkOpts.yes, "yes", false, "do not ask to confirm -action remove or retry")
 	flag.BoolVar(&ver, "version", false, "print version")
 	flag.BoolVar(&h, "help", false, "print options")
 	flag.Parse()
@@ -49,49 +68,130 @@ func main() {
 		os.Exit(0)
 	}
 
-	var db, err = cache.New(cacheDir.String())
+	if action != "" {
+		bulkOpts.action = strings.TrimSpace(action)
+
+		var err error
+		if matchRegex != "" {
+			if bulkOpts.filter.Regex, err = regexp.Compile(matchRegex); err != nil {
+				log.Errorf("invalid regex: %s", err)
+				os.Exit(1)
+			}
+		}
+		if bulkOpts.filter.Since, err = parseTime(since); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		if bulkOpts.filter.Until, err = parseTime(until); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		// a date on its own means the whole day
+		if len(until) == len(time.DateOnly) {
+			bulkOpts.filter.Until = bulkOpts.filter.Until.Add(24*time.Hour - time.Nanosecond)
+		}
+	}
+
+	// when the daemon is running this talks to it over its control socket
+	db, err := cache.Open(cacheDir.String())
 	if err != nil {
-		log.Errorf("error opening badger dir: %s", err)
+		log.Errorf("error opening cache: %s", err)
+		os.Exit(1)
 	}
 
-	if addImage != "" {
+	if action != "" {
+		if err := bulk(db, bulkOpts, os.Stdin, os.Stdout); err != nil {
+			log.Errorf("error running %s: %s", bulkOpts.action, err)
+			exit(db, 1)
+		}
+		exit(db, 0)
+
+	} else if addImage != "" {
 		if err := add(addImage, db); err != nil {
 			log.Errorf("error adding key: %s", err)
-			os.Exit(1)
+			exit(db, 1)
 		}
-		os.Exit(0)
+		exit(db, 0)
 
 	} else if removeImage != "" {
 		if err = db.RemoveImage(removeImage); err != nil {
 			log.Errorf("error removing image: %s from cache, err :%s", removeImage, err)
-			os.Exit(1)
+			exit(db, 1)
 		}
 		log.Infof("removed :%s from cache", removeImage)
-		os.Exit(0)
+		exit(db, 0)
 
 	} else if purge {
 		if err = db.RemoveAllImages(); err != nil {
 			log.Errorf("error purging database, err :%s", err)
-			os.Exit(1)
+			exit(db, 1)
 		}
 		log.Info("purged database")
-		os.Exit(0)
+		exit(db, 0)
+
+	} else if schemaVersion {
+		var versioned, ok = db.(cache.Versioned)
+		if !ok {
+			log.Error("this cache does not have a schema version")
+			exit(db, 1)
+		}
+
+		version, err := versioned.SchemaVersion()
+		if err != nil {
+			log.Errorf("error reading schema version: %s", err)
+			exit(db, 1)
+		}
+		fmt.Printf("schema version: %d, this build writes: %d\n", version, cache.CurrentSchemaVersion)
+		exit(db, 0)
+
+	} else if gc {
+		stats, err := cache.GC(db)
+		if err != nil {
+			log.Errorf("error running gc: %s", err)
+			exit(db, 1)
+		}
+		log.Infof("gc removed %d entries and reclaimed %d bytes", stats.Entries, stats.ReclaimedBytes)
+		exit(db, 0)
+
+	} else if exportFile != "" {
+		if err = exportCache(exportFile, db); err != nil {
+			log.Errorf("error exporting cache: %s", err)
+			exit(db, 1)
+		}
+		exit(db, 0)
+
+	} else if importFile != "" {
+		policy, err := cache.ParseMergePolicy(mergePolicy)
+		if err != nil {
+			log.Error(err)
+			exit(db, 1)
+		}
+
+		stats, err := importCache(importFile, policy, db)
+		if err != nil {
+			log.Errorf("error importing cache: %s", err)
+			exit(db, 1)
+		}
+		log.Infof("imported %s: %d added, %d updated, %d unchanged", importFile, stats.Added, stats.Updated, stats.Unchanged)
+		exit(db, 0)
 
 	} else if searchTerm != "" || listKeys {
 		var results, err = searchImages(searchTerm, db)
 		if err != nil {
 			log.Errorf("error searching keys: %s", err)
-			os.Exit(1)
+			exit(db, 1)
 		}
 
 		for _, img := range results {
 			fmt.Println(img)
 		}
-		os.Exit(0)
+		exit(db, 0)
 	}
+
+	exit(db, 0)
 }
 
-func add(image string, db cache.Cache) error {
+func add(image string, db cache.Store) error {
 	var entry, err = path.NewEntry(image, 0)
 	if err != nil {
 		return fmt.Errorf("image: %s does not exist, err :%w", image, err)
@@ -105,7 +205,7 @@ func add(image string, db cache.Cache) error {
 	return nil
 }
 
-func searchImages(searchTerm string, db cache.Cache) ([]string, error) {
+func searchImages(searchTerm string, db cache.Store) ([]string, error) {
 	var images = make(chan string)
 	var searchResults = make([]string, 0)
 	var wg sync.WaitGroup
@@ -126,3 +226,44 @@ func searchImages(searchTerm string, db cache.Cache) ([]string, error) {
 
 	return searchResults, nil
 }
+
+func exportCache(exportFile string, db cache.Store) error {
+	if exportFile == "-" {
+		return cache.Export(db, os.Stdout)
+	}
+
+	var file, err = os.Create(exportFile)
+	if err != nil {
+		return fmt.Errorf("error creating export file: %s, err: %w", exportFile, err)
+	}
+
+	if err := cache.Export(db, file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func importCache(importFile string, policy cache.MergePolicy, db cache.Store) (cache.ImportStats, error) {
+	if importFile == "-" {
+		return cache.Import(db, os.Stdin, policy)
+	}
+
+	var file, err = os.Open(importFile)
+	if err != nil {
+		return cache.ImportStats{}, fmt.Errorf("error opening import file: %s, err: %w", importFile, err)
+	}
+	defer file.Close()
+
+	return cache.Import(db, file, policy)
+}
+
+// exit closes the cache before exiting so badger flushes its writes.
+func exit(db cache.Store, code int) {
+	if err := db.Close(); err != nil {
+		log.Errorf("error closing cache: %s", err)
+		code = 1
+	}
+	os.Exit(code)
+}
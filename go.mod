@@ -12,6 +12,7 @@ require (
 	github.com/sirupsen/logrus v1.9.4
 	github.com/stretchr/testify v1.11.1
 	go.szostok.io/version v1.2.0
+	golang.org/x/image v0.38.0
 )
 
 replace github.com/imdario/mergo => github.com/imdario/mergo v0.3.16
@@ -0,0 +1,126 @@
+package local
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kmulvey/realesrgan-scheduler/internal/pathmap"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+)
+
+// splitAlpha applies rl.Alpha to an input with transparent pixels. Flatten puts it on white, separate splits it into
+// an opaque color image and a gray image of its alpha channel. Preserve leaves it to realesrgan.
+func (rl *RealesrganLocal) splitAlpha(j *job) error {
+
+	if rl.Alpha != pathmap.AlphaFlatten && rl.Alpha != pathmap.AlphaSeparate {
+		return nil
+	}
+
+	var transparent, err = imagetype.HasTransparency(j.input)
+	if err != nil || !transparent {
+		return err
+	}
+
+	img, err := decodeFile(j.input)
+	if err != nil {
+		return err
+	}
+	var bounds = img.Bounds()
+
+	if rl.Alpha == pathmap.AlphaFlatten {
+		var flat = image.NewNRGBA(bounds)
+		draw.Draw(flat, bounds, image.White, image.Point{}, draw.Src)
+		draw.Draw(flat, bounds, img, bounds.Min, draw.Over)
+
+		var flattened = filepath.Join(j.scratch, "flattened.png")
+		if err := writePNG(flat, flattened); err != nil {
+			return err
+		}
+		j.input = flattened
+		return nil
+	}
+
+	var colors = image.NewNRGBA(bounds)
+	var alpha = image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var c = color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			alpha.SetGray(x, y, color.Gray{Y: c.A})
+			c.A = 0xff
+			colors.SetNRGBA(x, y, c)
+		}
+	}
+
+	var colorFile, alphaFile = filepath.Join(j.scratch, "color.png"), filepath.Join(j.scratch, "alpha.png")
+	if err := writePNG(colors, colorFile); err != nil {
+		return err
+	}
+	if err := writePNG(alpha, alphaFile); err != nil {
+		return err
+	}
+	j.input, j.alpha = colorFile, alphaFile
+
+	return nil
+}
+
+// mergeAlpha upsizes the alpha channel split off by splitAlpha with the same settings as run, and puts it back into
+// the upsized colors in run.UpsizedFile.
+func (rl *RealesrganLocal) mergeAlpha(j *job, run realesrgan.ImageConfig) error {
+
+	if j.alpha == "" {
+		return nil
+	}
+
+	if !strings.EqualFold(filepath.Ext(run.UpsizedFile), ".png") {
+		return fmt.Errorf("the alpha channel can only be put back into png images, not: %s", run.UpsizedFile)
+	}
+
+	var upsizedAlpha = run
+	upsizedAlpha.SourceFile = j.alpha
+	upsizedAlpha.UpsizedFile = filepath.Join(j.scratch, "alpha_upsized.png")
+	if _, err := rl.runUpsize(j, upsizedAlpha); err != nil {
+		return fmt.Errorf("error upsizing alpha channel: %w", err)
+	}
+
+	colors, err := decodeFile(run.UpsizedFile)
+	if err != nil {
+		return err
+	}
+	alpha, err := decodeFile(upsizedAlpha.UpsizedFile)
+	if err != nil {
+		return err
+	}
+
+	var bounds = colors.Bounds()
+	if bounds.Size() != alpha.Bounds().Size() {
+		return fmt.Errorf("upsized alpha channel is %s but the image is %s", alpha.Bounds().Size(), bounds.Size())
+	}
+
+	var merged = image.NewNRGBA(bounds)
+	var offset = alpha.Bounds().Min.Sub(bounds.Min)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var c = color.NRGBAModel.Convert(colors.At(x, y)).(color.NRGBA)
+			c.A = color.GrayModel.Convert(alpha.At(x+offset.X, y+offset.Y)).(color.Gray).Y
+			merged.SetNRGBA(x, y, c)
+		}
+	}
+
+	// this is the final image so unlike the intermediates it is worth compressing
+	var out *os.File
+	if out, err = os.Create(run.UpsizedFile); err != nil {
+		return fmt.Errorf("error opening upsized image: %s, err: %w", run.UpsizedFile, err)
+	}
+	if err := png.Encode(out, merged); err != nil {
+		out.Close()
+		return fmt.Errorf("error encoding %s: %w", run.UpsizedFile, err)
+	}
+	return out.Close()
+}
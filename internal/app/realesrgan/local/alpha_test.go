@@ -0,0 +1,89 @@
+package local
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kmulvey/realesrgan-scheduler/internal/pathmap"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	"github.com/kmulvey/realesrgan-scheduler/testimages"
+	"github.com/stretchr/testify/assert"
+)
+
+// copyRealesrgan stands in for realesrgan by copying the input to the output, so upsizing keeps the size.
+const copyRealesrgan = `#!/bin/sh
+while [ $# -gt 0 ]; do
+	case "$1" in
+		-i) in="$2" ;;
+		-o) out="$2" ;;
+	esac
+	shift
+done
+cp "$in" "$out"
+`
+
+func TestAlpha(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var realesrganPath = filepath.Join(dir, "realesrgan")
+	assert.NoError(t, os.WriteFile(realesrganPath, []byte(copyRealesrgan), 0700)) //nolint:gosec
+
+	var img = image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.SetNRGBA(1, 2, color.NRGBA{R: 0x20, G: 0x40, B: 0x80, A: 0x60})
+	img.SetNRGBA(3, 0, color.NRGBA{})
+
+	var original = filepath.Join(dir, "glass.png")
+	assert.NoError(t, writePNG(img, original))
+
+	var opaque = filepath.Join(dir, "fox.png")
+	assert.NoError(t, os.WriteFile(opaque, testimages.FoxPNG, 0600))
+
+	// opaque images are left alone even if they have an alpha channel
+	var rl = RealesrganLocal{ScratchDir: dir, Alpha: pathmap.AlphaSeparate}
+	var j, err = rl.newJob(&realesrgan.ImageConfig{SourceFile: opaque})
+	assert.NoError(t, err)
+	assert.NoError(t, rl.splitAlpha(j))
+	assert.Equal(t, opaque, j.input)
+	assert.Empty(t, j.alpha)
+
+	j, err = rl.newJob(&realesrgan.ImageConfig{SourceFile: original})
+	assert.NoError(t, err)
+	assert.NoError(t, rl.splitAlpha(j))
+	assert.NotEmpty(t, j.alpha)
+
+	transparent, err := imagetype.HasTransparency(j.input)
+	assert.NoError(t, err)
+	assert.False(t, transparent)
+
+	var run = realesrgan.ImageConfig{SourceFile: j.input, UpsizedFile: filepath.Join(dir, "glass_4x.png"), RealesrganPath: realesrganPath, Progress: make(chan string, 10)}
+	assert.NoError(t, realesrgan.Upsize(run))
+	assert.NoError(t, rl.mergeAlpha(j, run))
+
+	merged, err := decodeFile(run.UpsizedFile)
+	assert.NoError(t, err)
+	assert.Equal(t, color.NRGBA{R: 0x20, G: 0x40, B: 0x80, A: 0x60}, color.NRGBAModel.Convert(merged.At(1, 2)))
+	assert.Equal(t, uint8(0), color.NRGBAModel.Convert(merged.At(3, 0)).(color.NRGBA).A)
+	assert.Equal(t, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, color.NRGBAModel.Convert(merged.At(0, 0)))
+	assert.NoError(t, j.cleanup())
+
+	// flattening puts the transparent pixels on white
+	rl.Alpha = pathmap.AlphaFlatten
+	j, err = rl.newJob(&realesrgan.ImageConfig{SourceFile: original})
+	assert.NoError(t, err)
+	assert.NoError(t, rl.splitAlpha(j))
+	assert.Empty(t, j.alpha)
+
+	flattened, err := decodeFile(j.input)
+	assert.NoError(t, err)
+	assert.Equal(t, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, color.NRGBAModel.Convert(flattened.At(3, 0)))
+	assert.Equal(t, uint8(0xff), color.NRGBAModel.Convert(flattened.At(1, 2)).(color.NRGBA).A)
+	assert.NoError(t, j.cleanup())
+}
@@ -3,25 +3,86 @@ package local
 import (
 	"fmt"
 
+	"github.com/kmulvey/realesrgan-scheduler/internal/cache"
+	"github.com/kmulvey/realesrgan-scheduler/internal/gpu"
+	"github.com/kmulvey/realesrgan-scheduler/internal/pathmap"
 	"github.com/kmulvey/realesrgan-scheduler/internal/queue"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/encode"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/geometry"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/metadata"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/provenance"
 	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
 	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
 )
 
 type RealesrganLocal struct {
-	PromNamespace   string
-	RealesrganPath  string
-	ModelName       string
-	NumGPUs         uint8
+	PromNamespace  string
+	RealesrganPath string
+	ModelName      string
+	NumGPUs        uint8
+	// Devices are the gpus to upsize on and how much each can take, when set they are used instead of ids 0 to
+	// NumGPUs-1, see DiscoverGPUs and ConfigureGPUs.
+	Devices         []gpu.Device
 	RemoveOriginals bool
+	// KeepOldVersions renames re-processed images to include the model that made them instead of deleting them.
+	KeepOldVersions bool
 	UpsizeTimeGauge prometheus.Gauge
+	// GPUInfoGauge is 1 for each gpu in use, labeled with its id and name.
+	GPUInfoGauge *prometheus.GaugeVec
+	// PassTimeGauge is the time each pass of an image took, by pass number and model.
+	PassTimeGauge *prometheus.GaugeVec
+	// Passes is the most times realesrgan is run on an image, each on the output of the last. With a target it stops
+	// once the target is reached, 0 is the same as 1.
+	Passes int
+	// PassModels are the models for each pass, the last one is used for any passes after it. ModelName if empty.
+	PassModels []string
+	// TileSize splits inputs wider or taller than it into tiles of this size that are upsized on their own, possibly
+	// on different gpus, and stitched back together. 0 never splits.
+	TileSize int
+	// TileOverlap is how many pixels tiles share with their neighbours, DefaultTileOverlap if 0.
+	TileOverlap int
+	// TileMaxPixels is the biggest input, in pixels, that is tiled, DefaultTileMaxPixels if 0. The stitched image is
+	// built in memory, so bigger ones fail rather than run out of it.
+	TileMaxPixels int
+	// BatchSize is the most small images upsized by one run of realesrgan, so the model is not loaded for each of
+	// them. 0 or 1 upsizes every image on its own.
+	BatchSize int
+	// BatchMaxPixels is the biggest image, in pixels, that is batched, DefaultBatchMaxPixels if 0.
+	BatchMaxPixels int
+	// Prefetch is how many of the next images in the queue are copied to the scratch dir while the gpus are busy,
+	// upsized images are then also written there and moved to the upsized dir in the background. 0 reads and writes
+	// them in place.
+	Prefetch int
+	// ScratchLimit is the most bytes of prefetched and upsized images kept in the scratch dir, DefaultScratchLimit if 0.
+	ScratchLimit int64
+	// ScratchDir is where intermediate files are kept while an image is upsized, the system temp dir if empty.
+	ScratchDir string
+	// HEIFDecoder is the tool used to convert AVIF and HEIC images, DefaultHEIFDecoder if empty.
+	HEIFDecoder string
+	// Preserve is which metadata and file times of the originals are copied to the upsized images.
+	Preserve metadata.Fields
+	// Alpha is what happens to transparent images, it has to match the policy of the mapper that named the outputs.
+	Alpha pathmap.AlphaPolicy
+	// Target is the size images are resampled to after upsizing, for those that do not have their own.
+	Target geometry.Target
+	// Encoding is how upsized images are written, realesrgan writes them itself when it is not enabled.
+	Encoding encode.Options
+	// Sidecar is the format of the provenance file written next to each upsized image, none if empty.
+	Sidecar provenance.Format
+	// Cache is optional, when set failed images are added to it and successful ones have their model recorded.
+	Cache cache.Store
 	*queue.Queue
 	files chan *realesrgan.ImageConfig
+	// pool places images on the Devices while the queue is upsized, tiles of an image can use the free ones.
+	pool *gpu.Pool
+	// stage is set while the queue is upsized with Prefetch.
+	stage *stage
 }
 
 // NewRealesrganLocal is the constructor for running local upsizing. It takes a slice of existing files
 // and prepopulates the queue with them,  Run() takes a channel of watchEvents to stream files.
-func NewRealesrganLocal(promNamespace, realesrganPath, modelName string, numGPUs uint8, removeOriginals bool, files chan *realesrgan.ImageConfig) (*RealesrganLocal, error) {
+func NewRealesrganLocal(promNamespace, realesrganPath, modelName string, numGPUs uint8, removeOriginals bool, skipCache cache.Store, files chan *realesrgan.ImageConfig) (*RealesrganLocal, error) {
 
 	var upsizeTime = prometheus.NewGauge(
 		prometheus.GaugeOpts{
@@ -32,13 +93,38 @@ func NewRealesrganLocal(promNamespace, realesrganPath, modelName string, numGPUs
 	)
 	prometheus.MustRegister(upsizeTime)
 
+	var passTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: promNamespace,
+			Name:      "upsize_pass_time",
+			Help:      "time it took to run one pass of the upsize chain",
+		},
+		[]string{"pass", "model"},
+	)
+	prometheus.MustRegister(passTime)
+
+	var gpuInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: promNamespace,
+			Name:      "gpu_info",
+			Help:      "the gpus images are upsized on",
+		},
+		[]string{"gpu", "name"},
+	)
+	prometheus.MustRegister(gpuInfo)
+
 	var rl = RealesrganLocal{
 		PromNamespace:   promNamespace,
 		RealesrganPath:  realesrganPath,
 		ModelName:       modelName,
 		UpsizeTimeGauge: upsizeTime,
+		PassTimeGauge:   passTime,
+		GPUInfoGauge:    gpuInfo,
 		NumGPUs:         numGPUs,
 		RemoveOriginals: removeOriginals,
+		Preserve:        metadata.AllFields,
+		Alpha:           pathmap.AlphaPreserve,
+		Cache:           skipCache,
 		Queue:           queue.New(false),
 		files:           files,
 	}
@@ -51,10 +137,6 @@ func (rl *RealesrganLocal) Run(images ...*realesrgan.ImageConfig) error {
 
 	for _, image := range images {
 
-		image.ModelName = rl.ModelName
-		image.RealesrganPath = rl.RealesrganPath
-		image.Progress = make(chan string)
-
 		var err = rl.AddImage(image)
 		if err != nil {
 			return fmt.Errorf("problem adding existing files to queue: %w", err)
@@ -66,9 +148,48 @@ func (rl *RealesrganLocal) Run(images ...*realesrgan.ImageConfig) error {
 	return nil
 }
 
+// Watch adds every image from images to the queue and upsizes them as they arrive. It returns once images is closed and the queue has drained.
+func (rl *RealesrganLocal) Watch(images chan *realesrgan.ImageConfig) {
+
+	// UpsizeQueue drains the whole queue, so one pending wake up is enough to pick up everything added while it was running
+	var wake = make(chan struct{}, 1)
+	wake <- struct{}{} // upsize anything that was queued before we started watching
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for range wake {
+			rl.UpsizeQueue()
+		}
+	}()
+
+	for image := range images {
+		if err := rl.AddImage(image); err != nil {
+			log.Errorf("error adding image to queue: %s", err)
+			continue
+		}
+
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+
+	close(wake)
+	<-done
+}
+
 // AddImage adds the given image to the queue if the upsized path does not already exist.
 func (rl *RealesrganLocal) AddImage(image *realesrgan.ImageConfig) error {
 
+	image.ModelName = rl.ModelName
+	image.RealesrganPath = rl.RealesrganPath
+	if !image.Target.Enabled() {
+		image.Target = rl.Target
+	}
+	if image.Progress == nil {
+		image.Progress = make(chan string)
+	}
+
 	if !rl.Queue.Contains(image) {
 		var err = rl.Queue.Add(image)
 		if err != nil {
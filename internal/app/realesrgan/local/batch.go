@@ -0,0 +1,261 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultBatchMaxPixels is the biggest image that is batched when BatchMaxPixels is not set, for images much bigger
+// than this loading the model is a small part of the time it takes to upsize them.
+const DefaultBatchMaxPixels = 1024 * 1024
+
+// batchable reports whether the image is small enough to be upsized in a batch.
+func (rl *RealesrganLocal) batchable(image *realesrgan.ImageConfig) bool {
+
+	if rl.BatchSize < 2 {
+		return false
+	}
+
+	var info, err = sourceInfo(image)
+	if err != nil {
+		return false
+	}
+
+	var maxPixels = rl.BatchMaxPixels
+	if maxPixels <= 0 {
+		maxPixels = DefaultBatchMaxPixels
+	}
+	return info.Width > 0 && info.Width*info.Height <= maxPixels
+}
+
+// nextBatch takes up to BatchSize-1 more images that can go with first from the front of the queue, they all run on
+// the gpu of first.
+func (rl *RealesrganLocal) nextBatch(first *realesrgan.ImageConfig) []*realesrgan.ImageConfig {
+
+	var batch = []*realesrgan.ImageConfig{first}
+	if !rl.batchable(first) {
+		return batch
+	}
+
+	for len(batch) < rl.BatchSize {
+		var next = rl.Queue.NextImageIf(rl.batchable)
+		if next == nil {
+			break
+		}
+		next.Remaining = rl.Queue.Len()
+		next.GpuId = first.GpuId
+		batch = append(batch, next)
+	}
+	return batch
+}
+
+// batchKey is what the images of one run of realesrgan have to have in common.
+type batchKey struct {
+	format string
+	model  string
+	scale  int
+}
+
+// upsizeBatch upsizes the images with as few runs of realesrgan as it can. Images that need more than one run of
+// their own, because they take several passes, are tiled or have their alpha channel upsized separately, are
+// upsized one at a time.
+func (rl *RealesrganLocal) upsizeBatch(images []*realesrgan.ImageConfig) {
+
+	var jobs = make(map[batchKey][]*job)
+	var runs = make(map[*job]realesrgan.ImageConfig)
+	var keys []batchKey
+	for _, image := range images {
+		var j, run, ok = rl.prepare(image)
+		if !ok {
+			continue
+		}
+
+		if len(j.passes) != 1 || j.tiled || j.alpha != "" {
+			rl.runAlone(j, run)
+			continue
+		}
+
+		var key = batchKey{format: strings.TrimPrefix(filepath.Ext(run.UpsizedFile), "."), model: j.passes[0].model, scale: j.passes[0].scale}
+		if _, ok := jobs[key]; !ok {
+			keys = append(keys, key)
+		}
+		jobs[key] = append(jobs[key], j)
+		runs[j] = run
+	}
+
+	for _, key := range keys {
+		rl.runBatch(key, jobs[key], runs)
+	}
+}
+
+// runBatch links the inputs of the jobs into a dir of their own, runs realesrgan on it once and finishes each job
+// with its output. A job that fails is recorded on its own, if realesrgan crashed the jobs it had not got to are run
+// alone instead. Each job is cleaned up once it is done.
+func (rl *RealesrganLocal) runBatch(key batchKey, jobs []*job, runs map[*job]realesrgan.ImageConfig) {
+
+	if len(jobs) == 1 {
+		rl.runAlone(jobs[0], runs[jobs[0]])
+		return
+	}
+
+	var dir, err = os.MkdirTemp(rl.ScratchDir, "realesrgan-batch-")
+	if err != nil {
+		log.Errorf("error creating batch dir, upsizing %d images one at a time: %s", len(jobs), err)
+		for _, j := range jobs {
+			rl.runAlone(j, runs[j])
+		}
+		return
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Errorf("error removing batch dir %s: %s", dir, err)
+		}
+	}()
+
+	// inputs are numbered as names only have to be unique within a dir
+	var batch = realesrgan.BatchConfig{
+		InputDir:       filepath.Join(dir, "in"),
+		OutputDir:      filepath.Join(dir, "out"),
+		Format:         key.format,
+		Scale:          key.scale,
+		ModelName:      key.model,
+		RealesrganPath: runs[jobs[0]].RealesrganPath,
+		GpuId:          runs[jobs[0]].GpuId,
+		Progress:       make(chan string),
+	}
+	if err := os.Mkdir(batch.InputDir, os.ModePerm); err != nil {
+		log.Errorf("error creating batch dir %s, upsizing %d images one at a time: %s", batch.InputDir, len(jobs), err)
+		for _, j := range jobs {
+			rl.runAlone(j, runs[j])
+		}
+		return
+	}
+
+	var names = make(map[*job]string, len(jobs))
+	var batched []*job
+	for i, j := range jobs {
+		var name = fmt.Sprintf("%d%s", i, filepath.Ext(j.input))
+		if err := linkOrCopy(j.input, filepath.Join(batch.InputDir, name)); err != nil {
+			log.Errorf("error adding %s to batch, upsizing it alone: %s", j.image.SourceFile, err)
+			rl.runAlone(j, runs[j])
+			continue
+		}
+		names[j] = name
+		batched = append(batched, j)
+	}
+	if len(batched) == 0 {
+		return
+	}
+
+	// every image in the batch shows the progress of the batch
+	var forwarded = make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for pct := range batch.Progress {
+			for _, j := range batched {
+				if runs[j].Progress != nil {
+					runs[j].Progress <- pct
+				}
+			}
+		}
+	}()
+
+	log.WithFields(log.Fields{"images": len(batched), "model": key.model, "gpu": rl.gpuName(batch.GpuId)}).Debug("starting batch")
+
+	var start = time.Now()
+	failures, batchErr := realesrgan.UpsizeBatch(batch)
+	close(batch.Progress)
+	<-forwarded
+	if batchErr != nil {
+		log.Errorf("error upsizing batch of %d images: %s", len(batched), batchErr)
+	}
+
+	// the model was loaded once for all of them so each image gets its share of the time
+	var share = time.Since(start) / time.Duration(len(batched))
+	for _, j := range batched {
+		var run = runs[j]
+		var name = names[j]
+		if err := failures[name]; err != nil {
+			log.Errorf("error upsizing %s: %s", j.image.SourceFile, err)
+			rl.recordFailure(j.image, err)
+			rl.cleanup(j)
+			continue
+		}
+
+		var output = filepath.Join(batch.OutputDir, strings.TrimSuffix(name, filepath.Ext(name))+"."+key.format)
+		if _, err := os.Stat(output); err != nil {
+			if batchErr != nil {
+				rl.runAlone(j, run)
+				continue
+			}
+			var err = fmt.Errorf("realesrgan did not write %s", output)
+			log.Errorf("error upsizing %s: %s", j.image.SourceFile, err)
+			rl.recordFailure(j.image, err)
+			rl.cleanup(j)
+			continue
+		}
+
+		if err := moveOutput(output, run.UpsizedFile); err != nil {
+			log.Errorf("error upsizing %s: %s", j.image.SourceFile, err)
+			rl.recordFailure(j.image, err)
+			rl.cleanup(j)
+			continue
+		}
+
+		if run.Progress != nil {
+			run.Progress <- "100%"
+		}
+		rl.deliver(j, run, []time.Duration{share}, start)
+	}
+}
+
+// linkOrCopy hardlinks file to link, or copies it when that is not possible, e.g. across file systems.
+func linkOrCopy(file, link string) error {
+	if err := os.Link(file, link); err == nil {
+		return nil
+	}
+	return copyFile(file, link)
+}
+
+// moveOutput moves what realesrgan wrote to where it would have written it had it been run on the image alone.
+func moveOutput(output, upsizedFile string) error {
+
+	if _, err := os.Stat(upsizedFile); err == nil {
+		return fmt.Errorf("file already exists: %s", upsizedFile)
+	}
+	if err := os.MkdirAll(filepath.Dir(upsizedFile), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create upsized directory: %w", err)
+	}
+
+	if err := os.Rename(output, upsizedFile); err == nil {
+		return nil
+	}
+	return copyFile(output, upsizedFile)
+}
+
+func copyFile(from, to string) error {
+
+	var in, err = os.Open(from)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", from, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(to)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", to, err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		return errors.Join(fmt.Errorf("error copying %s to %s: %w", from, to, err), out.Close(), os.Remove(to))
+	}
+	return out.Close()
+}
@@ -0,0 +1,130 @@
+package local
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kmulvey/realesrgan-scheduler/internal/queue"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// batchRealesrgan is copyRealesrgan for dirs, it logs each run to realesrgan.log and fails the second image.
+const batchRealesrgan = `#!/bin/sh
+log="$(dirname "$0")/realesrgan.log"
+while [ $# -gt 0 ]; do
+	case "$1" in
+		-i) in="$2" ;;
+		-o) out="$2" ;;
+		-f) format="$2" ;;
+	esac
+	shift
+done
+echo "$(ls "$in" | wc -l)" >> "$log"
+for f in "$in"/*; do
+	name="$(basename "$f")"
+	echo "50.00%" >&2
+	if [ "${name%.*}" = "1" ]; then
+		echo "decode image $f failed" >&2
+		continue
+	fi
+	cp "$f" "$out/${name%.*}.$format"
+	echo "$f -> $out/${name%.*}.$format done" >&2
+done
+`
+
+func TestBatch(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var realesrganPath = filepath.Join(dir, "realesrgan")
+	assert.NoError(t, os.WriteFile(realesrganPath, []byte(batchRealesrgan), 0700)) //nolint:gosec
+
+	var files = make(chan *realesrgan.ImageConfig)
+	var rl = RealesrganLocal{
+		ScratchDir:      dir,
+		ModelName:       "realesrgan-x4plus",
+		RealesrganPath:  realesrganPath,
+		NumGPUs:         1,
+		BatchSize:       4,
+		UpsizeTimeGauge: prometheus.NewGauge(prometheus.GaugeOpts{Name: "upsize_time"}),
+		Queue:           queue.New(false),
+		files:           files,
+	}
+	go func() {
+		for f := range files {
+			go func(f *realesrgan.ImageConfig) {
+				for range f.Progress {
+				}
+			}(f)
+		}
+	}()
+
+	var images []*realesrgan.ImageConfig
+	for i := range 3 {
+		var original = filepath.Join(dir, fmt.Sprintf("small%d.png", i))
+		assert.NoError(t, writePNG(image.NewNRGBA(image.Rect(0, 0, 8+i, 8)), original))
+
+		var img = &realesrgan.ImageConfig{SourceFile: original, UpsizedFile: filepath.Join(dir, "upsized", fmt.Sprintf("small%d.png", i)), Progress: make(chan string)}
+		assert.True(t, rl.batchable(img))
+		assert.NoError(t, rl.AddImage(img))
+		images = append(images, img)
+	}
+	rl.UpsizeQueue()
+	close(files)
+
+	// one run for all of them, and only the image that failed is missing
+	log, err := os.ReadFile(filepath.Join(dir, "realesrgan.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3"}, strings.Fields(string(log)))
+
+	var upsized int
+	for _, img := range images {
+		if _, err := os.Stat(img.UpsizedFile); err == nil {
+			upsized++
+		}
+	}
+	assert.Equal(t, 2, upsized)
+
+	// the image that failed can be retried while we are still running
+	assert.NoError(t, rl.AddImage(images[1]))
+	assert.Equal(t, 1, rl.Queue.Len())
+
+	// big images are upsized alone
+	rl.BatchMaxPixels = 8 * 8
+	assert.False(t, rl.batchable(images[2]))
+}
+
+func TestBatchMissingInputs(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var realesrganPath = filepath.Join(dir, "realesrgan")
+	assert.NoError(t, os.WriteFile(realesrganPath, []byte(batchRealesrgan), 0700)) //nolint:gosec
+
+	var rl = RealesrganLocal{ScratchDir: dir, ModelName: "realesrgan-x4plus", RealesrganPath: realesrganPath}
+
+	// none of the inputs can be added to the batch, so each is upsized alone and there is no batch to run
+	var jobs []*job
+	var runs = make(map[*job]realesrgan.ImageConfig)
+	for i := range 2 {
+		var j, err = rl.newJob(&realesrgan.ImageConfig{SourceFile: filepath.Join(dir, fmt.Sprintf("missing%d.png", i))})
+		assert.NoError(t, err)
+		j.passes = []pass{{model: rl.ModelName}}
+		jobs = append(jobs, j)
+		runs[j] = realesrgan.ImageConfig{SourceFile: j.input, UpsizedFile: filepath.Join(dir, fmt.Sprintf("upsized%d.png", i)), RealesrganPath: realesrganPath, Progress: make(chan string, 10)}
+	}
+
+	assert.NotPanics(t, func() { rl.runBatch(batchKey{format: "png", model: rl.ModelName, scale: 4}, jobs, runs) })
+	for _, j := range jobs {
+		assert.NoDirExists(t, j.scratch)
+	}
+	log, err := os.ReadFile(filepath.Join(dir, "realesrgan.log"))
+	assert.NoError(t, err)
+	assert.Len(t, strings.Fields(string(log)), 2) // the runs alone, not one of an empty batch
+}
@@ -0,0 +1,198 @@
+package local
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // register decoder
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/metadata"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	_ "golang.org/x/image/bmp"  // register decoder
+	_ "golang.org/x/image/tiff" // register decoder
+)
+
+// DefaultHEIFDecoder is the libheif tool used to read AVIF and HEIC images, there is no decoder for them in Go.
+// It is run as: heif-dec input output.png
+const DefaultHEIFDecoder = "heif-dec"
+
+// job is an image on its way through upsize along with the scratch dir for its intermediate files.
+type job struct {
+	image   *realesrgan.ImageConfig
+	scratch string
+	// input is what realesrgan reads, either SourceFile, its prefetched copy or an intermediate in scratch.
+	input string
+	// orientation is the EXIF orientation of the source, the input has already been turned to match it.
+	orientation int
+	// alpha is the alpha channel of the input as a gray image, when it is upsized separately.
+	alpha string
+	// passes are the runs of realesrgan that upsize the image, see planPasses.
+	passes []pass
+	// tiled is set when the input is upsized in tiles, see planTiles.
+	tiled bool
+	// encoding is how the upsized image was encoded, empty when realesrgan wrote it.
+	encoding string
+}
+
+// newJob makes a scratch dir for the image, cleanup must be called once the image is done.
+func (rl *RealesrganLocal) newJob(image *realesrgan.ImageConfig) (*job, error) {
+
+	var scratch, err = os.MkdirTemp(rl.ScratchDir, "realesrgan-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating scratch dir: %w", err)
+	}
+
+	return &job{image: image, scratch: scratch, input: rl.stage.input(image)}, nil
+}
+
+func (j *job) cleanup() error {
+	return os.RemoveAll(j.scratch)
+}
+
+// convertInput transcodes a source realesrgan cannot read to a lossless 8 bit png in the scratch dir.
+func (rl *RealesrganLocal) convertInput(j *job) error {
+
+	var source = j.image.Source
+	if source.Format == "" {
+		var err error
+		if source, err = imagetype.Sniff(j.image.SourceFile); err != nil {
+			return err
+		}
+		j.image.Source = source
+	}
+
+	if !source.NeedsConversion() {
+		return nil
+	}
+
+	var input = j.input
+	if source.Format == imagetype.AVIF || source.Format == imagetype.HEIC {
+		var decoded = filepath.Join(j.scratch, "decoded.png")
+		if err := rl.decodeHEIF(input, decoded); err != nil {
+			return err
+		}
+
+		// HDR images decode to 16 bit, which still needs converting
+		var info, err = imagetype.Sniff(decoded)
+		if err != nil {
+			return fmt.Errorf("%s did not write a readable image: %w", rl.heifDecoder(), err)
+		}
+		if !info.NeedsConversion() {
+			j.input = decoded
+			return nil
+		}
+		input = decoded
+	}
+
+	var converted = filepath.Join(j.scratch, "input.png")
+	if err := toPNG8(input, converted); err != nil {
+		return fmt.Errorf("error converting %s image %s: %w", source.Format, j.image.SourceFile, err)
+	}
+	j.input = converted
+
+	return nil
+}
+
+func (rl *RealesrganLocal) heifDecoder() string {
+	if rl.HEIFDecoder == "" {
+		return DefaultHEIFDecoder
+	}
+	return rl.HEIFDecoder
+}
+
+func (rl *RealesrganLocal) decodeHEIF(input, output string) error {
+
+	// the decoder is set by the user, like the realesrgan binary
+	//nolint:gosec
+	var cmd = exec.Command(rl.heifDecoder(), input, output)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error decoding %s with %s: %w, output: %s", input, rl.heifDecoder(), err, out)
+	}
+
+	return nil
+}
+
+// orientInput turns the pixels of JPEG and WebP sources the way their EXIF orientation says, realesrgan ignores the
+// tag and drops it so the upsized image would be shown sideways.
+func (rl *RealesrganLocal) orientInput(j *job) error {
+
+	j.orientation = 1
+	if j.image.Source.Format != imagetype.JPEG && j.image.Source.Format != imagetype.WebP {
+		return nil
+	}
+
+	var exif, err = metadata.ReadEXIF(j.image.SourceFile)
+	if err != nil {
+		return err
+	}
+
+	j.orientation = metadata.Orientation(exif)
+	if j.orientation == 1 {
+		return nil
+	}
+
+	img, err := decodeFile(j.input)
+	if err != nil {
+		return err
+	}
+
+	var oriented = filepath.Join(j.scratch, "oriented.png")
+	if err := writePNG(metadata.Orient(img, j.orientation), oriented); err != nil {
+		return fmt.Errorf("error writing oriented image: %w", err)
+	}
+	j.input = oriented
+
+	return nil
+}
+
+// toPNG8 decodes input with the Go decoders and writes it out as an 8 bit RGBA png.
+func toPNG8(input, output string) error {
+
+	var img, err = decodeFile(input)
+	if err != nil {
+		return err
+	}
+
+	var nrgba = image.NewNRGBA(img.Bounds())
+	draw.Draw(nrgba, nrgba.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	return writePNG(nrgba, output)
+}
+
+func decodeFile(file string) (image.Image, error) {
+
+	var in, err = os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	img, _, err := image.Decode(in)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", file, err)
+	}
+
+	return img, nil
+}
+
+// writePNG writes an intermediate, it is deleted as soon as realesrgan has read it so no time is spent compressing it.
+func writePNG(img image.Image, output string) error {
+
+	var out, err = os.Create(output)
+	if err != nil {
+		return err
+	}
+
+	var encoder = png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(out, img); err != nil {
+		out.Close()
+		return fmt.Errorf("error encoding %s: %w", output, err)
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,104 @@
+package local
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	"github.com/kmulvey/realesrgan-scheduler/testimages"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/bmp"
+)
+
+func TestConvertInput(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var rl = RealesrganLocal{ScratchDir: dir, HEIFDecoder: filepath.Join(dir, "no-such-decoder")}
+
+	var img = image.NewRGBA64(image.Rect(0, 0, 4, 3))
+	img.Set(2, 1, color.RGBA64{R: 0xffff, G: 0x8000, A: 0xffff})
+
+	var png16, bitmap bytes.Buffer
+	assert.NoError(t, png.Encode(&png16, img))
+	assert.NoError(t, bmp.Encode(&bitmap, img))
+
+	var files = map[string][]byte{"fox.jpg": testimages.FoxJPG, "deep.png": png16.Bytes(), "old.bmp": bitmap.Bytes(), "fox.avif": testimages.FoxAVIF}
+	for name, data := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0600))
+	}
+
+	var convert = func(name string) (*job, error) {
+		var j, err = rl.newJob(&realesrgan.ImageConfig{SourceFile: filepath.Join(dir, name)})
+		assert.NoError(t, err)
+		return j, rl.convertInput(j)
+	}
+
+	// realesrgan reads jpgs as they are
+	var j, err = convert("fox.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "fox.jpg"), j.input)
+	assert.Equal(t, imagetype.JPEG, j.image.Source.Format)
+	assert.NoError(t, j.cleanup())
+
+	for _, name := range []string{"deep.png", "old.bmp"} {
+		j, err = convert(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, j.scratch, filepath.Dir(j.input), name)
+
+		var info, err = imagetype.Sniff(j.input)
+		assert.NoError(t, err, name)
+		assert.Equal(t, imagetype.Info{Format: imagetype.PNG, Width: 4, Height: 3, Depth: 8, Alpha: true}, info, name)
+		assert.False(t, info.NeedsConversion(), name)
+
+		// the intermediate goes with the scratch dir
+		assert.NoError(t, j.cleanup())
+		assert.NoDirExists(t, j.scratch)
+	}
+
+	_, err = convert("fox.avif")
+	assert.ErrorContains(t, err, "no-such-decoder")
+}
+
+func TestOrientInput(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var rl = RealesrganLocal{ScratchDir: dir}
+
+	// an APP1 segment with a big endian EXIF block whose only tag is orientation 6
+	var app1 = []byte("\xff\xe1\x00\x24Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	var rotated = append(append(append([]byte{}, testimages.FoxJPG[:2]...), app1...), testimages.FoxJPG[2:]...)
+
+	for name, data := range map[string][]byte{"fox.jpg": testimages.FoxJPG, "rotated.jpg": rotated} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0600))
+	}
+
+	var orient = func(name string) *job {
+		var j, err = rl.newJob(&realesrgan.ImageConfig{SourceFile: filepath.Join(dir, name)})
+		assert.NoError(t, err)
+		assert.NoError(t, rl.convertInput(j))
+		assert.NoError(t, rl.orientInput(j))
+		return j
+	}
+
+	var j = orient("fox.jpg")
+	assert.Equal(t, 1, j.orientation)
+	assert.Equal(t, filepath.Join(dir, "fox.jpg"), j.input)
+
+	// the 1204x800 fox is stored turned on its side
+	j = orient("rotated.jpg")
+	assert.Equal(t, 6, j.orientation)
+	assert.Equal(t, filepath.Join(j.scratch, "oriented.png"), j.input)
+
+	var info, err = imagetype.Sniff(j.input)
+	assert.NoError(t, err)
+	assert.Equal(t, 800, info.Width)
+	assert.Equal(t, 1204, info.Height)
+}
@@ -0,0 +1,77 @@
+package local
+
+import (
+	"github.com/kmulvey/realesrgan-scheduler/internal/gpu"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	log "github.com/sirupsen/logrus"
+)
+
+// DiscoverGPUs asks realesrgan which vulkan devices there are and uses them all, except software renderers unless
+// includeSoftware is set.
+func (rl *RealesrganLocal) DiscoverGPUs(includeSoftware bool) error {
+
+	var devices, err = realesrgan.DiscoverDevices(rl.RealesrganPath, rl.ModelName, includeSoftware)
+	if err != nil {
+		return err
+	}
+
+	rl.Devices = nil
+	for _, device := range devices {
+		log.WithFields(log.Fields{"gpu": device.ID, "compute_queues": device.ComputeQueues, "fp16": device.FP16}).Infof("found gpu: %s", device.Name)
+		rl.Devices = append(rl.Devices, gpu.Device{ID: device.ID, Name: device.Name})
+	}
+	rl.NumGPUs = uint8(len(rl.Devices))
+	return nil
+}
+
+// ConfigureGPUs sets the capacity of the gpus in use, gpus that are not in use yet are added.
+func (rl *RealesrganLocal) ConfigureGPUs(capacities []gpu.Device) {
+	rl.Devices = gpu.Merge(rl.devices(), capacities)
+	rl.NumGPUs = uint8(len(rl.Devices))
+}
+
+// devices are the gpus to upsize on.
+func (rl *RealesrganLocal) devices() []gpu.Device {
+
+	if len(rl.Devices) > 0 {
+		return rl.Devices
+	}
+
+	var devices = make([]gpu.Device, rl.NumGPUs)
+	for i := range devices {
+		devices[i].ID = uint8(i)
+	}
+	return devices
+}
+
+// gpuName is the name of the gpu with id for logs and metrics, just the id if it was not discovered.
+func (rl *RealesrganLocal) gpuName(id uint8) string {
+	for _, device := range rl.devices() {
+		if device.ID == id {
+			return device.String()
+		}
+	}
+	return gpu.Device{ID: id}.String()
+}
+
+// sourceInfo is what the original of image is, sniffing it if that was not done when it was found.
+func sourceInfo(image *realesrgan.ImageConfig) (imagetype.Info, error) {
+	if image.Source.Format != "" {
+		return image.Source, nil
+	}
+	return imagetype.Sniff(image.SourceFile)
+}
+
+// imageMegapixels is the size of the original of image for placing it on a gpu, 0 if it cannot be read.
+func imageMegapixels(image *realesrgan.ImageConfig) float64 {
+	var info, err = sourceInfo(image)
+	if err != nil {
+		return 0
+	}
+	return megapixels(info.Width, info.Height)
+}
+
+func megapixels(width, height int) float64 {
+	return float64(width) * float64(height) / 1e6
+}
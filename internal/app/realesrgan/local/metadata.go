@@ -0,0 +1,39 @@
+package local
+
+import (
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/metadata"
+	log "github.com/sirupsen/logrus"
+)
+
+// copyMetadata copies the fields of rl.Preserve from the original to the upsized image, with the dimensions changed
+// to those of the upsized image. It only logs errors, an image without its metadata is still worth keeping.
+func (rl *RealesrganLocal) copyMetadata(j *job, upsizedFile string) {
+
+	var md, err = metadata.Read(j.image.SourceFile)
+	if err != nil {
+		log.Errorf("error reading metadata of %s: %s", j.image.SourceFile, err)
+	}
+	md = rl.Preserve.Select(md)
+
+	if !md.Empty() {
+		var info, err = imagetype.Sniff(upsizedFile)
+		if err != nil {
+			log.Errorf("error reading dimensions of %s: %s", upsizedFile, err)
+			return
+		}
+
+		// the pixels were turned before upsizing so the orientation no longer applies
+		var oriented = j.orientation > 1
+		md.EXIF = metadata.UpdateEXIF(md.EXIF, info.Width, info.Height, oriented)
+		md.XMP = metadata.UpdateXMP(md.XMP, info.Width, info.Height, oriented)
+
+		if err := metadata.Write(upsizedFile, md); err != nil {
+			log.Errorf("error writing metadata to %s: %s", upsizedFile, err)
+		}
+	}
+
+	if err := metadata.CopyTimes(j.image.SourceFile, upsizedFile, rl.Preserve.ModTime, rl.Preserve.AccessTime); err != nil {
+		log.Errorf("error copying times to %s: %s", upsizedFile, err)
+	}
+}
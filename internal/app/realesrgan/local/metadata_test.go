@@ -0,0 +1,46 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kmulvey/realesrgan-scheduler/pkg/metadata"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	"github.com/kmulvey/realesrgan-scheduler/testimages"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyMetadata(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var original, upsized = filepath.Join(dir, "fox.jpg"), filepath.Join(dir, "fox_4x.png")
+	assert.NoError(t, os.WriteFile(original, testimages.FoxJPG, 0600))
+	assert.NoError(t, os.WriteFile(upsized, testimages.FoxPNG, 0600))
+
+	var taken = time.Date(2019, 7, 4, 12, 30, 0, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(original, taken, taken))
+
+	var rl = RealesrganLocal{Preserve: metadata.Fields{EXIF: true, ModTime: true}}
+	rl.copyMetadata(&job{image: &realesrgan.ImageConfig{SourceFile: original}, orientation: 6}, upsized)
+
+	var md, err = metadata.Read(upsized)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, md.EXIF)
+	assert.Equal(t, 1, metadata.Orientation(md.EXIF))
+
+	info, err := os.Stat(upsized)
+	assert.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(taken))
+
+	// nothing is copied when nothing is asked for
+	assert.NoError(t, os.WriteFile(upsized, testimages.FoxPNG, 0600))
+	rl.Preserve = metadata.Fields{}
+	rl.copyMetadata(&job{image: &realesrgan.ImageConfig{SourceFile: original}}, upsized)
+
+	data, err := os.ReadFile(upsized)
+	assert.NoError(t, err)
+	assert.Equal(t, testimages.FoxPNG, data)
+}
@@ -0,0 +1,150 @@
+package local
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	log "github.com/sirupsen/logrus"
+)
+
+// pass is one run of realesrgan in the chain that upsizes an image.
+type pass struct {
+	model string
+	// scale is passed as -s, 0 leaves it to realesrgan.
+	scale int
+	// duration is how long the pass took on the colors of the image.
+	duration time.Duration
+}
+
+// passModel is the model for the pass with index i, PassModels falls back to its last entry and then to ModelName.
+func (rl *RealesrganLocal) passModel(image *realesrgan.ImageConfig, i int) string {
+	if len(rl.PassModels) == 0 {
+		return image.ModelName
+	}
+	return rl.PassModels[min(i, len(rl.PassModels)-1)]
+}
+
+// planPasses decides which models run at which scales to upsize the image. With a target the chain stops as soon as
+// it is reached, and each pass uses the smallest scale that gets there. It reports false for images that already meet
+// the target, which are not upsized at all.
+func (rl *RealesrganLocal) planPasses(j *job) (bool, error) {
+
+	var target = j.image.Target
+	var passes = max(1, rl.Passes)
+	if !target.Enabled() && passes == 1 {
+		j.passes = []pass{{model: rl.passModel(j.image, 0)}}
+		return true, nil
+	}
+
+	// the input is turned the way the image is shown, which is what the target is for
+	var info, err = imagetype.Sniff(j.input)
+	if err != nil {
+		return false, err
+	}
+
+	if target.Enabled() && target.Meets(info.Width, info.Height) {
+		log.Infof("skipping %s, at %dx%d it already meets the target %s", j.image.SourceFile, info.Width, info.Height, target)
+		return false, nil
+	}
+
+	j.passes = nil
+	var width, height = info.Width, info.Height
+	for i := range passes {
+		if target.Enabled() && target.Meets(width, height) {
+			break
+		}
+
+		var p = pass{model: rl.passModel(j.image, i)}
+		var scales = realesrgan.ModelScales(p.model)
+		p.scale = scales[len(scales)-1]
+		if target.Enabled() {
+			p.scale = realesrgan.ScaleFor(p.model, target.Factor(width, height))
+		}
+
+		j.passes = append(j.passes, p)
+		width, height = width*p.scale, height*p.scale
+	}
+
+	if target.Enabled() && !target.Meets(width, height) {
+		log.Warnf("%d passes upsize %s to %dx%d, it will be stretched the rest of the way to %s", len(j.passes), j.image.SourceFile, width, height, target)
+	}
+	return true, nil
+}
+
+// runPasses upsizes run.SourceFile into run.UpsizedFile with the planned passes, the passes in between write to the
+// scratch dir. It returns how long each pass took.
+func (rl *RealesrganLocal) runPasses(j *job, run realesrgan.ImageConfig) ([]time.Duration, error) {
+
+	var ext = filepath.Ext(run.UpsizedFile)
+	var name = strings.TrimSuffix(filepath.Base(run.UpsizedFile), ext)
+
+	// a job that was never planned runs once with the settings of run
+	var passes = j.passes
+	if len(passes) == 0 {
+		passes = []pass{{model: run.ModelName, scale: run.Scale}}
+	}
+
+	var durations = make([]time.Duration, len(passes))
+	var input = run.SourceFile
+	for i, p := range passes {
+
+		var r = run
+		r.SourceFile = input
+		r.ModelName = p.model
+		r.Scale = p.scale
+		if i < len(passes)-1 {
+			r.UpsizedFile = filepath.Join(j.scratch, fmt.Sprintf("%s.pass%d.png", name, i+1))
+		}
+
+		var progress chan string
+		var forwarded = make(chan struct{})
+		if len(passes) > 1 {
+			progress = make(chan string)
+			r.Progress = progress
+			go func() {
+				defer close(forwarded)
+				chainProgress(progress, run.Progress, i, len(passes))
+			}()
+		}
+
+		var passLog = log.WithFields(log.Fields{"image": j.image.SourceFile, "pass": fmt.Sprintf("%d/%d", i+1, len(passes)), "model": p.model, "gpu": rl.gpuName(r.GpuId)})
+		passLog.Debug("starting pass")
+
+		var start = time.Now()
+		var err = realesrgan.Upsize(r)
+		durations[i] = time.Since(start)
+		if progress != nil {
+			close(progress)
+			<-forwarded
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pass %d of %d: %w", i+1, len(passes), err)
+		}
+
+		if rl.PassTimeGauge != nil {
+			rl.PassTimeGauge.WithLabelValues(strconv.Itoa(i+1), p.model).Set(durations[i].Seconds())
+		}
+		passLog.Debugf("finished pass in %s", durations[i])
+
+		input = r.UpsizedFile
+	}
+
+	return durations, nil
+}
+
+// chainProgress turns the progress of one pass into the progress of the whole chain, so the image goes from 0% to
+// 100% once however many passes it takes.
+func chainProgress(pass <-chan string, chain chan<- string, i, passes int) {
+	for pct := range pass {
+		var value float64
+		if _, err := fmt.Sscanf(pct, "%f%%", &value); err != nil {
+			continue
+		}
+		chain <- fmt.Sprintf("%.2f%%", (float64(i)+value/100)/float64(passes)*100)
+	}
+}
@@ -0,0 +1,81 @@
+package local
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kmulvey/realesrgan-scheduler/pkg/geometry"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	"github.com/stretchr/testify/assert"
+)
+
+// loggingRealesrgan is copyRealesrgan that also writes the model and scale of each run to realesrgan.log.
+const loggingRealesrgan = `#!/bin/sh
+log="$(dirname "$0")/realesrgan.log"
+while [ $# -gt 0 ]; do
+	case "$1" in
+		-i) in="$2" ;;
+		-o) out="$2" ;;
+		-n) model="$2" ;;
+		-s) scale="$2" ;;
+	esac
+	shift
+done
+echo "$model $scale $(basename "$out")" >> "$log"
+echo "50.00%"
+cp "$in" "$out"
+`
+
+func TestPasses(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var realesrganPath = filepath.Join(dir, "realesrgan")
+	assert.NoError(t, os.WriteFile(realesrganPath, []byte(loggingRealesrgan), 0700)) //nolint:gosec
+
+	var icon = filepath.Join(dir, "icon.png")
+	assert.NoError(t, writePNG(image.NewGray(image.Rect(0, 0, 64, 48)), icon))
+
+	var rl = RealesrganLocal{ScratchDir: dir, ModelName: "realesrgan-x4plus", RealesrganPath: realesrganPath, Passes: 3, PassModels: []string{"realesr-animevideov3"}}
+	var newJob = func(target geometry.Target) *job {
+		var j, err = rl.newJob(&realesrgan.ImageConfig{SourceFile: icon, ModelName: rl.ModelName, Target: target})
+		assert.NoError(t, err)
+		upsize, err := rl.planPasses(j)
+		assert.NoError(t, err)
+		assert.True(t, upsize)
+		return j
+	}
+
+	// without a target every pass runs
+	var j = newJob(geometry.Target{})
+	assert.Equal(t, []pass{{model: "realesr-animevideov3", scale: 4}, {model: "realesr-animevideov3", scale: 4}, {model: "realesr-animevideov3", scale: 4}}, j.passes)
+	assert.NoError(t, j.cleanup())
+
+	// 64 -> 256 -> 768 reaches the long edge, the second pass only needs 3x
+	rl.PassModels = []string{"realesrgan-x4plus", "realesr-animevideov3"}
+	j = newJob(geometry.Target{Mode: geometry.Fit, Width: 700, Height: 700})
+	assert.Equal(t, []pass{{model: "realesrgan-x4plus", scale: 4}, {model: "realesr-animevideov3", scale: 3}}, j.passes)
+
+	var progress = make(chan string, 10)
+	var run = realesrgan.ImageConfig{SourceFile: icon, UpsizedFile: filepath.Join(dir, "out", "icon.png"), RealesrganPath: realesrganPath, Progress: progress}
+	durations, err := rl.runPasses(j, run)
+	assert.NoError(t, err)
+	assert.Len(t, durations, 2)
+	assert.FileExists(t, run.UpsizedFile)
+	assert.NoError(t, j.cleanup())
+
+	calls, err := os.ReadFile(filepath.Join(dir, "realesrgan.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"realesrgan-x4plus 4 icon.pass1.png", "realesr-animevideov3 3 icon.png"}, strings.Split(strings.TrimSpace(string(calls)), "\n"))
+
+	// the progress of each pass is a share of the whole
+	close(progress)
+	var reported []string
+	for pct := range progress {
+		reported = append(reported, pct)
+	}
+	assert.Equal(t, []string{"25.00%", "50.00%", "75.00%", "100.00%"}, reported)
+}
@@ -0,0 +1,194 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kmulvey/realesrgan-scheduler/internal/cache"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/provenance"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	log "github.com/sirupsen/logrus"
+	_ "golang.org/x/image/webp"
+)
+
+// UnknownModel is the model name given to upsized images that were made before models were tracked.
+const UnknownModel = "unknown"
+
+// NeedsReprocess reports whether the existing upsized image was made with a model other than rl.ModelName.
+// The model comes from the cache, or from the sidecar of images the cache does not know. Images that were upsized
+// before models were tracked are only reprocessed if includeUntracked is set.
+func (rl *RealesrganLocal) NeedsReprocess(upsizedFile string, includeUntracked bool) (bool, error) {
+
+	if rl.Cache == nil {
+		return false, errors.New("reprocessing requires a cache to look up models")
+	}
+
+	var output, found, err = rl.Cache.GetOutput(upsizedFile)
+	if err != nil {
+		return false, err
+	}
+
+	if !found {
+		var record, err = provenance.Read(upsizedFile)
+		if err != nil || record.ModelName == "" {
+			return includeUntracked, nil
+		}
+		return record.ModelName != rl.ModelName, nil
+	}
+
+	return output.ModelName != rl.ModelName, nil
+}
+
+// AddReprocessImage queues an image whose upsized file already exists. The new version is written to a staging file
+// and only replaces the existing one after it has been verified.
+func (rl *RealesrganLocal) AddReprocessImage(image *realesrgan.ImageConfig) error {
+
+	image.ReplaceFile = image.UpsizedFile
+	image.UpsizedFile = stagingPath(image.UpsizedFile)
+
+	// a staging file left over from an interrupted run cannot be trusted
+	if err := os.Remove(image.UpsizedFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing stale staging file %s: %w", image.UpsizedFile, err)
+	}
+
+	return rl.AddImage(image)
+}
+
+// replace verifies the staged upsized image and moves it over the old version, which is either renamed or deleted.
+// A staged image that fails verification is removed and the failure recorded, so it does not block the next attempt.
+func (rl *RealesrganLocal) replace(image *realesrgan.ImageConfig) error {
+
+	if err := verifyImage(image.UpsizedFile); err != nil {
+		err = fmt.Errorf("new version of %s failed verification, keeping the old one: %w", image.ReplaceFile, err)
+		rl.recordFailure(image, err)
+		if err := os.Remove(image.UpsizedFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Errorf("error removing staging file %s: %s", image.UpsizedFile, err)
+		}
+		return err
+	}
+
+	if rl.KeepOldVersions {
+		var oldModel = UnknownModel
+		var output cache.Output
+		var found bool
+		if rl.Cache != nil {
+			var err error
+			if output, found, err = rl.Cache.GetOutput(image.ReplaceFile); err == nil && found {
+				oldModel = output.ModelName
+			}
+		}
+		if record, err := provenance.Read(image.ReplaceFile); oldModel == UnknownModel && err == nil && record.ModelName != "" {
+			oldModel = record.ModelName
+		}
+
+		var versioned = versionedPath(image.ReplaceFile, oldModel)
+		if err := os.Rename(image.ReplaceFile, versioned); err != nil {
+			return fmt.Errorf("error versioning old upsized image: %w", err)
+		}
+		if err := provenance.Rename(image.ReplaceFile, versioned); err != nil {
+			return err
+		}
+		// the record of the new version replaces this one, so the old version keeps a copy under its new name
+		if found {
+			output.UpsizedFile = versioned
+			if err := rl.Cache.RecordOutput(versioned, output); err != nil {
+				log.Errorf("error recording old version %s in the cache: %s", versioned, err)
+			}
+		}
+	}
+
+	if err := os.Rename(image.UpsizedFile, image.ReplaceFile); err != nil {
+		return fmt.Errorf("error moving %s into place: %w", image.UpsizedFile, err)
+	}
+
+	return nil
+}
+
+// stagingPath returns a hidden sibling of upsizedFile with the same extension, so realesrgan writes the same format.
+func stagingPath(upsizedFile string) string {
+	var ext = filepath.Ext(upsizedFile)
+	var name = strings.TrimSuffix(filepath.Base(upsizedFile), ext)
+	return filepath.Join(filepath.Dir(upsizedFile), "."+name+".reprocess"+ext)
+}
+
+// versionedPath returns the name an old upsized image is kept under, e.g. fox.jpg -> fox.realesrgan-x4plus.jpg
+func versionedPath(upsizedFile, modelName string) string {
+	var ext = filepath.Ext(upsizedFile)
+	return strings.TrimSuffix(upsizedFile, ext) + "." + modelName + ext
+}
+
+// VersionOf returns the upsized image that file would be an old version of if it was named by versionedPath, e.g.
+// fox.realesrgan-x4plus.jpg -> fox.jpg, or "" when it has no model in its name.
+func VersionOf(file string) string {
+	var ext = filepath.Ext(file)
+	var name = strings.TrimSuffix(file, ext)
+	var model = filepath.Ext(name)
+	if len(model) < 2 {
+		return ""
+	}
+	return strings.TrimSuffix(name, model) + ext
+}
+
+// OldVersionOf returns the upsized image file was kept as an old version of, see VersionOf, or "" if it is not one.
+// The part of its name VersionOf strips has to be the model that made it, by its output record in store or its sidecar,
+// so a file like IMG_1.edited.jpg is not taken for a version of IMG_1.jpg. Old versions named UnknownModel have no
+// record, so that name is enough.
+func OldVersionOf(store cache.Store, file string) string {
+
+	var current = VersionOf(file)
+	if current == "" {
+		return ""
+	}
+
+	var model = strings.TrimPrefix(filepath.Ext(strings.TrimSuffix(file, filepath.Ext(file))), ".")
+	if model == UnknownModel {
+		return current
+	}
+
+	if store != nil {
+		if output, found, err := store.GetOutput(file); err == nil && found {
+			if output.ModelName == model {
+				return current
+			}
+			return ""
+		}
+	}
+	if record, err := provenance.Read(file); err == nil && record.ModelName == model {
+		return current
+	}
+	return ""
+}
+
+// verifyImage makes sure the file exists, is not empty and has a readable image header.
+func verifyImage(imagePath string) error {
+
+	var file, err = os.Open(imagePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("%s is empty", imagePath)
+	}
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return fmt.Errorf("error decoding %s: %w", imagePath, err)
+	}
+	if config.Width == 0 || config.Height == 0 {
+		return fmt.Errorf("%s has no pixels", imagePath)
+	}
+
+	return nil
+}
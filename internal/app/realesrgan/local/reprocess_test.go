@@ -0,0 +1,74 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kmulvey/realesrgan-scheduler/internal/cache"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/provenance"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	"github.com/kmulvey/realesrgan-scheduler/testimages"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReprocess(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var db = cache.NewMemory()
+	var rl = RealesrganLocal{ModelName: "realesr-animevideov3", KeepOldVersions: true, Cache: db}
+
+	var upsized = filepath.Join(dir, "fox.jpg")
+	assert.NoError(t, os.WriteFile(upsized, testimages.FoxJPG, 0600))
+
+	var needsReprocess, err = rl.NeedsReprocess(upsized, false)
+	assert.NoError(t, err)
+	assert.False(t, needsReprocess)
+
+	needsReprocess, err = rl.NeedsReprocess(upsized, true)
+	assert.NoError(t, err)
+	assert.True(t, needsReprocess)
+
+	// the sidecar knows the model when the cache does not
+	assert.NoError(t, provenance.Write(upsized, provenance.JSON, provenance.Record{ModelName: rl.ModelName}))
+	needsReprocess, err = rl.NeedsReprocess(upsized, true)
+	assert.NoError(t, err)
+	assert.False(t, needsReprocess)
+
+	assert.NoError(t, db.RecordOutput(upsized, cache.Output{ModelName: "realesrgan-x4plus", UpsizedAt: time.Now()}))
+	needsReprocess, err = rl.NeedsReprocess(upsized, false)
+	assert.NoError(t, err)
+	assert.True(t, needsReprocess)
+
+	var original = filepath.Join(dir, "original.jpg")
+	assert.NoError(t, os.WriteFile(original, testimages.FoxJPG, 0600))
+	var image = &realesrgan.ImageConfig{SourceFile: original, ReplaceFile: upsized, UpsizedFile: stagingPath(upsized)}
+	assert.Equal(t, filepath.Join(dir, ".fox.reprocess.jpg"), image.UpsizedFile)
+
+	// a broken staging file must not replace the old version
+	assert.NoError(t, os.WriteFile(image.UpsizedFile, testimages.NotAnImage, 0600))
+	assert.Error(t, rl.replace(image))
+	assert.FileExists(t, upsized)
+	assert.NoFileExists(t, image.UpsizedFile)
+	_, failed, err := db.GetFailure(original)
+	assert.NoError(t, err)
+	assert.True(t, failed)
+
+	assert.NoError(t, os.WriteFile(image.UpsizedFile, testimages.FoxPNG, 0600))
+	assert.NoError(t, rl.replace(image))
+	assert.NoFileExists(t, image.UpsizedFile)
+	assert.FileExists(t, filepath.Join(dir, "fox.realesrgan-x4plus.jpg"))
+	assert.FileExists(t, filepath.Join(dir, "fox.realesrgan-x4plus.jpg.json"))
+	assert.Equal(t, upsized, VersionOf(filepath.Join(dir, "fox.realesrgan-x4plus.jpg")))
+	assert.Equal(t, "", VersionOf(upsized))
+
+	// the cache keeps the record of the old version, so it is known to be one
+	assert.Equal(t, upsized, OldVersionOf(db, filepath.Join(dir, "fox.realesrgan-x4plus.jpg")))
+	assert.Equal(t, "", OldVersionOf(db, filepath.Join(dir, "fox.edited.jpg")))
+
+	replaced, err := os.ReadFile(upsized)
+	assert.NoError(t, err)
+	assert.Equal(t, testimages.FoxPNG, replaced)
+}
@@ -1,33 +1,288 @@
 package local
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/kmulvey/path"
+	"github.com/kmulvey/realesrgan-scheduler/internal/cache"
+	"github.com/kmulvey/realesrgan-scheduler/internal/gpu"
 	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/semaphore"
+	log "github.com/sirupsen/logrus"
 )
 
 // UpsizeQueue upsizes all the images in the queue using all available gpus.
 func (rl *RealesrganLocal) UpsizeQueue() {
 	var wg sync.WaitGroup
-	var semaphore = make(chan uint8, rl.NumGPUs)
-	for i := range rl.NumGPUs {
-		semaphore <- i
+	rl.pool = gpu.NewPool(rl.devices()...)
+	for _, device := range rl.pool.Devices() {
+		if rl.GPUInfoGauge != nil {
+			rl.GPUInfoGauge.WithLabelValues(strconv.Itoa(int(device.ID)), device.Name).Set(1)
+		}
+	}
+
+	if rl.Prefetch > 0 {
+		var err error
+		if rl.stage, err = newStage(rl.ScratchDir, rl.ScratchLimit); err != nil {
+			log.Errorf("error staging images, reading and writing them in place: %s", err)
+		}
+		defer func() {
+			if err := rl.stage.close(); err != nil {
+				log.Errorf("error removing staging dir: %s", err)
+			}
+			rl.stage = nil
+		}()
 	}
 
 	for rl.Queue.Len() > 0 {
 		var nextImage = rl.Queue.NextImage()
 		nextImage.Remaining = rl.Queue.Len() // set the remaining count for the image
-		nextImage.GpuId = <-semaphore
+
+		// the next images are copied while this one waits for a gpu
+		rl.stage.prefetch(append([]*realesrgan.ImageConfig{nextImage}, rl.Queue.Peek(rl.Prefetch)...))
+		var slot, err = rl.pool.Acquire(semaphore.WithHolder(context.Background(), nextImage.SourceFile), imageMegapixels(nextImage))
+		if err != nil {
+			log.Errorf("error getting a gpu for %s: %s", nextImage.SourceFile, err)
+			rl.Queue.Done(nextImage)
+			continue
+		}
+		nextImage.GpuId = slot.Device.ID
+		var batch = rl.nextBatch(nextImage)
 
 		wg.Add(1)
-		go func(image *realesrgan.ImageConfig) {
+		go func(batch []*realesrgan.ImageConfig) {
 			defer wg.Done()
-			defer func() { semaphore <- image.GpuId }() // release the gpu
+			defer rl.pool.Release(slot) // release the gpu
 
-			rl.files <- image // notify the file is being processed
-			realesrgan.Upsize(*image)
-		}(nextImage)
+			for _, image := range batch {
+				rl.files <- image // notify the file is being processed
+			}
+			if len(batch) == 1 {
+				rl.upsize(batch[0])
+			} else {
+				rl.upsizeBatch(batch)
+			}
+		}(batch)
 	}
 
 	wg.Wait()
 }
+
+// upsize runs realesrgan on a single image and records the outcome in the cache.
+func (rl *RealesrganLocal) upsize(image *realesrgan.ImageConfig) {
+
+	var job, run, ok = rl.prepare(image)
+	if !ok {
+		return
+	}
+
+	rl.runAlone(job, run)
+}
+
+// runAlone runs realesrgan for a prepared job on its own and delivers it.
+func (rl *RealesrganLocal) runAlone(job *job, run realesrgan.ImageConfig) {
+
+	var start = time.Now()
+	durations, err := rl.runUpsize(job, run)
+	if err != nil {
+		log.Errorf("error upsizing %s: %s", job.image.SourceFile, err)
+		rl.recordFailure(job.image, err)
+		rl.cleanup(job)
+		return
+	}
+
+	rl.deliver(job, run, durations, start)
+}
+
+// prepare gets the input of the image ready for realesrgan and returns what realesrgan should be run with. It reports
+// false when the image is not to be upsized, its job is then already cleaned up.
+func (rl *RealesrganLocal) prepare(image *realesrgan.ImageConfig) (j *job, run realesrgan.ImageConfig, ok bool) {
+
+	var err error
+	if j, err = rl.newJob(image); err != nil {
+		log.Errorf("error upsizing %s: %s", image.SourceFile, err)
+		rl.Queue.Done(image)
+		return nil, run, false
+	}
+	defer func() {
+		if !ok {
+			rl.cleanup(j)
+		}
+	}()
+
+	if err := rl.convertInput(j); err != nil {
+		log.Errorf("error converting %s: %s", image.SourceFile, err)
+		rl.recordFailure(image, err)
+		return j, run, false
+	}
+
+	if err := rl.orientInput(j); err != nil {
+		log.Errorf("error orienting %s: %s", image.SourceFile, err)
+		rl.recordFailure(image, err)
+		return j, run, false
+	}
+
+	if upsize, err := rl.planPasses(j); err != nil {
+		log.Errorf("error planning passes for %s: %s", image.SourceFile, err)
+		rl.recordFailure(image, err)
+		return j, run, false
+	} else if !upsize {
+		return j, run, false
+	}
+
+	if err := rl.planTiles(j); err != nil {
+		log.Errorf("error planning tiles for %s: %s", image.SourceFile, err)
+		rl.recordFailure(image, err)
+		return j, run, false
+	}
+
+	if err := rl.splitAlpha(j); err != nil {
+		log.Errorf("error splitting alpha of %s: %s", image.SourceFile, err)
+		rl.recordFailure(image, err)
+		return j, run, false
+	}
+
+	// realesrgan reads the converted input, everything else about the image stays the same
+	run = *image
+	run.SourceFile = j.input
+	if rl.intermediate(j) || rl.stage != nil {
+		if _, err := os.Stat(image.UpsizedFile); err == nil {
+			log.Errorf("error upsizing %s: file already exists: %s", image.SourceFile, image.UpsizedFile)
+			return j, run, false
+		}
+	}
+	if rl.intermediate(j) {
+		// realesrgan writes a lossless png that is stitched, resampled and encoded into the upsized image
+		run.UpsizedFile = filepath.Join(j.scratch, "upsized.png")
+	} else if rl.stage != nil {
+		// realesrgan writes to the scratch dir so the gpu does not wait on the upsized dir, it is moved there after
+		run.UpsizedFile = filepath.Join(j.scratch, "upsized"+filepath.Ext(image.UpsizedFile))
+	}
+
+	return j, run, true
+}
+
+// finish turns what realesrgan wrote for the job into the upsized image and records it. start is when realesrgan
+// was started and durations are how long each of its passes took.
+func (rl *RealesrganLocal) finish(job *job, run realesrgan.ImageConfig, durations []time.Duration, start time.Time) {
+
+	var image = job.image
+	for i, duration := range durations {
+		job.passes[i].duration = duration
+	}
+
+	// without its alpha channel the image is not finished, so it must not look upsized
+	if err := rl.mergeAlpha(job, run); err != nil {
+		log.Errorf("error merging alpha of %s: %s", image.SourceFile, err)
+		if err := os.Remove(run.UpsizedFile); err != nil {
+			log.Errorf("error removing %s: %s", run.UpsizedFile, err)
+		}
+		rl.recordFailure(image, err)
+		return
+	}
+
+	if err := rl.resample(job, run); err != nil {
+		log.Errorf("error resampling %s: %s", image.SourceFile, err)
+		rl.recordFailure(image, err)
+		return
+	}
+
+	if err := rl.encodeOutput(job, run); err != nil {
+		log.Errorf("error encoding %s: %s", image.UpsizedFile, err)
+		rl.recordFailure(image, err)
+		return
+	}
+
+	var duration = time.Since(start)
+	rl.UpsizeTimeGauge.Set(duration.Seconds())
+
+	rl.copyMetadata(job, image.UpsizedFile)
+
+	var upsizedFile = image.UpsizedFile
+	if image.ReplaceFile != "" {
+		if err := rl.replace(image); err != nil {
+			log.Errorf("error replacing %s: %s", image.ReplaceFile, err)
+			return
+		}
+		upsizedFile = image.ReplaceFile
+	}
+
+	rl.writeSidecar(job, upsizedFile, duration)
+
+	if rl.Cache == nil {
+		return
+	}
+
+	var output = cache.Output{
+		SourceFile: image.SourceFile,
+		ModelName:  image.ModelName,
+		Encoding:   job.encoding,
+		UpsizedAt:  time.Now(),
+	}
+	if err := rl.Cache.RecordOutput(upsizedFile, output); err != nil {
+		log.Errorf("error recording model for %s: %s", upsizedFile, err)
+	}
+}
+
+func (rl *RealesrganLocal) cleanup(j *job) {
+	if err := j.cleanup(); err != nil {
+		log.Errorf("error removing scratch dir %s: %s", j.scratch, err)
+	}
+	rl.stage.release(j.image)
+	rl.Queue.Done(j.image)
+}
+
+// recordFailure adds the image to the cache so it is skipped next time.
+func (rl *RealesrganLocal) recordFailure(image *realesrgan.ImageConfig, upsizeErr error) {
+
+	if rl.Cache == nil {
+		return
+	}
+
+	var entry, err = path.NewEntry(image.SourceFile, 0)
+	if err != nil {
+		log.Errorf("error getting entry for failed image %s: %s", image.SourceFile, err)
+		return
+	}
+
+	if err := rl.Cache.RecordFailure(entry, upsizeErr.Error()); err != nil {
+		log.Errorf("error adding failed image %s to cache: %s", image.SourceFile, err)
+	}
+}
+
+// intermediate reports whether realesrgan writes to the scratch dir rather than the upsized file, so the image can be
+// worked on before it is encoded.
+func (rl *RealesrganLocal) intermediate(j *job) bool {
+	return rl.Encoding.Enabled() || j.image.Target.Enabled() || j.tiled
+}
+
+// encodeOutput encodes what realesrgan wrote into the upsized image with rl.Encoding, or its defaults when only the
+// intermediate is needed. Without an intermediate it only moves what realesrgan wrote out of the staging scratch dir.
+func (rl *RealesrganLocal) encodeOutput(j *job, run realesrgan.ImageConfig) error {
+
+	if !rl.intermediate(j) {
+		if run.UpsizedFile != j.image.UpsizedFile {
+			return moveOutput(run.UpsizedFile, j.image.UpsizedFile)
+		}
+		return nil
+	}
+
+	var opts = rl.Encoding
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	var settings, err = opts.Encode(run.UpsizedFile, j.image.UpsizedFile)
+	if err != nil {
+		return err
+	}
+	j.encoding = settings.String()
+
+	log.Debugf("encoded %s: %s", j.image.UpsizedFile, j.encoding)
+	return nil
+}
@@ -0,0 +1,65 @@
+package local
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/provenance"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	log "github.com/sirupsen/logrus"
+	"go.szostok.io/version"
+)
+
+// writeSidecar records how upsizedFile was made next to it, in rl.Sidecar. Like copyMetadata it only logs errors and
+// leaves out what it cannot find.
+func (rl *RealesrganLocal) writeSidecar(j *job, upsizedFile string, duration time.Duration) {
+
+	if rl.Sidecar == provenance.None {
+		return
+	}
+
+	var record = provenance.Record{
+		SourceFile:       j.image.SourceFile,
+		ModelName:        j.image.ModelName,
+		GPU:              strconv.Itoa(int(j.image.GpuId)),
+		Encoding:         j.encoding,
+		Target:           j.image.Target.String(),
+		TileSize:         rl.tileSize(j),
+		Duration:         duration,
+		SchedulerVersion: version.Get().Version,
+		UpsizedAt:        time.Now(),
+	}
+
+	// with scales for every pass the total is known, otherwise it is worked out from the sizes below
+	record.Scale = 1
+	for _, p := range j.passes {
+		record.Scale *= p.scale
+	}
+	if len(j.passes) > 1 {
+		for _, p := range j.passes {
+			record.Passes = append(record.Passes, provenance.Pass{ModelName: p.model, Scale: p.scale, Duration: p.duration})
+		}
+	}
+
+	var err error
+	if record.SourceSHA256, err = provenance.HashFile(j.image.SourceFile); err != nil {
+		log.Errorf("error hashing %s for its sidecar: %s", j.image.SourceFile, err)
+	}
+
+	if record.Backend, record.BackendVersion, err = realesrgan.BinaryVersion(j.image.RealesrganPath); err != nil {
+		log.Errorf("error identifying realesrgan for the sidecar of %s: %s", upsizedFile, err)
+	}
+
+	// the input is what realesrgan was given, so it is already turned the way the output is
+	input, inputErr := imagetype.Sniff(j.input)
+	output, outputErr := imagetype.Sniff(upsizedFile)
+	if record.Scale == 0 && inputErr == nil && outputErr == nil && input.Width > 0 {
+		record.Scale = int(math.Round(float64(output.Width) / float64(input.Width)))
+	}
+
+	if err := provenance.Write(upsizedFile, rl.Sidecar, record); err != nil {
+		log.Errorf("error writing sidecar of %s: %s", upsizedFile, err)
+	}
+}
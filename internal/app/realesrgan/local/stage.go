@@ -0,0 +1,189 @@
+package local
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultScratchLimit is how much of the scratch dir prefetched inputs and finished outputs can take up when
+// ScratchLimit is not set.
+const DefaultScratchLimit = 4 << 30
+
+// stage keeps the gpus busy while images are read and written: it copies the next inputs in the queue to the scratch
+// dir before a gpu gets to them, and lets images be finished and moved to the upsized dir after their gpu has been
+// released. Everything it keeps in the scratch dir counts against its limit, what does not fit is read or written
+// where it is, as if there were no stage. A nil stage does nothing.
+type stage struct {
+	dir   string
+	limit int64
+
+	lock   sync.Mutex
+	used   int64
+	count  int
+	inputs map[string]*stagedInput
+	// finishing are the images being finished in the background.
+	finishing sync.WaitGroup
+}
+
+// stagedInput is the copy of an original in the scratch dir, ready is closed once it has been copied.
+type stagedInput struct {
+	path  string
+	size  int64
+	ready chan struct{}
+	err   error
+}
+
+func newStage(scratchDir string, limit int64) (*stage, error) {
+
+	var dir, err = os.MkdirTemp(scratchDir, "realesrgan-stage-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating staging dir: %w", err)
+	}
+
+	if limit <= 0 {
+		limit = DefaultScratchLimit
+	}
+	return &stage{dir: dir, limit: limit, inputs: make(map[string]*stagedInput)}, nil
+}
+
+// reserve takes size bytes of the limit if they are free.
+func (s *stage) reserve(size int64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.used+size > s.limit {
+		return false
+	}
+	s.used += size
+	return true
+}
+
+func (s *stage) free(size int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.used -= size
+}
+
+// prefetch starts copying the originals of images to the stage, unless they are already there or do not fit.
+func (s *stage) prefetch(images []*realesrgan.ImageConfig) {
+
+	if s == nil {
+		return
+	}
+
+	for _, image := range images {
+		var info, err = os.Stat(image.SourceFile)
+		if err != nil {
+			continue
+		}
+
+		s.lock.Lock()
+		if _, ok := s.inputs[image.SourceFile]; ok || s.used+info.Size() > s.limit {
+			s.lock.Unlock()
+			continue
+		}
+		s.used += info.Size()
+		s.count++
+		var staged = &stagedInput{
+			path:  filepath.Join(s.dir, fmt.Sprintf("%d%s", s.count, filepath.Ext(image.SourceFile))),
+			size:  info.Size(),
+			ready: make(chan struct{}),
+		}
+		s.inputs[image.SourceFile] = staged
+		s.lock.Unlock()
+
+		go func(source string) {
+			defer close(staged.ready)
+			if staged.err = copyFile(source, staged.path); staged.err != nil {
+				log.Errorf("error prefetching %s: %s", source, staged.err)
+			}
+		}(image.SourceFile)
+	}
+}
+
+// input returns the copy of the original of image once it is ready, or the original if it was not prefetched.
+func (s *stage) input(image *realesrgan.ImageConfig) string {
+
+	if s == nil {
+		return image.SourceFile
+	}
+
+	s.lock.Lock()
+	var staged, ok = s.inputs[image.SourceFile]
+	s.lock.Unlock()
+	if !ok {
+		return image.SourceFile
+	}
+
+	<-staged.ready
+	if staged.err != nil {
+		return image.SourceFile
+	}
+	return staged.path
+}
+
+// release removes the copy of the original of image, once its job no longer needs it.
+func (s *stage) release(image *realesrgan.ImageConfig) {
+
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	var staged, ok = s.inputs[image.SourceFile]
+	delete(s.inputs, image.SourceFile)
+	s.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	<-staged.ready
+	if err := os.Remove(staged.path); err != nil && !os.IsNotExist(err) {
+		log.Errorf("error removing prefetched %s: %s", staged.path, err)
+	}
+	s.free(staged.size)
+}
+
+// close waits for the images being finished and removes the staging dir.
+func (s *stage) close() error {
+	if s == nil {
+		return nil
+	}
+	s.finishing.Wait()
+	return os.RemoveAll(s.dir)
+}
+
+// deliver finishes the job, in the background if what realesrgan wrote is in the scratch dir and fits in the limit,
+// so the gpu can go on to the next image while it is being encoded and moved to the upsized dir. The job is cleaned up
+// once it is finished, it must not be used after.
+func (rl *RealesrganLocal) deliver(j *job, run realesrgan.ImageConfig, durations []time.Duration, start time.Time) {
+
+	// putting the alpha channel back needs the gpu again
+	var s = rl.stage
+	if s == nil || j.alpha != "" || run.UpsizedFile == j.image.UpsizedFile {
+		rl.finish(j, run, durations, start)
+		rl.cleanup(j)
+		return
+	}
+
+	var info, err = os.Stat(run.UpsizedFile)
+	if err != nil || !s.reserve(info.Size()) {
+		rl.finish(j, run, durations, start)
+		rl.cleanup(j)
+		return
+	}
+
+	s.finishing.Add(1)
+	go func() {
+		defer s.finishing.Done()
+		defer s.free(info.Size())
+		rl.finish(j, run, durations, start)
+		rl.cleanup(j)
+	}()
+}
@@ -0,0 +1,95 @@
+package local
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kmulvey/realesrgan-scheduler/internal/queue"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// inputRealesrgan is copyRealesrgan that also writes the dir of each input to realesrgan.log.
+const inputRealesrgan = `#!/bin/sh
+log="$(dirname "$0")/realesrgan.log"
+while [ $# -gt 0 ]; do
+	case "$1" in
+		-i) in="$2" ;;
+		-o) out="$2" ;;
+	esac
+	shift
+done
+echo "$(basename "$(dirname "$in")")" >> "$log"
+cp "$in" "$out"
+`
+
+func TestStage(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var realesrganPath = filepath.Join(dir, "realesrgan")
+	assert.NoError(t, os.WriteFile(realesrganPath, []byte(inputRealesrgan), 0700)) //nolint:gosec
+	var scratch = filepath.Join(dir, "scratch")
+	assert.NoError(t, os.Mkdir(scratch, os.ModePerm))
+
+	var files = make(chan *realesrgan.ImageConfig)
+	var rl = RealesrganLocal{
+		ScratchDir:      scratch,
+		ModelName:       "realesrgan-x4plus",
+		RealesrganPath:  realesrganPath,
+		NumGPUs:         1,
+		Prefetch:        2,
+		UpsizeTimeGauge: prometheus.NewGauge(prometheus.GaugeOpts{Name: "upsize_time"}),
+		Queue:           queue.New(false),
+		files:           files,
+	}
+	go func() {
+		for f := range files {
+			go func(f *realesrgan.ImageConfig) {
+				for range f.Progress {
+				}
+			}(f)
+		}
+	}()
+
+	var images []*realesrgan.ImageConfig
+	for i := range 3 {
+		var original = filepath.Join(dir, fmt.Sprintf("image%d.png", i))
+		assert.NoError(t, writePNG(image.NewNRGBA(image.Rect(0, 0, 8+i, 8)), original))
+
+		var img = &realesrgan.ImageConfig{SourceFile: original, UpsizedFile: filepath.Join(dir, "upsized", fmt.Sprintf("image%d.png", i)), Progress: make(chan string)}
+		assert.NoError(t, rl.AddImage(img))
+		images = append(images, img)
+	}
+	rl.UpsizeQueue()
+	close(files)
+
+	// every image was read from its prefetched copy and ended up in the upsized dir
+	log, err := os.ReadFile(filepath.Join(dir, "realesrgan.log"))
+	assert.NoError(t, err)
+	var inputDirs = strings.Fields(string(log))
+	assert.Len(t, inputDirs, 3)
+	for _, inputDir := range inputDirs {
+		assert.True(t, strings.HasPrefix(inputDir, "realesrgan-stage-"), inputDir)
+	}
+	for _, img := range images {
+		assert.FileExists(t, img.UpsizedFile)
+	}
+
+	// nothing is left in the scratch dir
+	entries, err := os.ReadDir(scratch)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// originals that do not fit are read in place
+	s, err := newStage(scratch, 10)
+	assert.NoError(t, err)
+	s.prefetch(images[:1])
+	assert.Equal(t, images[0].SourceFile, s.input(images[0]))
+	assert.NoError(t, s.close())
+}
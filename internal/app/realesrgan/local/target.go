@@ -0,0 +1,47 @@
+package local
+
+import (
+	"github.com/kmulvey/realesrgan-scheduler/pkg/geometry"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/metadata"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+)
+
+// MeetsTarget reports whether original is already as big as target, so it can be left out when images are listed
+// instead of being found by every scan only for planPasses to skip it. The size is the way the original is shown,
+// after its EXIF orientation.
+func MeetsTarget(original string, target geometry.Target) bool {
+
+	if !target.Enabled() {
+		return false
+	}
+
+	var info, err = imagetype.Sniff(original)
+	if err != nil {
+		return false
+	}
+
+	var width, height = info.Width, info.Height
+	if info.Format == imagetype.JPEG || info.Format == imagetype.WebP {
+		// orientations 5 to 8 turn the image on its side
+		if exif, err := metadata.ReadEXIF(original); err == nil && metadata.Orientation(exif) >= 5 {
+			width, height = height, width
+		}
+	}
+	return target.Meets(width, height)
+}
+
+// resample scales what realesrgan wrote in place to the exact target of the image.
+func (rl *RealesrganLocal) resample(j *job, run realesrgan.ImageConfig) error {
+
+	if !j.image.Target.Enabled() {
+		return nil
+	}
+
+	var img, err = decodeFile(run.UpsizedFile)
+	if err != nil {
+		return err
+	}
+
+	return writePNG(j.image.Target.Resample(img), run.UpsizedFile)
+}
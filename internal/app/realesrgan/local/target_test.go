@@ -0,0 +1,76 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kmulvey/realesrgan-scheduler/internal/queue"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/geometry"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	"github.com/kmulvey/realesrgan-scheduler/testimages"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTarget(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var realesrganPath = filepath.Join(dir, "realesrgan")
+	assert.NoError(t, os.WriteFile(realesrganPath, []byte(copyRealesrgan), 0700)) //nolint:gosec
+
+	var original = filepath.Join(dir, "fox.jpg")
+	assert.NoError(t, os.WriteFile(original, testimages.FoxJPG, 0600))
+
+	var rl = RealesrganLocal{
+		ScratchDir:      dir,
+		ModelName:       "realesr-animevideov3",
+		RealesrganPath:  realesrganPath,
+		UpsizeTimeGauge: prometheus.NewGauge(prometheus.GaugeOpts{Name: "upsize_time"}),
+		Target:          geometry.Target{Mode: geometry.Fit, Width: 100, Height: 100},
+		Queue:           queue.New(false),
+	}
+
+	// the fox is already bigger than this
+	var image = &realesrgan.ImageConfig{SourceFile: original, UpsizedFile: filepath.Join(dir, "small", "fox.jpg"), Progress: make(chan string, 10)}
+	assert.NoError(t, rl.AddImage(image))
+	rl.upsize(image)
+	assert.NoFileExists(t, image.UpsizedFile)
+
+	// twice the size needs the x2 model, the fake realesrgan does not upsize so resampling does it all
+	image = &realesrgan.ImageConfig{SourceFile: original, UpsizedFile: filepath.Join(dir, "big", "fox.jpg"), Progress: make(chan string, 10)}
+	image.Target = geometry.Target{Mode: geometry.Crop, Width: 2000, Height: 1600}
+	assert.NoError(t, rl.AddImage(image))
+
+	var j, err = rl.newJob(image)
+	assert.NoError(t, err)
+	upsize, err := rl.planPasses(j)
+	assert.NoError(t, err)
+	assert.True(t, upsize)
+	assert.Equal(t, []pass{{model: rl.ModelName, scale: 2}}, j.passes)
+	assert.NoError(t, j.cleanup())
+
+	rl.upsize(image)
+	info, err := imagetype.Sniff(image.UpsizedFile)
+	assert.NoError(t, err)
+	assert.Equal(t, imagetype.Info{Format: imagetype.JPEG, Width: 2000, Height: 1600, Depth: 8}, info)
+}
+
+func TestMeetsTarget(t *testing.T) {
+	t.Parallel()
+
+	var original = filepath.Join(t.TempDir(), "fox.jpg")
+	assert.NoError(t, os.WriteFile(original, testimages.FoxJPG, 0600))
+
+	var small, err = geometry.Parse("long:100")
+	assert.NoError(t, err)
+	big, err := geometry.Parse("long:10000")
+	assert.NoError(t, err)
+
+	assert.True(t, MeetsTarget(original, small))
+	assert.False(t, MeetsTarget(original, big))
+	assert.False(t, MeetsTarget(original, geometry.Target{}))
+	assert.False(t, MeetsTarget(filepath.Join(t.TempDir(), "missing.jpg"), small))
+}
@@ -0,0 +1,255 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/semaphore"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultTileOverlap is how many pixels of the original neighbouring tiles share, enough for the models to see
+// past the edge of each tile and for the seams to be blended away.
+const DefaultTileOverlap = 32
+
+// DefaultTileMaxPixels is the biggest input that is tiled when TileMaxPixels is not set. The tiles are stitched in
+// memory at 4 bytes per upsized pixel, so at 4x this is about 4 GiB.
+const DefaultTileMaxPixels = 64 * 1024 * 1024
+
+// tile is the part of the input one sub-job upsizes.
+type tile struct {
+	// core is the part of the input the tile is responsible for, rect is core grown by the overlap.
+	core, rect image.Rectangle
+	input      string
+	output     string
+}
+
+// planTiles decides whether the input is big enough to be upsized in tiles.
+func (rl *RealesrganLocal) planTiles(j *job) error {
+
+	if rl.TileSize <= 0 {
+		return nil
+	}
+
+	var info, err = imagetype.Sniff(j.input)
+	if err != nil {
+		return err
+	}
+
+	j.tiled = info.Width > rl.TileSize || info.Height > rl.TileSize
+	if !j.tiled {
+		return nil
+	}
+
+	var maxPixels = rl.TileMaxPixels
+	if maxPixels <= 0 {
+		maxPixels = DefaultTileMaxPixels
+	}
+	if info.Width*info.Height > maxPixels {
+		return fmt.Errorf("%dx%d is too big to stitch in memory, the most is %d pixels", info.Width, info.Height, maxPixels)
+	}
+	return nil
+}
+
+// tileSize is the size of the tiles j was split into, 0 if it was not.
+func (rl *RealesrganLocal) tileSize(j *job) int {
+	if !j.tiled {
+		return 0
+	}
+	return rl.TileSize
+}
+
+// tileGrid splits bounds into tiles of size, each grown by overlap on the sides that have a neighbour.
+func tileGrid(bounds image.Rectangle, size, overlap int) []tile {
+
+	var tiles []tile
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += size {
+		for x := bounds.Min.X; x < bounds.Max.X; x += size {
+			var core = image.Rect(x, y, x+size, y+size).Intersect(bounds)
+			var rect = image.Rect(core.Min.X-overlap, core.Min.Y-overlap, core.Max.X+overlap, core.Max.Y+overlap).Intersect(bounds)
+			tiles = append(tiles, tile{core: core, rect: rect})
+		}
+	}
+	return tiles
+}
+
+// runUpsize upsizes run.SourceFile into run.UpsizedFile, in tiles if the job is tiled.
+func (rl *RealesrganLocal) runUpsize(j *job, run realesrgan.ImageConfig) ([]time.Duration, error) {
+	if j.tiled {
+		return rl.runTiled(j, run)
+	}
+	return rl.runPasses(j, run)
+}
+
+// runTiled upsizes each tile of run.SourceFile as a sub-job and stitches them back together into run.UpsizedFile,
+// which must be a png. Tiles run on the gpu of the image and on any other gpu that is free, so one huge image can use
+// them all. It returns the time each pass took, summed over the tiles.
+func (rl *RealesrganLocal) runTiled(j *job, run realesrgan.ImageConfig) ([]time.Duration, error) {
+
+	var img, err = decodeFile(run.SourceFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var overlap = rl.TileOverlap
+	if overlap <= 0 {
+		overlap = DefaultTileOverlap
+	}
+
+	var name = filepath.Base(run.UpsizedFile)
+	var tiles = tileGrid(img.Bounds(), rl.TileSize, overlap)
+	for i := range tiles {
+		tiles[i].input = filepath.Join(j.scratch, fmt.Sprintf("%s.tile%d.png", name, i))
+		tiles[i].output = filepath.Join(j.scratch, fmt.Sprintf("%s.tile%d.upsized.png", name, i))
+		var sub = img.(interface {
+			SubImage(image.Rectangle) image.Image
+		}).SubImage(tiles[i].rect)
+		if err := writePNG(sub, tiles[i].input); err != nil {
+			return nil, err
+		}
+	}
+	img = nil // the stitched image is 16 times bigger, do not hold on to this too
+
+	// the gpu of the image is always there to fall back on, the others only when they are free
+	var own = make(chan uint8, 1)
+	own <- run.GpuId
+	var releaseOwn = func(gpu uint8) func() {
+		return func() { own <- gpu }
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	var durations []time.Duration
+	var done int
+	for i, t := range tiles {
+		var gpu uint8
+		var release func()
+		select {
+		case gpu = <-own:
+			release = releaseOwn(gpu)
+		default:
+			if slot, ok := rl.pool.TryAcquire(semaphore.WithHolder(context.Background(), j.image.SourceFile), megapixels(t.rect.Dx(), t.rect.Dy())); ok {
+				gpu = slot.Device.ID
+				release = func() { rl.pool.Release(slot) }
+			} else {
+				gpu = <-own
+				release = releaseOwn(gpu)
+			}
+		}
+
+		wg.Add(1)
+		go func(i int, t tile, gpu uint8, release func()) {
+			defer wg.Done()
+			defer release()
+
+			var r = run
+			r.SourceFile, r.UpsizedFile, r.GpuId = t.input, t.output, gpu
+			r.Progress = make(chan string)
+			go func(progress chan string) {
+				for range progress {
+				}
+			}(r.Progress)
+
+			log.WithFields(log.Fields{"image": j.image.SourceFile, "tile": fmt.Sprintf("%d/%d", i+1, len(tiles)), "gpu": rl.gpuName(gpu)}).Debug("upsizing tile")
+			var tileDurations, err = rl.runPasses(j, r)
+			close(r.Progress)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("tile %d of %d: %w", i+1, len(tiles), err))
+				return
+			}
+			if durations == nil {
+				durations = make([]time.Duration, len(tileDurations))
+			}
+			for p, d := range tileDurations {
+				durations[p] += d
+			}
+
+			done++
+			if run.Progress != nil {
+				run.Progress <- fmt.Sprintf("%.2f%%", float64(done)/float64(len(tiles))*100)
+			}
+		}(i, t, gpu, release)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	stitched, err := stitch(tiles)
+	if err != nil {
+		return nil, err
+	}
+	return durations, writePNG(stitched, run.UpsizedFile)
+}
+
+// stitch puts the upsized tiles back together in the order they were cut. Where a tile overlaps the ones before it,
+// it fades in over the overlap so there is no seam. The whole upsized image is held in memory, see TileMaxPixels.
+func stitch(tiles []tile) (*image.NRGBA, error) {
+
+	var out *image.NRGBA
+	var scale int
+	for _, t := range tiles {
+		var img, err = decodeFile(t.output)
+		if err != nil {
+			return nil, err
+		}
+
+		var bounds = img.Bounds()
+		if out == nil {
+			scale = bounds.Dx() / t.rect.Dx()
+			var total = t.rect
+			for _, other := range tiles {
+				total = total.Union(other.rect)
+			}
+			out = image.NewNRGBA(image.Rectangle{Min: total.Min.Mul(scale), Max: total.Max.Mul(scale)})
+		}
+		if bounds.Dx() != t.rect.Dx()*scale || bounds.Dy() != t.rect.Dy()*scale {
+			return nil, fmt.Errorf("tile %s was upsized to %s, expected %dx", t.rect, bounds.Size(), scale)
+		}
+
+		// the ramps run across the overlap with the tiles before this one, to the left and above
+		var rect = image.Rectangle{Min: t.rect.Min.Mul(scale), Max: t.rect.Max.Mul(scale)}
+		// with the overlap on both sides of the cut that is twice the overlap, unless the tile is not that big
+		var rampX = min(2*(t.core.Min.X-t.rect.Min.X)*scale, rect.Dx())
+		var rampY = min(2*(t.core.Min.Y-t.rect.Min.Y)*scale, rect.Dy())
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			var wy = ramp(y-rect.Min.Y, rampY)
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				var w = wy * ramp(x-rect.Min.X, rampX)
+				var c = color.NRGBAModel.Convert(img.At(bounds.Min.X+x-rect.Min.X, bounds.Min.Y+y-rect.Min.Y)).(color.NRGBA)
+				if w < 1 {
+					c = blend(out.NRGBAAt(x, y), c, w)
+				}
+				out.SetNRGBA(x, y, c)
+			}
+		}
+	}
+	return out, nil
+}
+
+// ramp is the weight of a new tile at distance d into an overlap of width, going from 0 to 1 across it.
+func ramp(d, width int) float64 {
+	if width <= 0 || d >= width {
+		return 1
+	}
+	return (float64(d) + 0.5) / float64(width)
+}
+
+func blend(a, b color.NRGBA, w float64) color.NRGBA {
+	var mix = func(x, y uint8) uint8 {
+		return uint8(float64(x)*(1-w) + float64(y)*w + 0.5)
+	}
+	return color.NRGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: mix(a.A, b.A)}
+}
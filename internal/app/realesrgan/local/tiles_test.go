@@ -0,0 +1,94 @@
+package local
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kmulvey/realesrgan-scheduler/internal/gpu"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTileGrid(t *testing.T) {
+	t.Parallel()
+
+	var tiles = tileGrid(image.Rect(0, 0, 70, 50), 32, 8)
+	assert.Len(t, tiles, 6)
+	assert.Equal(t, image.Rect(0, 0, 32, 32), tiles[0].core)
+	assert.Equal(t, image.Rect(0, 0, 40, 40), tiles[0].rect)
+	assert.Equal(t, image.Rect(32, 0, 64, 32), tiles[1].core)
+	assert.Equal(t, image.Rect(24, 0, 70, 40), tiles[1].rect)
+	assert.Equal(t, image.Rect(64, 32, 70, 50), tiles[5].core)
+	assert.Equal(t, image.Rect(56, 24, 70, 50), tiles[5].rect)
+
+	// small images are a single tile
+	assert.Len(t, tileGrid(image.Rect(0, 0, 20, 20), 32, 8), 1)
+}
+
+func TestTiles(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var realesrganPath = filepath.Join(dir, "realesrgan")
+	assert.NoError(t, os.WriteFile(realesrganPath, []byte(copyRealesrgan), 0700)) //nolint:gosec
+
+	var img = image.NewNRGBA(image.Rect(0, 0, 70, 50))
+	for y := range 50 {
+		for x := range 70 {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 3), G: uint8(y * 5), B: uint8(x ^ y), A: 0xff})
+		}
+	}
+	var original = filepath.Join(dir, "wide.png")
+	assert.NoError(t, writePNG(img, original))
+
+	// a second gpu is free to take tiles
+	var rl = RealesrganLocal{ScratchDir: dir, TileSize: 32, TileOverlap: 8, pool: gpu.NewPool(gpu.Device{ID: 1})}
+
+	var j, err = rl.newJob(&realesrgan.ImageConfig{SourceFile: original})
+	assert.NoError(t, err)
+	assert.NoError(t, rl.planTiles(j))
+	assert.True(t, j.tiled)
+	assert.True(t, rl.intermediate(j))
+	assert.Equal(t, 32, rl.tileSize(j))
+
+	var run = realesrgan.ImageConfig{SourceFile: j.input, UpsizedFile: filepath.Join(j.scratch, "upsized.png"), RealesrganPath: realesrganPath, Progress: make(chan string, 10)}
+	durations, err := rl.runUpsize(j, run)
+	assert.NoError(t, err)
+	assert.Len(t, durations, 1)
+	assert.Len(t, run.Progress, 6)
+	var slot, ok = rl.pool.TryAcquire(context.Background(), 1)
+	assert.True(t, ok)
+	assert.Equal(t, uint8(1), slot.Device.ID)
+
+	// realesrgan did not change the tiles so blending them must give back the original
+	stitched, err := decodeFile(run.UpsizedFile)
+	assert.NoError(t, err)
+	assert.Equal(t, img.Bounds(), stitched.Bounds())
+	for y := range 50 {
+		for x := range 70 {
+			assert.Equal(t, img.NRGBAAt(x, y), color.NRGBAModel.Convert(stitched.At(x, y)))
+		}
+	}
+	assert.NoError(t, j.cleanup())
+
+	// images that fit in one tile are upsized whole
+	rl.TileSize = 100
+	j, err = rl.newJob(&realesrgan.ImageConfig{SourceFile: original})
+	assert.NoError(t, err)
+	assert.NoError(t, rl.planTiles(j))
+	assert.False(t, j.tiled)
+	assert.Equal(t, 0, rl.tileSize(j))
+	assert.NoError(t, j.cleanup())
+
+	// images too big to stitch in memory are refused
+	rl.TileSize = 32
+	rl.TileMaxPixels = 70*50 - 1
+	j, err = rl.newJob(&realesrgan.ImageConfig{SourceFile: original})
+	assert.NoError(t, err)
+	assert.Error(t, rl.planTiles(j))
+	assert.NoError(t, j.cleanup())
+}
@@ -1,8 +1,11 @@
 package cache
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	badger "github.com/dgraph-io/badger/v3"
 	"github.com/kmulvey/path"
@@ -10,10 +13,30 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// Every key is namespaced by what it holds, see migrate.go for the layouts older versions used.
+const (
+	failurePrefix = "failure:"
+	outputPrefix  = "output:"
+	metaPrefix    = "meta:"
+)
+
+// Cache is the Store kept on disk with badger.
 type Cache struct {
 	*badger.DB
 }
 
+var _ Store = (*Cache)(nil)
+
+// Output records which model produced an upsized image.
+type Output struct {
+	UpsizedFile string `json:"upsized_file"`
+	SourceFile  string `json:"source_file"`
+	ModelName   string `json:"model_name"`
+	// Encoding is how the image was encoded, empty when realesrgan wrote it.
+	Encoding  string    `json:"encoding,omitempty"`
+	UpsizedAt time.Time `json:"upsized_at"`
+}
+
 func New(cachePath string) (Cache, error) {
 	var l = logrus.New()
 	l.SetLevel(log.ErrorLevel)
@@ -26,6 +49,11 @@ func New(cachePath string) (Cache, error) {
 		return Cache{}, fmt.Errorf("error opening badger db: %w", err)
 	}
 
+	if err := migrate(db); err != nil {
+		db.Close()
+		return Cache{}, fmt.Errorf("error upgrading cache: %s, err: %w", cachePath, err)
+	}
+
 	return Cache{db}, nil
 }
 
@@ -34,19 +62,59 @@ func (c *Cache) Close() error {
 }
 
 func (c *Cache) AddImage(image path.Entry) error {
+	return c.RecordFailure(image, "")
+}
+
+func (c *Cache) RecordFailure(image path.Entry, reason string) error {
 	return c.DB.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(image.AbsolutePath), nil)
+
+		var failure, err = getFailure(txn, image.AbsolutePath)
+		if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+
+		if image.FileInfo != nil {
+			failure.Size = image.FileInfo.Size()
+			failure.ModTime = image.FileInfo.ModTime()
+		}
+		failure.Attempts = append(failure.Attempts, newAttempt(reason))
+		return setFailure(txn, failure)
+	})
+}
+
+func (c *Cache) PutFailure(failure Failure) error {
+	return c.DB.Update(func(txn *badger.Txn) error {
+		return setFailure(txn, failure)
+	})
+}
+
+func (c *Cache) GetFailure(image string) (Failure, bool, error) {
+
+	var failure Failure
+	var err = c.DB.View(func(txn *badger.Txn) error {
+		var err error
+		failure, err = getFailure(txn, image)
+		return err
 	})
+
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return Failure{}, false, nil
+	} else if err != nil {
+		return Failure{}, false, fmt.Errorf("error reading failure record for %s: %w", image, err)
+	}
+
+	return failure, true, nil
 }
 
 func (c *Cache) RemoveImage(image string) error {
 	return c.DB.Update(func(txn *badger.Txn) error {
-		return txn.Delete([]byte(image))
+		return txn.Delete([]byte(failurePrefix + image))
 	})
 }
 
+// RemoveAllImages deletes every failure and output record, the schema version is kept.
 func (c *Cache) RemoveAllImages() error {
-	return c.DB.DropAll()
+	return c.DB.DropPrefix([]byte(failurePrefix), []byte(outputPrefix))
 }
 
 func (c *Cache) Contains(image path.Entry) bool {
@@ -54,7 +122,7 @@ func (c *Cache) Contains(image path.Entry) bool {
 	var found bool
 	if err := c.DB.View(func(txn *badger.Txn) error {
 
-		var _, err = txn.Get([]byte(image.AbsolutePath))
+		var _, err = txn.Get([]byte(failurePrefix + image.AbsolutePath))
 		if err == nil {
 			found = true
 			return nil
@@ -74,11 +142,13 @@ func (c *Cache) ListKeys(searchTerm string, images chan string) error {
 
 		var opts = badger.DefaultIteratorOptions
 		opts.PrefetchSize = 20
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(failurePrefix)
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
 		for it.Rewind(); it.Valid(); it.Next() {
-			var key = string(it.Item().Key())
+			var key = strings.TrimPrefix(string(it.Item().Key()), failurePrefix)
 			if strings.Contains(key, searchTerm) {
 				images <- key
 			}
@@ -89,3 +159,90 @@ func (c *Cache) ListKeys(searchTerm string, images chan string) error {
 		return nil
 	})
 }
+
+func (c *Cache) ListFailures() ([]Failure, error) {
+
+	var failures []Failure
+	var err = c.DB.View(func(txn *badger.Txn) error {
+
+		var opts = badger.DefaultIteratorOptions
+		opts.Prefix = []byte(failurePrefix)
+		var it = txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			var key = strings.TrimPrefix(string(it.Item().Key()), failurePrefix)
+
+			var failure = Failure{Path: key}
+			if err := it.Item().Value(func(val []byte) error {
+				return decodeFailure(key, val, &failure)
+			}); err != nil {
+				return err
+			}
+			failures = append(failures, failure)
+		}
+
+		return nil
+	})
+
+	return failures, err
+}
+
+func (c *Cache) RecordOutput(upsizedFile string, output Output) error {
+	output.UpsizedFile = upsizedFile
+	return c.DB.Update(func(txn *badger.Txn) error {
+		return setOutput(txn, output)
+	})
+}
+
+func (c *Cache) GetOutput(upsizedFile string) (Output, bool, error) {
+
+	var output Output
+	var err = c.DB.View(func(txn *badger.Txn) error {
+
+		var err error
+		output, err = getOutput(txn, upsizedFile)
+		return err
+	})
+
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return Output{}, false, nil
+	} else if err != nil {
+		return Output{}, false, fmt.Errorf("error reading output record for %s: %w", upsizedFile, err)
+	}
+
+	return output, true, nil
+}
+
+func (c *Cache) RemoveOutput(upsizedFile string) error {
+	return c.DB.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(outputPrefix + upsizedFile))
+	})
+}
+
+func (c *Cache) ListOutputs() ([]Output, error) {
+
+	var outputs []Output
+	var err = c.DB.View(func(txn *badger.Txn) error {
+
+		var opts = badger.DefaultIteratorOptions
+		opts.Prefix = []byte(outputPrefix)
+		var it = txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			var output Output
+			if err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &output)
+			}); err != nil {
+				return fmt.Errorf("error decoding output record for %s: %w", it.Item().Key(), err)
+			}
+			output.UpsizedFile = strings.TrimPrefix(string(it.Item().Key()), outputPrefix)
+			outputs = append(outputs, output)
+		}
+
+		return nil
+	})
+
+	return outputs, err
+}
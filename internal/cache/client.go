@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"fmt"
+	"net/rpc"
+	"strings"
+
+	"github.com/kmulvey/path"
+)
+
+// Client is a Store that forwards every call to the daemon serving the cache on its control socket.
+type Client struct {
+	rpc *rpc.Client
+}
+
+var _ Store = (*Client)(nil)
+var _ Retrier = (*Client)(nil)
+var _ Versioned = (*Client)(nil)
+
+// Dial connects to a daemon started with Serve.
+func Dial(socketPath string) (*Client, error) {
+	var client, err = rpc.Dial("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to control socket: %s, err: %w", socketPath, err)
+	}
+	return &Client{rpc: client}, nil
+}
+
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
+
+func (c *Client) AddImage(image path.Entry) error {
+	return c.RecordFailure(image, "")
+}
+
+func (c *Client) RecordFailure(image path.Entry, reason string) error {
+	return c.rpc.Call("Store.RecordFailure", newImageArgs(image, reason), &struct{}{})
+}
+
+func (c *Client) PutFailure(failure Failure) error {
+	return c.rpc.Call("Store.PutFailure", failure, &struct{}{})
+}
+
+func (c *Client) GetFailure(image string) (Failure, bool, error) {
+	var reply GetFailureReply
+	var err = c.rpc.Call("Store.GetFailure", image, &reply)
+	return reply.Failure, reply.Found, err
+}
+
+func (c *Client) RemoveImage(image string) error {
+	return c.rpc.Call("Store.RemoveImage", image, &struct{}{})
+}
+
+func (c *Client) RemoveAllImages() error {
+	return c.rpc.Call("Store.RemoveAllImages", struct{}{}, &struct{}{})
+}
+
+func (c *Client) Contains(image path.Entry) bool {
+	var found bool
+	if err := c.rpc.Call("Store.Contains", image.AbsolutePath, &found); err != nil {
+		return false
+	}
+	return found
+}
+
+func (c *Client) ListKeys(searchTerm string, images chan string) error {
+	defer close(images)
+
+	var failures, err = c.ListFailures()
+	if err != nil {
+		return err
+	}
+
+	for _, failure := range failures {
+		if strings.Contains(failure.Path, searchTerm) {
+			images <- failure.Path
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) ListFailures() ([]Failure, error) {
+	var failures []Failure
+	var err = c.rpc.Call("Store.ListFailures", struct{}{}, &failures)
+	return failures, err
+}
+
+func (c *Client) RecordOutput(upsizedFile string, output Output) error {
+	return c.rpc.Call("Store.RecordOutput", RecordOutputArgs{UpsizedFile: upsizedFile, Output: output}, &struct{}{})
+}
+
+func (c *Client) GetOutput(upsizedFile string) (Output, bool, error) {
+	var reply GetOutputReply
+	var err = c.rpc.Call("Store.GetOutput", upsizedFile, &reply)
+	return reply.Output, reply.Found, err
+}
+
+func (c *Client) RemoveOutput(upsizedFile string) error {
+	return c.rpc.Call("Store.RemoveOutput", upsizedFile, &struct{}{})
+}
+
+func (c *Client) ListOutputs() ([]Output, error) {
+	var outputs []Output
+	var err = c.rpc.Call("Store.ListOutputs", struct{}{}, &outputs)
+	return outputs, err
+}
+
+// Compact asks the daemon to compact its store.
+func (c *Client) Compact() (int64, error) {
+	var reclaimed int64
+	var err = c.rpc.Call("Store.Compact", struct{}{}, &reclaimed)
+	return reclaimed, err
+}
+
+// Retry asks the daemon to queue the images now.
+func (c *Client) Retry(images []string) error {
+	return c.rpc.Call("Store.Retry", images, &struct{}{})
+}
+
+// SchemaVersion returns the layout version of the daemons cache.
+func (c *Client) SchemaVersion() (int, error) {
+	var version int
+	var err = c.rpc.Call("Store.SchemaVersion", struct{}{}, &version)
+	return version, err
+}
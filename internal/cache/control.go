@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kmulvey/path"
+	log "github.com/sirupsen/logrus"
+)
+
+// socketName is the control socket the daemon creates inside the cache dir.
+const socketName = "control.sock"
+
+// SocketPath returns where the daemon listens for cache operations for the cache in cacheDir.
+func SocketPath(cacheDir string) string {
+	return filepath.Join(cacheDir, socketName)
+}
+
+// Open opens the badger cache in cacheDir. Badger only lets one process hold the cache, so if that fails
+// because the daemon is running, the daemons control socket is used instead.
+func Open(cacheDir string) (Store, error) {
+
+	var db, err = New(cacheDir)
+	if err == nil {
+		return &db, nil
+	}
+
+	client, dialErr := Dial(SocketPath(cacheDir))
+	if dialErr != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// Serve answers Store calls from other processes on socketPath until ctx is done. If retry is not nil
+// clients can use it to have images upsized again without waiting for the next scan.
+func Serve(ctx context.Context, store Store, socketPath string, retry func(images []string) error) error {
+
+	// a socket left behind by a crash stops us from listening, but one that answers belongs to a running daemon
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return fmt.Errorf("another process is already serving %s", socketPath)
+	}
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error removing stale socket: %s, err: %w", socketPath, err)
+	}
+
+	var listener, err = net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("error listening on: %s, err: %w", socketPath, err)
+	}
+
+	var server = rpc.NewServer()
+	if err := server.RegisterName("Store", &storeService{store: store, retry: retry}); err != nil {
+		listener.Close()
+		return fmt.Errorf("error registering store service: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("error accepting control connection: %w", err)
+		}
+
+		log.Debugf("control connection from %s", conn.RemoteAddr())
+		go server.ServeConn(conn)
+	}
+}
+
+// ImageArgs carries a path.Entry over the control socket, FileInfo is an interface so only the fields the store uses are sent.
+// It and the other rpc types are only exported because net/rpc requires it.
+type ImageArgs struct {
+	Path    string
+	HasInfo bool
+	Size    int64
+	ModTime time.Time
+	Reason  string
+}
+
+func newImageArgs(image path.Entry, reason string) ImageArgs {
+	var args = ImageArgs{Path: image.AbsolutePath, Reason: reason}
+	if image.FileInfo != nil {
+		args.HasInfo = true
+		args.Size = image.FileInfo.Size()
+		args.ModTime = image.FileInfo.ModTime()
+	}
+	return args
+}
+
+func (a ImageArgs) entry() path.Entry {
+	var entry = path.Entry{AbsolutePath: a.Path}
+	if a.HasInfo {
+		entry.FileInfo = remoteFileInfo{name: filepath.Base(a.Path), size: a.Size, modTime: a.ModTime}
+	}
+	return entry
+}
+
+// remoteFileInfo is the os.FileInfo rebuilt on the daemons side of the socket.
+type remoteFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i remoteFileInfo) Name() string       { return i.name }
+func (i remoteFileInfo) Size() int64        { return i.size }
+func (i remoteFileInfo) Mode() fs.FileMode  { return 0 }
+func (i remoteFileInfo) ModTime() time.Time { return i.modTime }
+func (i remoteFileInfo) IsDir() bool        { return false }
+func (i remoteFileInfo) Sys() any           { return nil }
+
+type GetFailureReply struct {
+	Failure Failure
+	Found   bool
+}
+
+type GetOutputReply struct {
+	Output Output
+	Found  bool
+}
+
+type RecordOutputArgs struct {
+	UpsizedFile string
+	Output      Output
+}
+
+// storeService is the rpc side of the socket, each method forwards to the daemons store.
+type storeService struct {
+	store Store
+	retry func(images []string) error
+}
+
+func (s *storeService) RecordFailure(args ImageArgs, _ *struct{}) error {
+	return s.store.RecordFailure(args.entry(), args.Reason)
+}
+
+func (s *storeService) PutFailure(failure Failure, _ *struct{}) error {
+	return s.store.PutFailure(failure)
+}
+
+func (s *storeService) GetFailure(image string, reply *GetFailureReply) error {
+	var err error
+	reply.Failure, reply.Found, err = s.store.GetFailure(image)
+	return err
+}
+
+func (s *storeService) RemoveImage(image string, _ *struct{}) error {
+	return s.store.RemoveImage(image)
+}
+
+func (s *storeService) RemoveAllImages(_ struct{}, _ *struct{}) error {
+	return s.store.RemoveAllImages()
+}
+
+func (s *storeService) Contains(image string, found *bool) error {
+	*found = s.store.Contains(path.Entry{AbsolutePath: image})
+	return nil
+}
+
+func (s *storeService) ListFailures(_ struct{}, failures *[]Failure) error {
+	var err error
+	*failures, err = s.store.ListFailures()
+	return err
+}
+
+func (s *storeService) RecordOutput(args RecordOutputArgs, _ *struct{}) error {
+	return s.store.RecordOutput(args.UpsizedFile, args.Output)
+}
+
+func (s *storeService) GetOutput(upsizedFile string, reply *GetOutputReply) error {
+	var err error
+	reply.Output, reply.Found, err = s.store.GetOutput(upsizedFile)
+	return err
+}
+
+func (s *storeService) RemoveOutput(upsizedFile string, _ *struct{}) error {
+	return s.store.RemoveOutput(upsizedFile)
+}
+
+func (s *storeService) ListOutputs(_ struct{}, outputs *[]Output) error {
+	var err error
+	*outputs, err = s.store.ListOutputs()
+	return err
+}
+
+func (s *storeService) Compact(_ struct{}, reclaimed *int64) error {
+	if c, ok := s.store.(compacter); ok {
+		var err error
+		*reclaimed, err = c.Compact()
+		return err
+	}
+	return nil
+}
+
+func (s *storeService) Retry(images []string, _ *struct{}) error {
+	if s.retry == nil {
+		return errors.New("the daemon is not accepting retries")
+	}
+	return s.retry(images)
+}
+
+func (s *storeService) SchemaVersion(_ struct{}, version *int) error {
+	var versioned, ok = s.store.(Versioned)
+	if !ok {
+		return errors.New("the daemons cache is not versioned")
+	}
+
+	var err error
+	*version, err = versioned.SchemaVersion()
+	return err
+}
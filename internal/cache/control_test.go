@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kmulvey/path"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlSocket(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var db, err = New(filepath.Join(dir, "cache"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	var served = make(chan error)
+	go func() {
+		served <- Serve(ctx, &db, SocketPath(filepath.Join(dir, "cache")), nil)
+	}()
+
+	// the daemon holds the lock so Open has to fall back to the socket
+	var store Store
+	assert.Eventually(t, func() bool {
+		store, err = Open(filepath.Join(dir, "cache"))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.IsType(t, &Client{}, store)
+
+	var image = filepath.Join(dir, "fox.jpg")
+	assert.NoError(t, os.WriteFile(image, []byte("fox"), 0600))
+	entry, err := path.NewEntry(image, 0)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.RecordFailure(entry, "segfault"))
+	assert.True(t, store.Contains(entry))
+	assert.True(t, db.Contains(entry))
+
+	failure, found, err := store.GetFailure(image)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int64(3), failure.Size)
+	assert.Equal(t, "segfault", failure.Attempts[0].Reason)
+
+	var keys = make(chan string)
+	go func() { assert.NoError(t, store.ListKeys("fox", keys)) }()
+	assert.Equal(t, image, <-keys)
+
+	assert.NoError(t, store.RemoveImage(image))
+	assert.False(t, db.Contains(entry))
+
+	_, err = GC(store)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Close())
+	cancel()
+	assert.NoError(t, <-served)
+}
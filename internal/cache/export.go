@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// MergePolicy decides what happens when an imported record already exists in the cache.
+type MergePolicy string
+
+const (
+	// MergeNewest keeps whichever failure was attempted most recently.
+	MergeNewest MergePolicy = "newest"
+	// MergeUnion keeps every attempt from both failures.
+	MergeUnion MergePolicy = "union"
+)
+
+// ParseMergePolicy validates a merge policy given on the command line.
+func ParseMergePolicy(policy string) (MergePolicy, error) {
+	switch MergePolicy(policy) {
+	case MergeNewest, MergeUnion:
+		return MergePolicy(policy), nil
+	}
+	return "", fmt.Errorf("unknown merge policy: %s, must be one of: %s, %s", policy, MergeNewest, MergeUnion)
+}
+
+// Record is one line of an export, exactly one of Failure or Output is set.
+type Record struct {
+	Failure *Failure `json:"failure,omitempty"`
+	Output  *Output  `json:"output,omitempty"`
+}
+
+// ImportStats counts what an import did to the cache.
+type ImportStats struct {
+	Added     int
+	Updated   int
+	Unchanged int
+}
+
+// Export writes every record in the store to w as JSON Lines.
+func Export(store Store, w io.Writer) error {
+
+	var encoder = json.NewEncoder(w)
+
+	var failures, err = store.ListFailures()
+	if err != nil {
+		return fmt.Errorf("error listing failures: %w", err)
+	}
+	for i := range failures {
+		if err := encoder.Encode(Record{Failure: &failures[i]}); err != nil {
+			return fmt.Errorf("error writing record for %s: %w", failures[i].Path, err)
+		}
+	}
+
+	outputs, err := store.ListOutputs()
+	if err != nil {
+		return fmt.Errorf("error listing outputs: %w", err)
+	}
+	for i := range outputs {
+		if err := encoder.Encode(Record{Output: &outputs[i]}); err != nil {
+			return fmt.Errorf("error writing record for %s: %w", outputs[i].UpsizedFile, err)
+		}
+	}
+
+	return nil
+}
+
+// Import reads JSON Lines written by Export and merges them into the store according to policy.
+func Import(store Store, r io.Reader, policy MergePolicy) (ImportStats, error) {
+
+	var stats ImportStats
+	var scanner = bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		var line = strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return stats, fmt.Errorf("error decoding line %d: %w", lineNum, err)
+		}
+
+		var err error
+		switch {
+		case record.Failure != nil && record.Failure.Path != "":
+			err = importFailure(store, *record.Failure, policy, &stats)
+		case record.Output != nil && record.Output.UpsizedFile != "":
+			err = importOutput(store, *record.Output, &stats)
+		default:
+			err = fmt.Errorf("line %d has no failure or output record", lineNum)
+		}
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, scanner.Err()
+}
+
+func importFailure(store Store, imported Failure, policy MergePolicy, stats *ImportStats) error {
+
+	var existing, found, err = store.GetFailure(imported.Path)
+	if err != nil {
+		return err
+	} else if !found {
+		stats.Added++
+		return store.PutFailure(imported)
+	}
+
+	var merged = mergeFailures(existing, imported, policy)
+	if equalAttempts(existing.Attempts, merged.Attempts) {
+		stats.Unchanged++
+		return nil
+	}
+
+	stats.Updated++
+	return store.PutFailure(merged)
+}
+
+func importOutput(store Store, imported Output, stats *ImportStats) error {
+
+	var existing, found, err = store.GetOutput(imported.UpsizedFile)
+	if err != nil {
+		return err
+	} else if !found {
+		stats.Added++
+		return store.RecordOutput(imported.UpsizedFile, imported)
+	}
+
+	// outputs are always newest wins, an image only has one current version
+	if !imported.UpsizedAt.After(existing.UpsizedAt) {
+		stats.Unchanged++
+		return nil
+	}
+
+	stats.Updated++
+	return store.RecordOutput(imported.UpsizedFile, imported)
+}
+
+// mergeFailures combines two records for the same image. Ties under MergeNewest go to the existing record.
+func mergeFailures(existing, imported Failure, policy MergePolicy) Failure {
+
+	if policy == MergeNewest {
+		if imported.LastAttempt().After(existing.LastAttempt()) {
+			return imported
+		}
+		return existing
+	}
+
+	// the file details come from whichever side saw the image fail last
+	var latest = existing
+	if imported.LastAttempt().After(existing.LastAttempt()) {
+		latest = imported
+	}
+
+	var merged = Failure{Path: existing.Path, Size: latest.Size, ModTime: latest.ModTime}
+	var seen = make(map[Attempt]struct{}, len(existing.Attempts)+len(imported.Attempts))
+	for _, attempt := range append(append([]Attempt(nil), existing.Attempts...), imported.Attempts...) {
+		// normalize the time so the same attempt read back from json compares equal
+		var key = attempt
+		key.Time = key.Time.UTC()
+		if _, found := seen[key]; found {
+			continue
+		}
+		seen[key] = struct{}{}
+		merged.Attempts = append(merged.Attempts, attempt)
+	}
+
+	sort.SliceStable(merged.Attempts, func(i, j int) bool {
+		return merged.Attempts[i].Time.Before(merged.Attempts[j].Time)
+	})
+
+	return merged
+}
+
+func equalAttempts(a, b []Attempt) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Time.Equal(b[i].Time) || a[i].Host != b[i].Host || a[i].Reason != b[i].Reason {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/kmulvey/path"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImport(t *testing.T) {
+	t.Parallel()
+
+	var first, err = New(filepath.Join(t.TempDir(), "first"))
+	assert.NoError(t, err)
+	defer first.Close()
+
+	assert.NoError(t, first.PutFailure(Failure{Path: "/photos/legacy.jpg"}))
+
+	var day = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, first.DB.Update(func(txn *badger.Txn) error {
+		return setFailure(txn, Failure{Path: "/photos/bad.jpg", Attempts: []Attempt{{Time: day, Host: "first", Reason: "segfault"}}})
+	}))
+	assert.NoError(t, first.RecordOutput("/upscaled/fox.jpg", Output{SourceFile: "/photos/fox.jpg", ModelName: "realesrgan-x4plus", UpsizedAt: day}))
+
+	var export bytes.Buffer
+	assert.NoError(t, Export(&first, &export))
+	assert.Equal(t, 3, strings.Count(export.String(), "\n"))
+
+	// the other machine only needs to be a Store
+	var second = NewMemory()
+	assert.NoError(t, second.PutFailure(Failure{Path: "/photos/bad.jpg", Attempts: []Attempt{{Time: day.Add(time.Hour), Host: "second", Reason: "oom"}}}))
+
+	stats, err := Import(second, bytes.NewReader(export.Bytes()), MergeNewest)
+	assert.NoError(t, err)
+	assert.Equal(t, ImportStats{Added: 2, Unchanged: 1}, stats)
+
+	failure, found, err := second.GetFailure("/photos/bad.jpg")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Len(t, failure.Attempts, 1)
+	assert.Equal(t, "second", failure.Attempts[0].Host)
+
+	stats, err = Import(second, bytes.NewReader(export.Bytes()), MergeUnion)
+	assert.NoError(t, err)
+	assert.Equal(t, ImportStats{Updated: 1, Unchanged: 2}, stats)
+
+	failure, _, err = second.GetFailure("/photos/bad.jpg")
+	assert.NoError(t, err)
+	assert.Len(t, failure.Attempts, 2)
+	assert.Equal(t, "first", failure.Attempts[0].Host)
+
+	// importing the same export again must not duplicate attempts
+	stats, err = Import(second, bytes.NewReader(export.Bytes()), MergeUnion)
+	assert.NoError(t, err)
+	assert.Equal(t, ImportStats{Unchanged: 3}, stats)
+
+	assert.True(t, second.Contains(path.Entry{AbsolutePath: "/photos/legacy.jpg"}))
+	output, found, err := second.GetOutput("/upscaled/fox.jpg")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "realesrgan-x4plus", output.ModelName)
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Failure types, derived from the reason realesrgan gave for an attempt.
+const (
+	TypeManual   = "manual"
+	TypeSegfault = "segfault"
+	TypeDecode   = "decode"
+	TypeEncode   = "encode"
+	TypeMemory   = "memory"
+	TypeOther    = "other"
+)
+
+// FailureTypes lists every type Attempt.Type can return.
+var FailureTypes = []string{TypeManual, TypeSegfault, TypeDecode, TypeEncode, TypeMemory, TypeOther}
+
+// Type classifies the attempt by its reason, attempts without a reason were added by hand.
+func (a Attempt) Type() string {
+	var reason = strings.ToLower(a.Reason)
+	switch {
+	case reason == "":
+		return TypeManual
+	case strings.Contains(reason, "segmentation fault"):
+		return TypeSegfault
+	case strings.Contains(reason, "decode image"):
+		return TypeDecode
+	case strings.Contains(reason, "encode image"):
+		return TypeEncode
+	case strings.Contains(reason, "out of memory") || strings.Contains(reason, "vkallocatememory failed"):
+		return TypeMemory
+	}
+	return TypeOther
+}
+
+// Type is the type of the most recent attempt, failures from caches that did not record attempts are manual.
+func (f Failure) Type() string {
+	var last Attempt
+	for _, attempt := range f.Attempts {
+		if !attempt.Time.Before(last.Time) {
+			last = attempt
+		}
+	}
+	return last.Type()
+}
+
+// Filter selects failures, every field that is set has to match. A zero Filter matches everything.
+type Filter struct {
+	// Regex is matched against the images path.
+	Regex *regexp.Regexp
+	// Glob is matched against the images path with filepath.Match, so * does not cross directories.
+	Glob string
+	// Type is one of FailureTypes.
+	Type string
+	// Since and Until bound the time of the last attempt.
+	Since time.Time
+	Until time.Time
+	// MinAttempts and MaxAttempts bound the number of attempts, MaxAttempts is ignored if it is 0.
+	MinAttempts int
+	MaxAttempts int
+}
+
+// Validate checks the parts of the filter that can be wrong before it is used.
+func (f Filter) Validate() error {
+	if f.Glob != "" {
+		if _, err := filepath.Match(f.Glob, ""); err != nil {
+			return fmt.Errorf("invalid glob: %s, err: %w", f.Glob, err)
+		}
+	}
+
+	if f.Type != "" {
+		var known bool
+		for _, failureType := range FailureTypes {
+			known = known || f.Type == failureType
+		}
+		if !known {
+			return fmt.Errorf("unknown failure type: %s, must be one of: %s", f.Type, strings.Join(FailureTypes, ", "))
+		}
+	}
+
+	if f.MaxAttempts > 0 && f.MinAttempts > f.MaxAttempts {
+		return fmt.Errorf("min attempts: %d is more than max attempts: %d", f.MinAttempts, f.MaxAttempts)
+	}
+
+	return nil
+}
+
+// Match reports whether the failure passes every part of the filter.
+func (f Filter) Match(failure Failure) bool {
+
+	if f.Regex != nil && !f.Regex.MatchString(failure.Path) {
+		return false
+	}
+
+	if f.Glob != "" {
+		if matched, _ := filepath.Match(f.Glob, failure.Path); !matched {
+			return false
+		}
+	}
+
+	if f.Type != "" && failure.Type() != f.Type {
+		return false
+	}
+
+	var last = failure.LastAttempt()
+	if !f.Since.IsZero() && last.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && last.After(f.Until) {
+		return false
+	}
+
+	if len(failure.Attempts) < f.MinAttempts {
+		return false
+	}
+	if f.MaxAttempts > 0 && len(failure.Attempts) > f.MaxAttempts {
+		return false
+	}
+
+	return true
+}
+
+// Select returns every failure in the store that matches the filter.
+func Select(store Store, filter Filter) ([]Failure, error) {
+
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	var failures, err = store.ListFailures()
+	if err != nil {
+		return nil, fmt.Errorf("error listing failures: %w", err)
+	}
+
+	var matches []Failure
+	for _, failure := range failures {
+		if filter.Match(failure) {
+			matches = append(matches, failure)
+		}
+	}
+
+	return matches, nil
+}
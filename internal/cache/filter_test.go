@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	var day = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	var failure = Failure{
+		Path: "/photos/2026/fox.png",
+		Attempts: []Attempt{
+			{Time: day, Reason: "decode image /photos/2026/fox.png failed"},
+			{Time: day.Add(time.Hour), Reason: "error running cmd: signal: segmentation fault (core dumped)"},
+		},
+	}
+
+	assert.True(t, Filter{}.Match(failure))
+	assert.Equal(t, TypeSegfault, failure.Type())
+	assert.Equal(t, TypeManual, Failure{Path: "/photos/legacy.jpg"}.Type())
+
+	assert.True(t, Filter{Regex: regexp.MustCompile(`\.png$`)}.Match(failure))
+	assert.False(t, Filter{Regex: regexp.MustCompile(`\.jpg$`)}.Match(failure))
+
+	assert.True(t, Filter{Glob: "/photos/*/*.png"}.Match(failure))
+	assert.False(t, Filter{Glob: "/photos/*.png"}.Match(failure))
+
+	assert.True(t, Filter{Type: TypeSegfault}.Match(failure))
+	assert.False(t, Filter{Type: TypeDecode}.Match(failure))
+
+	assert.True(t, Filter{Since: day, Until: day.Add(2 * time.Hour)}.Match(failure))
+	assert.False(t, Filter{Since: day.Add(2 * time.Hour)}.Match(failure))
+	assert.False(t, Filter{Until: day}.Match(failure))
+
+	assert.True(t, Filter{MinAttempts: 2, MaxAttempts: 2}.Match(failure))
+	assert.False(t, Filter{MinAttempts: 3}.Match(failure))
+	assert.False(t, Filter{MaxAttempts: 1}.Match(failure))
+
+	assert.Error(t, Filter{Glob: "[photos"}.Validate())
+	assert.Error(t, Filter{Type: "sideways"}.Validate())
+	assert.Error(t, Filter{MinAttempts: 3, MaxAttempts: 1}.Validate())
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+// GCStats reports what a GC run removed.
+type GCStats struct {
+	Entries        int
+	ReclaimedBytes int64
+}
+
+// compacter is implemented by stores that can give disk space back once records have been removed.
+type compacter interface {
+	Compact() (int64, error)
+}
+
+// GC drops failures whose image is gone or has changed since it failed and outputs whose upsized image is gone.
+// If the store keeps its data on disk it is then compacted so the space is given back.
+func GC(store Store) (GCStats, error) {
+
+	var stats GCStats
+
+	var failures, err = store.ListFailures()
+	if err != nil {
+		return stats, fmt.Errorf("error listing failures: %w", err)
+	}
+	for _, failure := range failures {
+		var info, err = os.Stat(failure.Path)
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && failure.Changed(info)) {
+			if err := store.RemoveImage(failure.Path); err != nil {
+				return stats, fmt.Errorf("error removing %s: %w", failure.Path, err)
+			}
+			stats.Entries++
+		}
+	}
+
+	outputs, err := store.ListOutputs()
+	if err != nil {
+		return stats, fmt.Errorf("error listing outputs: %w", err)
+	}
+	for _, output := range outputs {
+		if _, err := os.Stat(output.UpsizedFile); errors.Is(err, fs.ErrNotExist) {
+			if err := store.RemoveOutput(output.UpsizedFile); err != nil {
+				return stats, fmt.Errorf("error removing %s: %w", output.UpsizedFile, err)
+			}
+			stats.Entries++
+		}
+	}
+
+	if c, ok := store.(compacter); ok {
+		stats.ReclaimedBytes, err = c.Compact()
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+// Compact flattens the lsm tree and garbage collects the value log, it returns how many bytes were freed on disk.
+func (c *Cache) Compact() (int64, error) {
+
+	var before, err = c.diskSize()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := c.DB.Flatten(1); err != nil {
+		return 0, fmt.Errorf("error flattening lsm tree: %w", err)
+	}
+
+	// each call rewrites at most one value log file, keep going until there is nothing left to rewrite
+	for {
+		err := c.DB.RunValueLogGC(0.5)
+		if errors.Is(err, badger.ErrNoRewrite) || errors.Is(err, badger.ErrRejected) {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("error running value log gc: %w", err)
+		}
+	}
+
+	after, err := c.diskSize()
+	if err != nil {
+		return 0, err
+	}
+
+	return before - after, nil
+}
+
+// diskSize adds up the size of every file badger keeps on disk.
+func (c *Cache) diskSize() (int64, error) {
+
+	var opts = c.DB.Opts()
+	var dirs = []string{opts.Dir}
+	if opts.ValueDir != opts.Dir {
+		dirs = append(dirs, opts.ValueDir)
+	}
+
+	var size int64
+	for _, dir := range dirs {
+		var err = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+
+			info, err := d.Info()
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil // badger removed it while compacting
+			} else if err != nil {
+				return err
+			}
+			size += info.Size()
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("error sizing cache dir: %s, err: %w", dir, err)
+		}
+	}
+
+	return size, nil
+}
@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kmulvey/path"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGC(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var db, err = New(filepath.Join(dir, "cache"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	var images = make(map[string]path.Entry)
+	for _, name := range []string{"kept.jpg", "deleted.jpg", "changed.jpg"} {
+		var file = filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(file, []byte("not really a jpg"), 0600))
+
+		images[name], err = path.NewEntry(file, 0)
+		assert.NoError(t, err)
+		assert.NoError(t, db.RecordFailure(images[name], "segfault"))
+	}
+	assert.NoError(t, db.RecordOutput(filepath.Join(dir, "gone.jpg"), Output{ModelName: "realesrgan-x4plus", UpsizedAt: time.Now()}))
+
+	assert.NoError(t, os.Remove(images["deleted.jpg"].AbsolutePath))
+	assert.NoError(t, os.WriteFile(images["changed.jpg"].AbsolutePath, []byte("a different image"), 0600))
+
+	stats, err := GC(&db)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stats.Entries)
+
+	assert.True(t, db.Contains(images["kept.jpg"]))
+	assert.False(t, db.Contains(images["deleted.jpg"]))
+	assert.False(t, db.Contains(images["changed.jpg"]))
+
+	_, found, err := db.GetOutput(filepath.Join(dir, "gone.jpg"))
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
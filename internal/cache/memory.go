@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kmulvey/path"
+)
+
+// Memory is a Store that only lives as long as the process, it is safe for concurrent use.
+type Memory struct {
+	lock     sync.RWMutex
+	failures map[string]Failure
+	outputs  map[string]Output
+}
+
+var _ Store = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		failures: make(map[string]Failure),
+		outputs:  make(map[string]Output),
+	}
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) AddImage(image path.Entry) error {
+	return m.RecordFailure(image, "")
+}
+
+func (m *Memory) RecordFailure(image path.Entry, reason string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var failure, found = m.failures[image.AbsolutePath]
+	if !found {
+		failure = Failure{Path: image.AbsolutePath}
+	}
+
+	if image.FileInfo != nil {
+		failure.Size = image.FileInfo.Size()
+		failure.ModTime = image.FileInfo.ModTime()
+	}
+	failure.Attempts = append(append([]Attempt(nil), failure.Attempts...), newAttempt(reason))
+	m.failures[image.AbsolutePath] = failure
+
+	return nil
+}
+
+func (m *Memory) PutFailure(failure Failure) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.failures[failure.Path] = failure
+	return nil
+}
+
+func (m *Memory) GetFailure(image string) (Failure, bool, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var failure, found = m.failures[image]
+	return failure, found, nil
+}
+
+func (m *Memory) RemoveImage(image string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.failures, image)
+	return nil
+}
+
+func (m *Memory) RemoveAllImages() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.failures = make(map[string]Failure)
+	m.outputs = make(map[string]Output)
+	return nil
+}
+
+func (m *Memory) Contains(image path.Entry) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var _, found = m.failures[image.AbsolutePath]
+	return found
+}
+
+func (m *Memory) ListKeys(searchTerm string, images chan string) error {
+	defer close(images)
+
+	var failures, _ = m.ListFailures()
+	for _, failure := range failures {
+		if strings.Contains(failure.Path, searchTerm) {
+			images <- failure.Path
+		}
+	}
+
+	return nil
+}
+
+// ListFailures returns the failures sorted by path, the same order badger iterates in.
+func (m *Memory) ListFailures() ([]Failure, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var failures = make([]Failure, 0, len(m.failures))
+	for _, failure := range m.failures {
+		failures = append(failures, failure)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Path < failures[j].Path
+	})
+
+	return failures, nil
+}
+
+func (m *Memory) RecordOutput(upsizedFile string, output Output) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	output.UpsizedFile = upsizedFile
+	m.outputs[upsizedFile] = output
+	return nil
+}
+
+func (m *Memory) GetOutput(upsizedFile string) (Output, bool, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var output, found = m.outputs[upsizedFile]
+	return output, found, nil
+}
+
+func (m *Memory) RemoveOutput(upsizedFile string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.outputs, upsizedFile)
+	return nil
+}
+
+// ListOutputs returns the outputs sorted by upsized file.
+func (m *Memory) ListOutputs() ([]Output, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var outputs = make([]Output, 0, len(m.outputs))
+	for _, output := range m.outputs {
+		outputs = append(outputs, output)
+	}
+	sort.Slice(outputs, func(i, j int) bool {
+		return outputs[i].UpsizedFile < outputs[j].UpsizedFile
+	})
+
+	return outputs, nil
+}
@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	badger "github.com/dgraph-io/badger/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+// The layouts the cache has had, New upgrades older ones in place:
+//
+//	0: failures keyed by their bare absolute path with a nil value, there was no version key
+//	1: failures keyed by their bare absolute path with a json Failure value
+//	2: failures keyed under failurePrefix, the version kept under schemaKey
+var schemaKey = []byte(metaPrefix + "schema_version")
+
+// migrationBatchSize keeps each migration transaction well under badgers transaction size limit.
+const migrationBatchSize = 1000
+
+// migration upgrades the cache from the previous version to version. It must be safe to run again on a cache
+// it has already partly or completely upgraded, as the version is only saved once it has finished.
+type migration struct {
+	version     int
+	description string
+	run         func(db *badger.DB) error
+}
+
+var migrations = []migration{
+	{version: 1, description: "store every failure as a json record", run: migrateFailureRecords},
+	{version: 2, description: "move failures under the failure prefix", run: migrateFailurePrefix},
+}
+
+// CurrentSchemaVersion is the layout this version of the cache reads and writes.
+var CurrentSchemaVersion = migrations[len(migrations)-1].version
+
+// Versioned is implemented by stores whose layout on disk is versioned.
+type Versioned interface {
+	SchemaVersion() (int, error)
+}
+
+// SchemaVersion returns the layout version of the cache on disk.
+func (c *Cache) SchemaVersion() (int, error) {
+	return readSchemaVersion(c.DB)
+}
+
+// migrate runs every migration newer than the caches schema version, in order.
+func migrate(db *badger.DB) error {
+
+	var version, err = readSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if version > CurrentSchemaVersion {
+		return fmt.Errorf("cache is schema version %d but this build only understands up to %d", version, CurrentSchemaVersion)
+	}
+
+	// a new cache has nothing to upgrade
+	if version == 0 && isEmpty(db) {
+		return db.Update(func(txn *badger.Txn) error {
+			return txn.Set(schemaKey, []byte(strconv.Itoa(CurrentSchemaVersion)))
+		})
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		log.Infof("upgrading cache to schema version %d: %s", m.version, m.description)
+		if err := m.run(db); err != nil {
+			return fmt.Errorf("error upgrading to schema version %d: %w", m.version, err)
+		}
+
+		if err := db.Update(func(txn *badger.Txn) error {
+			return txn.Set(schemaKey, []byte(strconv.Itoa(m.version)))
+		}); err != nil {
+			return fmt.Errorf("error saving schema version %d: %w", m.version, err)
+		}
+	}
+
+	return nil
+}
+
+func isEmpty(db *badger.DB) bool {
+	var empty = true
+	_ = db.View(func(txn *badger.Txn) error {
+		var opts = badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		var it = txn.NewIterator(opts)
+		defer it.Close()
+
+		it.Rewind()
+		empty = !it.Valid()
+		return nil
+	})
+	return empty
+}
+
+// readSchemaVersion returns 0 for caches written before the version was kept.
+func readSchemaVersion(db *badger.DB) (int, error) {
+
+	var version int
+	var err = db.View(func(txn *badger.Txn) error {
+
+		var item, err = txn.Get(schemaKey)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			version, err = strconv.Atoi(string(val))
+			return err
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error reading schema version: %w", err)
+	}
+
+	return version, nil
+}
+
+// migrateFailureRecords replaces the nil value of every failure with a json record.
+func migrateFailureRecords(db *badger.DB) error {
+	return updateBareKeys(db, func(txn *badger.Txn, key string) error {
+
+		var item, err = txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+
+		if item.ValueSize() > 0 {
+			return nil // already a record
+		}
+
+		value, err := json.Marshal(Failure{Path: key})
+		if err != nil {
+			return err
+		}
+		return txn.Set([]byte(key), value)
+	})
+}
+
+// migrateFailurePrefix moves every failure from its bare path to under failurePrefix. The move of each key
+// happens in one transaction so a key is never lost or left in both places.
+func migrateFailurePrefix(db *badger.DB) error {
+	return updateBareKeys(db, func(txn *badger.Txn, key string) error {
+
+		var item, err = txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		if err := txn.Set([]byte(failurePrefix+key), value); err != nil {
+			return err
+		}
+		return txn.Delete([]byte(key))
+	})
+}
+
+// updateBareKeys calls fn on every key without a namespace prefix, in batches of one transaction each.
+func updateBareKeys(db *badger.DB, fn func(txn *badger.Txn, key string) error) error {
+
+	var keys []string
+	var err = db.View(func(txn *badger.Txn) error {
+
+		var opts = badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		var it = txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			var key = string(it.Item().Key())
+			if !strings.HasPrefix(key, failurePrefix) && !strings.HasPrefix(key, outputPrefix) && !strings.HasPrefix(key, metaPrefix) {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error listing keys to upgrade: %w", err)
+	}
+
+	for start := 0; start < len(keys); start += migrationBatchSize {
+		var batch = keys[start:min(start+migrationBatchSize, len(keys))]
+
+		var err = db.Update(func(txn *badger.Txn) error {
+			for _, key := range batch {
+				if err := fn(txn, key); err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+					return fmt.Errorf("error upgrading key: %s, err: %w", key, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/kmulvey/path"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	var dir = filepath.Join(t.TempDir(), "cache")
+
+	// write the layout the first versions of the cache used
+	var opts = badger.DefaultOptions(dir)
+	opts.Logger = nil
+	var db, err = badger.Open(opts)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set([]byte("/photos/legacy.jpg"), nil); err != nil {
+			return err
+		}
+		return txn.Set([]byte("/photos/recorded.jpg"), []byte(`{"path":"/photos/recorded.jpg","attempts":[{"time":"2026-01-01T00:00:00Z","reason":"segmentation fault"}]}`))
+	}))
+
+	// pretend an earlier upgrade was interrupted after the first migration
+	assert.NoError(t, migrations[0].run(db))
+	assert.NoError(t, db.Close())
+
+	for range 2 {
+		var c, err = New(dir)
+		assert.NoError(t, err)
+
+		version, err := c.SchemaVersion()
+		assert.NoError(t, err)
+		assert.Equal(t, CurrentSchemaVersion, version)
+
+		assert.True(t, c.Contains(path.Entry{AbsolutePath: "/photos/legacy.jpg"}))
+		failure, found, err := c.GetFailure("/photos/recorded.jpg")
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, TypeSegfault, failure.Type())
+
+		failures, err := c.ListFailures()
+		assert.NoError(t, err)
+		assert.Len(t, failures, 2)
+
+		assert.NoError(t, c.Close())
+	}
+}
+
+func TestMigrateNewerCache(t *testing.T) {
+	t.Parallel()
+
+	var dir = filepath.Join(t.TempDir(), "cache")
+	var c, err = New(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, c.DB.Update(func(txn *badger.Txn) error {
+		return txn.Set(schemaKey, []byte("999"))
+	}))
+	assert.NoError(t, c.Close())
+
+	_, err = New(dir)
+	assert.Error(t, err)
+}
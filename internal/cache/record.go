@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+// Failure is the record kept for an image that could not be upsized, it is stored under the images absolute path.
+// Size and ModTime are of the image when it last failed, if either changes the image is worth trying again.
+type Failure struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size,omitempty"`
+	ModTime  time.Time `json:"mod_time"`
+	Attempts []Attempt `json:"attempts,omitempty"`
+}
+
+// Attempt is one failed try at upsizing an image.
+type Attempt struct {
+	Time   time.Time `json:"time"`
+	Host   string    `json:"host,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// LastAttempt returns the time of the most recent attempt, or the zero time if there were none.
+func (f Failure) LastAttempt() time.Time {
+	var last time.Time
+	for _, attempt := range f.Attempts {
+		if attempt.Time.After(last) {
+			last = attempt.Time
+		}
+	}
+	return last
+}
+
+// Changed reports whether the file at f.Path is different from when it failed. Failures recorded before sizes
+// were tracked are never considered changed.
+func (f Failure) Changed(info os.FileInfo) bool {
+	if f.Size == 0 && f.ModTime.IsZero() {
+		return false
+	}
+	return info.Size() != f.Size || !info.ModTime().Equal(f.ModTime)
+}
+
+func newAttempt(reason string) Attempt {
+	var host, _ = os.Hostname()
+	return Attempt{Time: time.Now(), Host: host, Reason: reason}
+}
+
+func getFailure(txn *badger.Txn, image string) (Failure, error) {
+
+	var failure = Failure{Path: image}
+	var item, err = txn.Get([]byte(failurePrefix + image))
+	if err != nil {
+		return failure, err
+	}
+
+	err = item.Value(func(val []byte) error {
+		return decodeFailure(image, val, &failure)
+	})
+
+	return failure, err
+}
+
+// decodeFailure reads a failure record, an empty value is a failure without attempts as caches before schema
+// version 1 stored them.
+func decodeFailure(image string, val []byte, failure *Failure) error {
+	if len(val) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(val, failure); err != nil {
+		return fmt.Errorf("error decoding failure record for %s: %w", image, err)
+	}
+	failure.Path = image
+
+	return nil
+}
+
+func setFailure(txn *badger.Txn, failure Failure) error {
+
+	var value, err = json.Marshal(failure)
+	if err != nil {
+		return fmt.Errorf("error encoding failure record for %s: %w", failure.Path, err)
+	}
+
+	return txn.Set([]byte(failurePrefix+failure.Path), value)
+}
+
+func getOutput(txn *badger.Txn, upsizedFile string) (Output, error) {
+
+	var output Output
+	var item, err = txn.Get([]byte(outputPrefix + upsizedFile))
+	if err != nil {
+		return output, err
+	}
+
+	err = item.Value(func(val []byte) error {
+		if err := json.Unmarshal(val, &output); err != nil {
+			return fmt.Errorf("error decoding output record for %s: %w", upsizedFile, err)
+		}
+		return nil
+	})
+	output.UpsizedFile = upsizedFile
+
+	return output, err
+}
+
+func setOutput(txn *badger.Txn, output Output) error {
+
+	var value, err = json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("error encoding output record for %s: %w", output.UpsizedFile, err)
+	}
+
+	return txn.Set([]byte(outputPrefix+output.UpsizedFile), value)
+}
@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"github.com/kmulvey/path"
+)
+
+// Store keeps the images that failed to upsize, so they can be skipped, and which model produced each upsized image.
+// Cache is backed by badger on disk, Memory is for tests and dry runs.
+type Store interface {
+	// AddImage adds the image so it will be skipped, it is recorded as a failure without a reason.
+	AddImage(image path.Entry) error
+	// RecordFailure adds an attempt with the given reason to the images failure record, creating it if needed.
+	RecordFailure(image path.Entry, reason string) error
+	// PutFailure saves the failure record as is, replacing any existing one.
+	PutFailure(failure Failure) error
+	// GetFailure returns the failure record for the given image, the bool is false if the image is not stored.
+	GetFailure(image string) (Failure, bool, error)
+	RemoveImage(image string) error
+	RemoveAllImages() error
+	Contains(image path.Entry) bool
+	// ListKeys sends the path of every failed image containing searchTerm on images and closes it when done.
+	ListKeys(searchTerm string, images chan string) error
+	ListFailures() ([]Failure, error)
+
+	// RecordOutput saves which model produced the given upsized image, replacing any previous record.
+	RecordOutput(upsizedFile string, output Output) error
+	// GetOutput returns the record for the given upsized image, the bool is false if the image was never recorded.
+	GetOutput(upsizedFile string) (Output, bool, error)
+	RemoveOutput(upsizedFile string) error
+	ListOutputs() ([]Output, error)
+
+	Close() error
+}
+
+// Retrier is implemented by stores that can ask the running daemon to upsize images again right away.
+type Retrier interface {
+	Retry(images []string) error
+}
+
+// Owner returns a pathmap.Mapper Owner that looks up the original an upsized image was made from in its output record.
+func Owner(store Store) func(upsized string) (string, bool) {
+	return func(upsized string) (string, bool) {
+		var output, found, err = store.GetOutput(upsized)
+		if err != nil || !found || output.SourceFile == "" {
+			return "", false
+		}
+		return output.SourceFile, true
+	}
+}
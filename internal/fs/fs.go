@@ -4,16 +4,27 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
 	"regexp"
-	"strings"
 
 	"github.com/kmulvey/path"
-	"github.com/kmulvey/realesrgan-scheduler/pkg/realesrgan"
+	"github.com/kmulvey/realesrgan-scheduler/internal/pathmap"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
+	log "github.com/sirupsen/logrus"
 )
 
-// ImageExtensionRegex are all the supported image extensions, and the only ones that will be included in file search/globbing.
-var ImageExtensionRegex = regexp.MustCompile(".*.jpg$|.*.jpeg$|.*.png$|.*.webp$|.*.JPG$|.*.JPEG$|.*.PNG$|.*.WEBP$")
+// ImageExtensionRegex matches the extensions of every image format that can be read. Nothing uses it any more, images
+// are found by their content with IsImage, it is kept for compatibility.
+var ImageExtensionRegex = regexp.MustCompile(`(?i)\.(jpe?g|png|webp|gif|bmp|tiff?|avif|heic|heif)$`)
+
+// IsImage is a Walk filter that accepts files whose content is an image in a supported format, whatever their name.
+func IsImage(entry path.Entry) bool {
+	var _, err = imagetype.Sniff(entry.AbsolutePath)
+	if err != nil {
+		log.Debugf("skipping %s: %s", entry.AbsolutePath, err)
+		return false
+	}
+	return true
+}
 
 // WatchEventToEntry convert path.WatchEvent to path.Entry
 func WatchEventToEntry(watchEvents []path.WatchEvent) []path.Entry {
@@ -35,19 +46,6 @@ func WatchEventChanToEntryChan(watchEvents chan path.WatchEvent) chan path.Entry
 	return entires
 }
 
-// AlreadyUpsized checks if we already upsized the image.
-func AlreadyUpsized(originalImage *realesrgan.ImageConfig, outputPath string) bool {
-
-	var upsizedImagePath = filepath.Base(originalImage.SourceFile)
-	upsizedImagePath = filepath.Join(outputPath, strings.Replace(upsizedImagePath, filepath.Ext(upsizedImagePath), ".jpg", 1))
-
-	if _, err := os.Stat(upsizedImagePath); errors.Is(err, os.ErrNotExist) {
-		return false
-	}
-
-	return true
-}
-
 // MakeDir will create a directory if it does not already exist.
 func MakeDir(path string) error {
 	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
@@ -97,26 +95,22 @@ func WatchDir(ctx context.Context, inputDir, outputDir string, images chan path.
 }
 */
 
-func FindNewImages(originalsDir, upsizedDir string, depth uint8) ([]string, error) {
+// FindNewImages returns the originals under mapper.OriginalsRoot that have not been upsized yet, see Walk for maxDepth.
+func FindNewImages(mapper pathmap.Mapper, maxDepth int) ([]string, error) {
 
-	var originalImages, err = path.List(originalsDir, depth, false, path.NewRegexEntitiesFilter(ImageExtensionRegex))
+	var originalImages, err = Walk(mapper.OriginalsRoot, maxDepth, IsImage)
 	if err != nil {
 		return nil, err
 	}
 
-	upsizedImages, err := path.List(upsizedDir, depth, false, path.NewRegexEntitiesFilter(ImageExtensionRegex))
+	index, err := mapper.Index(path.OnlyNames(originalImages))
 	if err != nil {
 		return nil, err
 	}
 
-	var upsizedImagesSet = make(map[string]struct{}, len(upsizedImages))
-	for _, img := range upsizedImages {
-		upsizedImagesSet[strings.ReplaceAll(img.AbsolutePath, upsizedDir, "")] = struct{}{}
-	}
 	var newImages []string
-
 	for _, img := range originalImages {
-		if _, exists := upsizedImagesSet[strings.ReplaceAll(img.AbsolutePath, originalsDir, "")]; !exists {
+		if !index.Exists(img.AbsolutePath) {
 			newImages = append(newImages, img.AbsolutePath)
 		}
 	}
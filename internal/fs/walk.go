@@ -0,0 +1,94 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kmulvey/path"
+)
+
+// Filter decides which files Walk returns.
+type Filter func(path.Entry) bool
+
+// Walk returns every file under root that filter accepts, or every file if filter is nil. maxDepth limits how
+// many directories deep to look, 1 is only the files in root and 0 is no limit. Symlinks are followed and entries
+// keep the path they were found at, so a tree mirrored from them matches what the user sees. A symlink back to one
+// of its own parent directories is not followed again, and broken symlinks are skipped.
+func Walk(root string, maxDepth int, filter Filter) ([]path.Entry, error) {
+
+	var absRoot, err = filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving dir: %s, err: %w", root, err)
+	}
+
+	var files []path.Entry
+	if err := walkDir(absRoot, 1, maxDepth, filter, map[string]struct{}{}, &files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// walkDir adds the files in dir to files, ancestors holds the resolved paths of the dirs above it to catch loops.
+func walkDir(dir string, depth, maxDepth int, filter Filter, ancestors map[string]struct{}, files *[]path.Entry) error {
+
+	var realDir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return fmt.Errorf("error resolving dir: %s, err: %w", dir, err)
+	}
+	if _, loop := ancestors[realDir]; loop {
+		return nil
+	}
+	ancestors[realDir] = struct{}{}
+	defer delete(ancestors, realDir)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("error reading dir: %s, err: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		var entryPath = filepath.Join(dir, entry.Name())
+
+		// Stat rather than the dir entry so symlinks are seen as what they point to
+		var info, err = os.Stat(entryPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("error stating file: %s, err: %w", entryPath, err)
+		}
+
+		if info.IsDir() {
+			if maxDepth > 0 && depth >= maxDepth {
+				continue
+			}
+			if err := walkDir(entryPath, depth+1, maxDepth, filter, ancestors, files); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var file = path.Entry{FileInfo: info, AbsolutePath: entryPath}
+		if filter == nil || filter(file) {
+			*files = append(*files, file)
+		}
+	}
+
+	return nil
+}
+
+// WithinDepth reports whether file is no more than maxDepth directories under root, as Walk would find it.
+func WithinDepth(root, file string, maxDepth int) bool {
+	if maxDepth <= 0 {
+		return true
+	}
+
+	var rel, err = filepath.Rel(root, file)
+	if err != nil {
+		return false
+	}
+	return len(strings.Split(filepath.ToSlash(rel), "/")) <= maxDepth
+}
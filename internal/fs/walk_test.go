@@ -0,0 +1,64 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/kmulvey/realesrgan-scheduler/testimages"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalk(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	for file, data := range map[string][]byte{
+		"a.jpg":                testimages.FoxJPG,
+		"notes.txt":            []byte("notes"),
+		"fake.jpg":             testimages.NotAnImage,
+		"2026/b":               testimages.FoxPNG,
+		"2026/trip/day1/c.JPG": testimages.FoxAVIF,
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, file)), 0750))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, file), data, 0600))
+	}
+
+	// a loop back to the root and a link that goes nowhere
+	assert.NoError(t, os.Symlink(dir, filepath.Join(dir, "2026", "trip", "loop")))
+	assert.NoError(t, os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "broken.jpg")))
+
+	var names = func(maxDepth int) []string {
+		var files, err = Walk(dir, maxDepth, IsImage)
+		assert.NoError(t, err)
+
+		var names []string
+		for _, file := range files {
+			rel, err := filepath.Rel(dir, file.AbsolutePath)
+			assert.NoError(t, err)
+			names = append(names, filepath.ToSlash(rel))
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	assert.Equal(t, []string{"2026/b", "2026/trip/day1/c.JPG", "a.jpg"}, names(0))
+	assert.Equal(t, []string{"a.jpg"}, names(1))
+	assert.Equal(t, []string{"2026/b", "a.jpg"}, names(2))
+
+	assert.True(t, WithinDepth(dir, filepath.Join(dir, "2026", "trip", "day1", "c.JPG"), 0))
+	assert.True(t, WithinDepth(dir, filepath.Join(dir, "2026", "b"), 2))
+	assert.False(t, WithinDepth(dir, filepath.Join(dir, "2026", "trip", "day1", "c.JPG"), 2))
+}
+
+func TestImageExtensionRegex(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"a.jpg", "a.Jpg", "a.JPEG", "a.png", "a.webp", "a.avif", "a.HEIC", "a.tif", "a.tiff", "a.bmp", "a.gif"} {
+		assert.True(t, ImageExtensionRegex.MatchString(name), name)
+	}
+	for _, name := range []string{"ajpg", "a.jpg.txt", "a.pngx", "notes.txt"} {
+		assert.False(t, ImageExtensionRegex.MatchString(name), name)
+	}
+}
@@ -0,0 +1,155 @@
+package gpu
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MegapixelsPerGiB is how big an image each GiB of VRAM is trusted with when a device has no MaxMegapixels, so
+// images too big for a small card go to a bigger one instead of crashing realesrgan.
+const MegapixelsPerGiB = 4
+
+// Device is a gpu and how much work it can take on. Fields left at 0 do not limit it.
+type Device struct {
+	// ID is what is passed to realesrgan as -g.
+	ID   uint8
+	Name string
+	// VRAM is the memory of the device in MiB, without MaxMegapixels it limits the images it is given, see
+	// MegapixelsPerGiB.
+	VRAM int
+	// Speed is how fast the device is compared to the others, 1 if 0.
+	Speed float64
+	// MaxJobs is how many images the device upsizes at once, 1 if 0.
+	MaxJobs int
+	// MaxMegapixels is the biggest image the device is given, 0 to go by VRAM, or for no limit without it.
+	MaxMegapixels float64
+}
+
+func (d Device) String() string {
+	if d.Name == "" {
+		return strconv.Itoa(int(d.ID))
+	}
+	return fmt.Sprintf("%d %s", d.ID, d.Name)
+}
+
+func (d Device) maxJobs() int {
+	return max(1, d.MaxJobs)
+}
+
+func (d Device) speed() float64 {
+	if d.Speed <= 0 {
+		return 1
+	}
+	return d.Speed
+}
+
+// Limit is the biggest image the device is allowed, MaxMegapixels or what its VRAM allows, 0 for no limit.
+func (d Device) Limit() float64 {
+	if d.MaxMegapixels > 0 {
+		return d.MaxMegapixels
+	}
+	if d.VRAM > 0 {
+		return float64(d.VRAM) / 1024 * MegapixelsPerGiB
+	}
+	return 0
+}
+
+// Fits reports whether the device is allowed an image of megapixels.
+func (d Device) Fits(megapixels float64) bool {
+	return d.Limit() <= 0 || megapixels <= d.Limit()
+}
+
+// Weight is how many of the jobs of the device an image of megapixels takes up. With a limit, each job gets an even
+// share of it, so an image as big as the limit has the device to itself while small ones share it.
+func (d Device) Weight(megapixels float64) int {
+	if d.Limit() <= 0 {
+		return 1
+	}
+	var weight = int(math.Ceil(megapixels * float64(d.maxJobs()) / d.Limit()))
+	return min(max(1, weight), d.maxJobs())
+}
+
+// ParseDevice parses the id of a device and its capacity, e.g. 0:vram=24576,speed=2,jobs=4,max-mp=100
+func ParseDevice(value string) (Device, error) {
+
+	var id, capacity, _ = strings.Cut(value, ":")
+	var parsedID, err = strconv.ParseUint(id, 10, 8)
+	if err != nil {
+		return Device{}, fmt.Errorf("error parsing gpu id: %s, err: %w", id, err)
+	}
+
+	var device = Device{ID: uint8(parsedID)}
+	if capacity == "" {
+		return device, nil
+	}
+
+	for _, field := range strings.Split(capacity, ",") {
+		var key, value, ok = strings.Cut(field, "=")
+		if !ok {
+			return Device{}, fmt.Errorf("gpu capacity must be key=value, not: %s", field)
+		}
+
+		switch key {
+		case "vram":
+			device.VRAM, err = strconv.Atoi(value)
+		case "speed":
+			device.Speed, err = strconv.ParseFloat(value, 64)
+		case "jobs":
+			device.MaxJobs, err = strconv.Atoi(value)
+		case "max-mp":
+			device.MaxMegapixels, err = strconv.ParseFloat(value, 64)
+		case "name":
+			device.Name = value
+		default:
+			return Device{}, fmt.Errorf("unknown gpu capacity: %s, must be vram, speed, jobs, max-mp or name", key)
+		}
+		if err != nil {
+			return Device{}, fmt.Errorf("error parsing gpu %s: %s, err: %w", key, value, err)
+		}
+	}
+
+	return device, nil
+}
+
+// Devices is a flag.Value that can be given once for each gpu, see ParseDevice.
+type Devices []Device
+
+func (d *Devices) String() string {
+	var devices = make([]string, len(*d))
+	for i, device := range *d {
+		devices[i] = device.String()
+	}
+	return strings.Join(devices, ", ")
+}
+
+func (d *Devices) Set(value string) error {
+	var device, err = ParseDevice(value)
+	if err != nil {
+		return err
+	}
+	*d = append(*d, device)
+	return nil
+}
+
+// Merge returns devices with the capacity of the device with the same id in overrides, the overrides that are not in
+// devices are added to the end. Names are kept unless the override has one.
+func Merge(devices, overrides []Device) []Device {
+
+	var merged = append([]Device(nil), devices...)
+Overrides:
+	for _, override := range overrides {
+		for i := range merged {
+			if merged[i].ID == override.ID {
+				if override.Name == "" {
+					override.Name = merged[i].Name
+				}
+				merged[i] = override
+				continue Overrides
+			}
+		}
+		merged = append(merged, override)
+	}
+	return merged
+}
@@ -0,0 +1,70 @@
+package gpu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDevice(t *testing.T) {
+	t.Parallel()
+
+	var device, err = ParseDevice("1:vram=24576,speed=2.5,jobs=4,max-mp=100,name=big")
+	assert.NoError(t, err)
+	assert.Equal(t, Device{ID: 1, Name: "big", VRAM: 24576, Speed: 2.5, MaxJobs: 4, MaxMegapixels: 100}, device)
+
+	device, err = ParseDevice("0")
+	assert.NoError(t, err)
+	assert.Equal(t, Device{}, device)
+
+	_, err = ParseDevice("gpu0")
+	assert.Error(t, err)
+	_, err = ParseDevice("0:vram")
+	assert.Error(t, err)
+	_, err = ParseDevice("0:vram=lots")
+	assert.Error(t, err)
+	_, err = ParseDevice("0:color=red")
+	assert.Error(t, err)
+
+	var devices Devices
+	assert.NoError(t, devices.Set("0:jobs=2"))
+	assert.NoError(t, devices.Set("1:name=small"))
+	assert.Equal(t, "0, 1 small", devices.String())
+}
+
+func TestWeight(t *testing.T) {
+	t.Parallel()
+
+	var big = Device{MaxJobs: 4, MaxMegapixels: 100}
+	assert.Equal(t, 1, big.Weight(1))
+	assert.Equal(t, 1, big.Weight(25))
+	assert.Equal(t, 2, big.Weight(26))
+	assert.Equal(t, 4, big.Weight(100))
+	assert.Equal(t, 4, big.Weight(400))
+	assert.True(t, big.Fits(100))
+	assert.False(t, big.Fits(101))
+
+	// without a limit every image is one job
+	assert.Equal(t, 1, Device{MaxJobs: 4}.Weight(400))
+	assert.True(t, Device{}.Fits(400))
+
+	// without a limit of its own the VRAM sets it
+	var vram = Device{VRAM: 4096}
+	assert.Equal(t, 16.0, vram.Limit())
+	assert.True(t, vram.Fits(16))
+	assert.False(t, vram.Fits(60))
+	assert.Equal(t, 100.0, Device{VRAM: 4096, MaxMegapixels: 100}.Limit())
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	var discovered = []Device{{ID: 0, Name: "AMD RADV POLARIS10"}, {ID: 1, Name: "NVIDIA GeForce RTX 4090"}}
+	var merged = Merge(discovered, []Device{{ID: 1, MaxJobs: 4}, {ID: 2, Name: "extra"}})
+	assert.Equal(t, []Device{
+		{ID: 0, Name: "AMD RADV POLARIS10"},
+		{ID: 1, Name: "NVIDIA GeForce RTX 4090", MaxJobs: 4},
+		{ID: 2, Name: "extra"},
+	}, merged)
+	assert.Equal(t, Device{ID: 1, Name: "NVIDIA GeForce RTX 4090"}, discovered[1])
+}
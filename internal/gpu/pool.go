@@ -0,0 +1,157 @@
+package gpu
+
+import (
+	"context"
+	"math"
+
+	"github.com/kmulvey/realesrgan-scheduler/pkg/semaphore"
+)
+
+// Pool hands out the gpus to images by their size: images only go to devices that allow them, the smallest device
+// that fits is used so the big ones stay free for big images, and devices that can run several jobs take small images
+// until their jobs are used up. Each device is a resource of a semaphore with its jobs as capacity.
+type Pool struct {
+	sem *semaphore.Semaphore[Device]
+}
+
+// Slot is a place on a device that an image was given, it must be handed back to Release.
+type Slot struct {
+	Device Device
+	lease  semaphore.Lease[Device]
+}
+
+// NewPool makes a pool of the devices, there must be at least one.
+func NewPool(devices ...Device) *Pool {
+	var p = &Pool{sem: semaphore.New[Device]()}
+	for _, d := range devices {
+		p.sem.Add(d, d.maxJobs())
+	}
+	return p
+}
+
+// Devices returns the devices in the pool.
+func (p *Pool) Devices() []Device {
+	var resources = p.sem.Resources()
+	var devices = make([]Device, len(resources))
+	for i, r := range resources {
+		devices[i] = r.Value
+	}
+	return devices
+}
+
+// Status returns how busy each device is and which images it is working on, see semaphore.WithHolder.
+func (p *Pool) Status() []semaphore.Resource[Device] {
+	return p.sem.Resources()
+}
+
+// Acquire waits for a device to take an image of megapixels and returns its slot. Images too big for every device
+// go to the ones that allow the most.
+func (p *Pool) Acquire(ctx context.Context, megapixels float64) (Slot, error) {
+	var lease, err = p.sem.AcquireFunc(ctx, place(megapixels))
+	if err != nil {
+		return Slot{}, err
+	}
+	return Slot{Device: lease.Resource, lease: lease}, nil
+}
+
+// TryAcquire is Acquire for when a device is free now, it reports false otherwise. A nil pool has no devices.
+func (p *Pool) TryAcquire(ctx context.Context, megapixels float64) (Slot, bool) {
+	if p == nil {
+		return Slot{}, false
+	}
+
+	var lease, ok = p.sem.TryAcquireFunc(ctx, place(megapixels))
+	return Slot{Device: lease.Resource, lease: lease}, ok
+}
+
+// Release hands back a slot from Acquire.
+func (p *Pool) Release(slot Slot) {
+	p.sem.Release(slot.lease)
+}
+
+// Disable stops images from being placed on the device with id, e.g. after it failed, the ones on it finish.
+func (p *Pool) Disable(id uint8) error {
+	return p.sem.Disable(p.device(id))
+}
+
+// Enable lets images be placed on a disabled device again.
+func (p *Pool) Enable(id uint8) error {
+	return p.sem.Enable(p.device(id))
+}
+
+func (p *Pool) device(id uint8) Device {
+	for _, d := range p.Devices() {
+		if d.ID == id {
+			return d
+		}
+	}
+	return Device{ID: id}
+}
+
+// place picks the best device for an image of megapixels that has room for it.
+func place(megapixels float64) semaphore.Pick[Device] {
+	return func(resources []semaphore.Resource[Device]) (int, int, bool) {
+
+		var best = -1
+		for _, i := range capable(resources, megapixels) {
+			if resources[i].Free() < resources[i].Value.Weight(megapixels) {
+				continue
+			}
+			if best == -1 || better(resources[i], resources[best]) {
+				best = i
+			}
+		}
+		if best == -1 {
+			return 0, 0, false
+		}
+		return best, resources[best].Value.Weight(megapixels), true
+	}
+}
+
+// capable returns the indexes of the devices that allow an image of megapixels, or of the ones that allow the most
+// if none do.
+func capable(resources []semaphore.Resource[Device], megapixels float64) []int {
+
+	var indexes []int
+	var largest float64
+	for i, r := range resources {
+		if r.Value.Fits(megapixels) {
+			indexes = append(indexes, i)
+		}
+		largest = max(largest, limit(r.Value))
+	}
+	if len(indexes) > 0 {
+		return indexes
+	}
+
+	for i, r := range resources {
+		if limit(r.Value) == largest {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// better reports whether a is a better place for an image than b: the smallest device that fits, then the fastest,
+// then the least busy. Devices with more VRAM allow bigger images, see Device.Limit, so they are only preferred for
+// images the smaller ones are not allowed.
+func better(a, b semaphore.Resource[Device]) bool {
+	if limit(a.Value) != limit(b.Value) {
+		return limit(a.Value) < limit(b.Value)
+	}
+	if a.Value.VRAM != b.Value.VRAM && a.Value.VRAM > 0 && b.Value.VRAM > 0 {
+		return a.Value.VRAM < b.Value.VRAM
+	}
+	if a.Value.speed() != b.Value.speed() {
+		return a.Value.speed() > b.Value.speed()
+	}
+	return float64(a.Used)/float64(a.Capacity) < float64(b.Used)/float64(b.Capacity)
+}
+
+// limit is the biggest image a device allows, no limit is bigger than any.
+func limit(d Device) float64 {
+	if d.Limit() <= 0 {
+		return math.Inf(1)
+	}
+	return d.Limit()
+}
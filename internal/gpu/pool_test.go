@@ -0,0 +1,107 @@
+package gpu
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kmulvey/realesrgan-scheduler/pkg/semaphore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+
+	var ctx = context.Background()
+	var small = Device{ID: 0, VRAM: 4096, MaxMegapixels: 16}
+	var big = Device{ID: 1, VRAM: 24576, MaxJobs: 4, MaxMegapixels: 100}
+	var pool = NewPool(small, big)
+	assert.Equal(t, []Device{small, big}, pool.Devices())
+
+	// a scan too big for the small card goes to the big one
+	var scan, err = pool.Acquire(semaphore.WithHolder(ctx, "scan.tiff"), 60)
+	assert.NoError(t, err)
+	assert.Equal(t, big, scan.Device)
+
+	// small images go to the small card first, then share the big one
+	thumb, err := pool.Acquire(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, small, thumb.Device)
+	thumb2, err := pool.Acquire(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, big, thumb2.Device)
+
+	var status = pool.Status()
+	assert.Equal(t, 4, status[1].Used)
+	assert.Equal(t, "scan.tiff", status[1].Leases[0].Holder)
+	assert.Equal(t, 3, status[1].Leases[0].Weight)
+
+	// the scan takes 3 of the 4 jobs of the big card and the thumbnail the last, so both cards are full
+	_, ok := pool.TryAcquire(ctx, 1)
+	assert.False(t, ok)
+
+	var acquired = make(chan Slot)
+	go func() {
+		var slot, _ = pool.Acquire(ctx, 1)
+		acquired <- slot
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a slot on a full pool")
+	case <-time.After(50 * time.Millisecond):
+	}
+	pool.Release(thumb)
+	var thumb3 = <-acquired
+	assert.Equal(t, small, thumb3.Device)
+
+	// giving up on a full pool
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = pool.Acquire(timeout, 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// images too big for every card go to the biggest
+	pool.Release(scan)
+	pool.Release(thumb2)
+	huge, ok := pool.TryAcquire(ctx, 500)
+	assert.True(t, ok)
+	assert.Equal(t, big, huge.Device)
+	pool.Release(huge)
+
+	// a disabled card is skipped even if it would be the best
+	pool.Release(thumb3)
+	assert.NoError(t, pool.Disable(small.ID))
+	thumb, ok = pool.TryAcquire(ctx, 1)
+	assert.True(t, ok)
+	assert.Equal(t, big, thumb.Device)
+	assert.NoError(t, pool.Enable(small.ID))
+	assert.Error(t, pool.Disable(7))
+
+	var none *Pool
+	_, ok = none.TryAcquire(ctx, 1)
+	assert.False(t, ok)
+}
+
+func TestPoolVRAM(t *testing.T) {
+	t.Parallel()
+
+	var ctx = context.Background()
+	var small = Device{ID: 0, VRAM: 4096}
+	var big = Device{ID: 1, VRAM: 24576}
+	var pool = NewPool(small, big)
+
+	// the scan is too big for the small card even though it is free and would be picked for small images
+	var scan, ok = pool.TryAcquire(ctx, 60)
+	assert.True(t, ok)
+	assert.Equal(t, big, scan.Device)
+
+	thumb, ok := pool.TryAcquire(ctx, 1)
+	assert.True(t, ok)
+	assert.Equal(t, small, thumb.Device)
+
+	// and it waits for the big card rather than take the small one
+	pool.Release(thumb)
+	_, ok = pool.TryAcquire(ctx, 60)
+	assert.False(t, ok)
+	pool.Release(scan)
+}
@@ -0,0 +1,89 @@
+package pathmap
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
+)
+
+// AlphaPolicy is what happens to originals with transparent pixels.
+type AlphaPolicy string
+
+const (
+	// AlphaFlatten puts transparent originals on a white background and keeps the configured format.
+	AlphaFlatten AlphaPolicy = "flatten"
+	// AlphaPreserve lets realesrgan upsize the alpha channel with the colors, transparent originals are written as
+	// png when the configured format is jpg.
+	AlphaPreserve AlphaPolicy = "preserve"
+	// AlphaSeparate upsizes the alpha channel as an image of its own and puts it back afterwards, for models that
+	// mangle it. Transparent originals are always written as png.
+	AlphaSeparate AlphaPolicy = "separate"
+)
+
+// AlphaPath returns where an upsized image goes instead of upsized when its original is transparent, because its
+// format would lose the transparency that the policy keeps. It is empty when the format keeps it or the policy drops it.
+func (m Mapper) AlphaPath(upsized string) string {
+
+	var ext = filepath.Ext(upsized)
+	switch lower := strings.ToLower(ext); m.Alpha {
+	case AlphaPreserve:
+		if lower != ".jpg" && lower != ".jpeg" {
+			return ""
+		}
+	case AlphaSeparate:
+		if lower == ".png" {
+			return ""
+		}
+	default:
+		return ""
+	}
+
+	return strings.TrimSuffix(upsized, ext) + ".png"
+}
+
+// Resolve returns where the upsized version of original is written, upsized from Map or its AlphaPath if original is
+// transparent. It decodes original, so it is only called when an image is queued, and the upsized image is found
+// under either name afterwards, see Existing, so editing the pixels of the original does not move it.
+func (m Mapper) Resolve(original, upsized string) string {
+
+	var alphaPath = m.alphaPath(original, upsized)
+	if alphaPath == "" {
+		return upsized
+	}
+
+	if transparent, err := imagetype.HasTransparency(original); err == nil && transparent {
+		return alphaPath
+	}
+	return upsized
+}
+
+// Existing returns which of upsized from Map and its AlphaPath is the upsized version of original on disk, the bool is
+// false if neither is. The AlphaPath may be the upsized name of another original, so a file there only counts when its
+// cache or sidecar record says it was made from original, see Owner.
+func (m Mapper) Existing(original, upsized string) (string, bool) {
+
+	if _, err := os.Stat(upsized); err == nil {
+		return upsized, true
+	}
+
+	var alphaPath = m.alphaPath(original, upsized)
+	if alphaPath == "" {
+		return "", false
+	}
+	if _, err := os.Stat(alphaPath); err == nil && m.owner(alphaPath) == original {
+		return alphaPath, true
+	}
+	return "", false
+}
+
+// alphaPath is AlphaPath for original, it is empty for jpegs as they have no alpha channel to keep.
+func (m Mapper) alphaPath(original, upsized string) string {
+
+	switch strings.ToLower(filepath.Ext(original)) {
+	case ".jpg", ".jpeg":
+		return ""
+	}
+	return m.AlphaPath(upsized)
+}
@@ -0,0 +1,135 @@
+package pathmap
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kmulvey/realesrgan-scheduler/testimages"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlphaExt(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var img = image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 0xff, A: 0x80})
+
+	var file, err = os.Create(filepath.Join(dir, "glass.png"))
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(file, img))
+	assert.NoError(t, file.Close())
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "fox.png"), testimages.FoxPNG, 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "fox.webp"), nil, 0600))
+
+	var cases = []struct {
+		alpha    AlphaPolicy
+		ext      string
+		original string
+		upsized  string
+	}{
+		{"", ".jpg", "glass.png", "glass.png"},
+		{AlphaPreserve, ".jpg", "fox.png", "fox.jpg"},
+		{AlphaPreserve, ".webp", "glass.png", "glass.webp"},
+		{AlphaFlatten, ".jpg", "glass.png", "glass.jpg"},
+		{AlphaSeparate, ".webp", "glass.png", "glass.png"},
+		{AlphaSeparate, "", "fox.webp", "fox.webp"},
+	}
+
+	for _, c := range cases {
+		var m = Mapper{OriginalsRoot: dir, UpsizedRoot: "/upscaled", Ext: c.ext, Alpha: c.alpha}
+		assert.NoError(t, m.Validate())
+
+		var upsized, err = m.Map(filepath.Join(dir, c.original))
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join("/upscaled", c.upsized), m.Resolve(filepath.Join(dir, c.original), upsized), c)
+	}
+
+	// only the name is mapped, the pixels are looked at once the image is queued
+	var m = Mapper{OriginalsRoot: dir, UpsizedRoot: filepath.Join(dir, "upscaled"), Ext: ".jpg"}
+	assert.NoError(t, m.Validate())
+	upsized, err := m.Map(filepath.Join(dir, "glass.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "upscaled", "glass.jpg"), upsized)
+	assert.Equal(t, filepath.Join(dir, "upscaled", "glass.png"), m.AlphaPath(upsized))
+	assert.Equal(t, "", m.AlphaPath(filepath.Join(dir, "upscaled", "glass.webp")))
+
+	// and either name counts as upsized, the AlphaPath only when it was made from this original
+	assert.False(t, m.Exists(filepath.Join(dir, "glass.png")))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "upscaled"), 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "upscaled", "glass.png"), nil, 0600))
+	assert.False(t, m.Exists(filepath.Join(dir, "glass.png")))
+	m.Owner = func(upsized string) (string, bool) {
+		return filepath.Join(dir, "glass.png"), upsized == filepath.Join(dir, "upscaled", "glass.png")
+	}
+	assert.True(t, m.Exists(filepath.Join(dir, "glass.png")))
+	existing, ok := m.Existing(filepath.Join(dir, "glass.png"), upsized)
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(dir, "upscaled", "glass.png"), existing)
+
+	index, err := m.Index([]string{filepath.Join(dir, "glass.png"), filepath.Join(dir, "fox.png")})
+	assert.NoError(t, err)
+	resolved, err := index.Resolve(filepath.Join(dir, "glass.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, existing, resolved)
+	resolved, err = index.Resolve(filepath.Join(dir, "fox.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "upscaled", "fox.jpg"), resolved)
+
+	assert.Error(t, (&Mapper{OriginalsRoot: dir, UpsizedRoot: "/upscaled", Alpha: "drop"}).Validate())
+}
+
+func TestAlphaOwner(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var upscaled = filepath.Join(dir, "upscaled")
+	assert.NoError(t, os.MkdirAll(upscaled, 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "x.jpg"), testimages.FoxJPG, 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "x.png"), testimages.FoxPNG, 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "x.webp"), nil, 0600))
+
+	// x.png is upsized, the file is its own and not the AlphaPath of x.jpg
+	assert.NoError(t, os.WriteFile(filepath.Join(upscaled, "x.png"), nil, 0600))
+	var m = Mapper{OriginalsRoot: dir, UpsizedRoot: upscaled, Alpha: AlphaPreserve}
+	m.Owner = func(upsized string) (string, bool) {
+		return filepath.Join(dir, "x.png"), upsized == filepath.Join(upscaled, "x.png")
+	}
+	assert.NoError(t, m.Validate())
+
+	assert.True(t, m.Exists(filepath.Join(dir, "x.png")))
+	assert.False(t, m.Exists(filepath.Join(dir, "x.jpg")))
+
+	var index, err = m.Index([]string{filepath.Join(dir, "x.jpg"), filepath.Join(dir, "x.png")})
+	assert.NoError(t, err)
+	assert.False(t, index.Exists(filepath.Join(dir, "x.jpg")))
+	resolved, err := index.Resolve(filepath.Join(dir, "x.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(upscaled, "x.jpg"), resolved)
+
+	// a webp can be transparent, so with separate it may be written where x.png goes
+	m.Alpha = AlphaSeparate
+	assert.False(t, m.Exists(filepath.Join(dir, "x.webp")))
+	_, err = m.Index([]string{filepath.Join(dir, "x.webp"), filepath.Join(dir, "x.png")})
+	var collisionErr *CollisionError
+	assert.True(t, errors.As(err, &collisionErr))
+	assert.Equal(t, []Collision{
+		{Upsized: filepath.Join(upscaled, "x.png"), Originals: []string{filepath.Join(dir, "x.png"), filepath.Join(dir, "x.webp")}},
+	}, collisionErr.Collisions)
+
+	// the one that made the file keeps the name
+	m.OnCollision = Rename
+	index, err = m.Index([]string{filepath.Join(dir, "x.webp"), filepath.Join(dir, "x.png")})
+	assert.NoError(t, err)
+	upsized, err := index.Map(filepath.Join(dir, "x.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(upscaled, "x.png"), upsized)
+	upsized, err = index.Map(filepath.Join(dir, "x.webp"))
+	assert.NoError(t, err)
+	assert.Regexp(t, `/x_[0-9a-f]{8}\.webp$`, upsized)
+}
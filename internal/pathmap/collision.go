@@ -0,0 +1,235 @@
+package pathmap
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kmulvey/realesrgan-scheduler/pkg/provenance"
+)
+
+// CollisionPolicy is what to do when more than one original maps to the same upsized path.
+type CollisionPolicy string
+
+const (
+	// Refuse fails with a report of every collision.
+	Refuse CollisionPolicy = "refuse"
+	// Rename adds a hash of their path to the names of colliding originals. The one an existing upsized image was made
+	// from keeps its name, see Mapper.Owner, so names never move from one original to another.
+	Rename CollisionPolicy = "rename"
+)
+
+// Collision is an upsized path more than one original maps to.
+type Collision struct {
+	Upsized   string
+	Originals []string
+}
+
+// CollisionError is returned with the Refuse policy, it lists every collision found.
+type CollisionError struct {
+	Collisions []Collision
+}
+
+func (e *CollisionError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d upsized paths would be written by more than one original, rename them or use -output-collisions %s:", len(e.Collisions), Rename)
+	for _, collision := range e.Collisions {
+		fmt.Fprintf(&b, "\n  %s <- %s", collision.Upsized, strings.Join(collision.Originals, ", "))
+	}
+	return b.String()
+}
+
+// Index maps originals like Mapper does, but makes sure no two originals it has seen get the same upsized path.
+// It is safe for concurrent use.
+type Index struct {
+	mapper  Mapper
+	lock    sync.Mutex
+	upsized map[string]string // original -> upsized
+	claimed map[string]string // upsized or AlphaPath -> original, "" when it is kept free for a group that was renamed
+}
+
+// Index maps all of originals up front so any collisions are found before work starts. With the Refuse policy the
+// error is a *CollisionError.
+func (m Mapper) Index(originals []string) (*Index, error) {
+
+	var index = &Index{
+		mapper:  m,
+		upsized: make(map[string]string, len(originals)),
+		claimed: make(map[string]string, len(originals)),
+	}
+
+	var groups = make(map[string][]string, len(originals))
+	var plain = make(map[string]string, len(originals))
+	for _, original := range originals {
+		var upsized, err = m.Map(original)
+		if err != nil {
+			return nil, err
+		}
+		plain[original] = upsized
+		groups[upsized] = append(groups[upsized], original)
+
+		// a transparent original is written to its AlphaPath, which can be the name of another original
+		if alphaPath := m.alphaPath(original, upsized); alphaPath != "" {
+			groups[alphaPath] = append(groups[alphaPath], original)
+		}
+	}
+
+	var upsizedPaths = make([]string, 0, len(groups))
+	for upsized := range groups {
+		upsizedPaths = append(upsizedPaths, upsized)
+	}
+	sort.Strings(upsizedPaths)
+
+	var collisions []Collision
+	var renamed = make(map[string]bool)
+	for _, upsized := range upsizedPaths {
+		var group = groups[upsized]
+		if len(group) == 1 {
+			continue
+		}
+		sort.Strings(group)
+		collisions = append(collisions, Collision{Upsized: upsized, Originals: group})
+
+		// which original sorts first depends on which ones exist now, only the one that already has the name keeps it
+		var owner = m.owner(upsized)
+		index.claimed[upsized] = ""
+		for _, original := range group {
+			if original != owner {
+				renamed[original] = true
+			}
+		}
+	}
+
+	if len(collisions) > 0 && m.OnCollision != Rename {
+		return nil, &CollisionError{Collisions: collisions}
+	}
+
+	var renames = make([]string, 0, len(renamed))
+	for original, upsized := range plain {
+		if renamed[original] {
+			renames = append(renames, original)
+		} else {
+			index.claim(original, upsized)
+		}
+	}
+	sort.Strings(renames)
+
+	// every plain name is claimed first so a renamed original can never take one
+	for _, original := range renames {
+		if _, err := index.rename(original); err != nil {
+			return nil, err
+		}
+	}
+
+	return index, nil
+}
+
+// Map returns where the upsized version of original goes, an original the index has not seen yet is added to it.
+func (i *Index) Map(original string) (string, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if upsized, ok := i.upsized[original]; ok {
+		return upsized, nil
+	}
+
+	var upsized, err = i.mapper.Map(original)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range []string{upsized, i.mapper.alphaPath(original, upsized)} {
+		if other, taken := i.claimed[name]; taken && name != "" {
+			if i.mapper.OnCollision != Rename {
+				var group = []string{other, original}
+				sort.Strings(group)
+				return "", &CollisionError{Collisions: []Collision{{Upsized: name, Originals: group}}}
+			}
+			return i.rename(original)
+		}
+	}
+
+	i.claim(original, upsized)
+	return upsized, nil
+}
+
+// Exists reports whether the upsized version of original is already on disk.
+func (i *Index) Exists(original string) bool {
+	_, exists := i.Existing(original)
+	return exists
+}
+
+// Existing returns the upsized version of original on disk, see Mapper.Existing. The bool is false if there is none.
+func (i *Index) Existing(original string) (string, bool) {
+	var upsized, err = i.Map(original)
+	if err != nil {
+		return "", false
+	}
+	return i.mapper.Existing(original, upsized)
+}
+
+// Resolve returns where the upsized version of original is: the existing upsized image if there is one, otherwise
+// where it is to be written, see Mapper.Resolve.
+func (i *Index) Resolve(original string) (string, error) {
+	var upsized, err = i.Map(original)
+	if err != nil {
+		return "", err
+	}
+
+	if existing, ok := i.mapper.Existing(original, upsized); ok {
+		return existing, nil
+	}
+	return i.mapper.Resolve(original, upsized), nil
+}
+
+// claim gives upsized to original and keeps its AlphaPath for it too, unless another original already has that name.
+func (i *Index) claim(original, upsized string) {
+	i.upsized[original] = upsized
+	i.claimed[upsized] = original
+
+	if alphaPath := i.mapper.alphaPath(original, upsized); alphaPath != "" {
+		if _, taken := i.claimed[alphaPath]; !taken {
+			i.claimed[alphaPath] = original
+		}
+	}
+}
+
+// owner returns the original the existing upsized image was made from, or "" if it does not exist or is not known.
+func (m Mapper) owner(upsized string) string {
+	if m.Owner != nil {
+		if original, ok := m.Owner(upsized); ok {
+			return original
+		}
+	}
+	if record, err := provenance.Read(upsized); err == nil {
+		return record.SourceFile
+	}
+	return ""
+}
+
+// rename gives original a name made unique by a hash of its path relative to the originals root, so it is the same
+// every run.
+func (i *Index) rename(original string) (string, error) {
+
+	var upsized, err = i.mapper.Map(original)
+	if err != nil {
+		return "", err
+	}
+
+	var rel, _ = filepath.Rel(i.mapper.OriginalsRoot, original)
+	var hash = fnv.New32a()
+	hash.Write([]byte(filepath.ToSlash(rel)))
+
+	var ext = filepath.Ext(upsized)
+	upsized = fmt.Sprintf("%s_%08x%s", strings.TrimSuffix(upsized, ext), hash.Sum32(), ext)
+
+	if other, taken := i.claimed[upsized]; taken {
+		return "", &CollisionError{Collisions: []Collision{{Upsized: upsized, Originals: []string{other, original}}}}
+	}
+
+	i.claim(original, upsized)
+	return upsized, nil
+}
@@ -0,0 +1,87 @@
+package pathmap
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexRefuse(t *testing.T) {
+	t.Parallel()
+
+	var m = Mapper{OriginalsRoot: "/photos", UpsizedRoot: "/upscaled", Ext: ".jpg", Layout: Flatten}
+	assert.NoError(t, m.Validate())
+
+	var _, err = m.Index([]string{"/photos/a/x.png", "/photos/a/x.jpg", "/photos/a/sub/IMG_0001.jpg", "/photos/a/IMG_0001.jpg", "/photos/a/y.jpg"})
+
+	var collisionErr *CollisionError
+	assert.True(t, errors.As(err, &collisionErr))
+	assert.Equal(t, []Collision{
+		{Upsized: "/upscaled/IMG_0001.jpg", Originals: []string{"/photos/a/IMG_0001.jpg", "/photos/a/sub/IMG_0001.jpg"}},
+		{Upsized: "/upscaled/x.jpg", Originals: []string{"/photos/a/x.jpg", "/photos/a/x.png"}},
+	}, collisionErr.Collisions)
+	assert.Contains(t, err.Error(), "/upscaled/x.jpg <- /photos/a/x.jpg, /photos/a/x.png")
+
+	// an original found later cannot take a path already in use either
+	index, err := m.Index([]string{"/photos/a/x.jpg"})
+	assert.NoError(t, err)
+	_, err = index.Map("/photos/b/x.png")
+	assert.True(t, errors.As(err, &collisionErr))
+}
+
+func TestIndexRename(t *testing.T) {
+	t.Parallel()
+
+	var m = Mapper{OriginalsRoot: "/photos", UpsizedRoot: "/upscaled", Ext: ".jpg", OnCollision: Rename}
+	assert.NoError(t, m.Validate())
+	var owners = map[string]string{}
+	m.Owner = func(upsized string) (string, bool) {
+		var original, ok = owners[upsized]
+		return original, ok
+	}
+
+	var originals = []string{"/photos/a/x.png", "/photos/a/x.jpg", "/photos/a/y.jpg"}
+	var index, err = m.Index(originals)
+	assert.NoError(t, err)
+
+	var first = make(map[string]string, len(originals))
+	for _, original := range originals {
+		first[original], err = index.Map(original)
+		assert.NoError(t, err)
+	}
+	// neither has been upsized, so neither gets the plain name
+	assert.Regexp(t, `^/upscaled/a/x_[0-9a-f]{8}\.jpg$`, first["/photos/a/x.jpg"])
+	assert.Regexp(t, `^/upscaled/a/x_[0-9a-f]{8}\.jpg$`, first["/photos/a/x.png"])
+	assert.NotEqual(t, first["/photos/a/x.jpg"], first["/photos/a/x.png"])
+	assert.Equal(t, "/upscaled/a/y.jpg", first["/photos/a/y.jpg"])
+
+	// the same originals in any order get the same names
+	index, err = m.Index([]string{"/photos/a/y.jpg", "/photos/a/x.jpg", "/photos/a/x.png"})
+	assert.NoError(t, err)
+	for original, upsized := range first {
+		var again, err = index.Map(original)
+		assert.NoError(t, err)
+		assert.Equal(t, upsized, again)
+	}
+
+	later, err := index.Map("/photos/a/y.png")
+	assert.NoError(t, err)
+	assert.Regexp(t, `^/upscaled/a/y_[0-9a-f]{8}\.jpg$`, later)
+
+	// the plain name of a renamed group is not handed out later either
+	later, err = index.Map("/photos/a/x.webp")
+	assert.NoError(t, err)
+	assert.Regexp(t, `^/upscaled/a/x_[0-9a-f]{8}\.jpg$`, later)
+
+	// x.png was upsized on its own before x.jpg was added, it keeps its name even though x.jpg sorts first
+	owners["/upscaled/a/x.jpg"] = "/photos/a/x.png"
+	index, err = m.Index([]string{"/photos/a/x.jpg", "/photos/a/x.png"})
+	assert.NoError(t, err)
+	upsized, err := index.Map("/photos/a/x.png")
+	assert.NoError(t, err)
+	assert.Equal(t, "/upscaled/a/x.jpg", upsized)
+	upsized, err = index.Map("/photos/a/x.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, first["/photos/a/x.jpg"], upsized)
+}
@@ -0,0 +1,169 @@
+package pathmap
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Layout is how upsized images are arranged under the upsized root.
+type Layout string
+
+const (
+	// Mirror keeps each image in the same directories, relative to the root, as its original.
+	Mirror Layout = "mirror"
+	// Flatten puts every upsized image directly in the upsized root.
+	Flatten Layout = "flatten"
+)
+
+// outputExtensions are the formats realesrgan can write.
+var outputExtensions = map[string]struct{}{".jpg": {}, ".jpeg": {}, ".png": {}, ".webp": {}}
+
+// defaultExt is used for originals in a format realesrgan cannot write when no Ext is configured.
+const defaultExt = ".png"
+
+// Mapper decides where the upsized version of an original image goes. Every command uses it so they all agree on
+// which originals have already been upsized.
+type Mapper struct {
+	OriginalsRoot string
+	UpsizedRoot   string
+	// Ext replaces the extension of the original, e.g. ".jpg". Empty keeps the extension of the original
+	// if realesrgan can write that format, and uses .png if not.
+	Ext string
+	// Prefix and Suffix are added around the file name, e.g. a Suffix of "_4x" maps fox.jpg to fox_4x.jpg.
+	Prefix string
+	Suffix string
+	Layout Layout
+	// OnCollision is what to do when originals would overwrite each other, see Index.
+	OnCollision CollisionPolicy
+	// Alpha is what happens to transparent originals, it can change their extension so it is part of the mapping.
+	Alpha AlphaPolicy
+	// Owner returns the original an existing upsized image was made from, so with the Rename policy a name that was
+	// handed out stays with its original when colliding ones turn up later. The provenance sidecar of the upsized image
+	// is read when it is nil or does not know.
+	Owner func(upsized string) (string, bool)
+}
+
+// RegisterFlags adds the options for the output name and layout to flags, the roots are left to each command. The Ext
+// and Layout already set on m are the defaults, so a command can keep the naming it always used.
+func (m *Mapper) RegisterFlags(flags *flag.FlagSet) {
+	var layout = m.Layout
+	if layout == "" {
+		layout = Mirror
+	}
+	flags.StringVar(&m.Ext, "output-ext", m.Ext, "extension (format) of upsized images: .jpg, .png or .webp, empty keeps the original's")
+	flags.StringVar(&m.Prefix, "output-prefix", "", "added to the start of upsized image names")
+	flags.StringVar(&m.Suffix, "output-suffix", "", "added to the end of upsized image names, before the extension, e.g. _4x")
+	flags.Func("output-layout", fmt.Sprintf("mirror keeps the originals directories, flatten puts every image in the upsized dir (default %s)", layout), func(value string) error {
+		m.Layout = Layout(value)
+		return nil
+	})
+	flags.Func("output-collisions", "when originals map to the same upsized image, refuse to start or rename them with a hash of their path (default refuse)", func(value string) error {
+		m.OnCollision = CollisionPolicy(value)
+		return nil
+	})
+	flags.Func("alpha", "what to do with transparent images: flatten them onto white, preserve them by writing png instead of jpg, or separate to upsize the alpha channel on its own (default preserve)", func(value string) error {
+		m.Alpha = AlphaPolicy(value)
+		return nil
+	})
+}
+
+// Validate checks the options and normalizes Ext and Layout, it must be called before Map.
+func (m *Mapper) Validate() error {
+
+	if m.OriginalsRoot == "" || m.UpsizedRoot == "" {
+		return errors.New("both the originals and upsized dirs are required")
+	}
+
+	// listings return absolute paths so the roots have to be too
+	var err error
+	if m.OriginalsRoot, err = filepath.Abs(m.OriginalsRoot); err != nil {
+		return fmt.Errorf("error resolving originals dir: %s, err: %w", m.OriginalsRoot, err)
+	}
+	if m.UpsizedRoot, err = filepath.Abs(m.UpsizedRoot); err != nil {
+		return fmt.Errorf("error resolving upsized dir: %s, err: %w", m.UpsizedRoot, err)
+	}
+
+	if m.Ext != "" {
+		m.Ext = strings.ToLower(m.Ext)
+		if !strings.HasPrefix(m.Ext, ".") {
+			m.Ext = "." + m.Ext
+		}
+		if _, ok := outputExtensions[m.Ext]; !ok {
+			return fmt.Errorf("realesrgan cannot write %s images, use .jpg, .png or .webp", m.Ext)
+		}
+	}
+
+	switch m.Layout {
+	case "":
+		m.Layout = Mirror
+	case Mirror, Flatten:
+	default:
+		return fmt.Errorf("unknown layout: %s, must be %s or %s", m.Layout, Mirror, Flatten)
+	}
+
+	switch m.OnCollision {
+	case "":
+		m.OnCollision = Refuse
+	case Refuse, Rename:
+	default:
+		return fmt.Errorf("unknown collision policy: %s, must be %s or %s", m.OnCollision, Refuse, Rename)
+	}
+
+	switch m.Alpha {
+	case "":
+		m.Alpha = AlphaPreserve
+	case AlphaFlatten, AlphaPreserve, AlphaSeparate:
+	default:
+		return fmt.Errorf("unknown alpha policy: %s, must be %s, %s or %s", m.Alpha, AlphaFlatten, AlphaPreserve, AlphaSeparate)
+	}
+
+	if strings.ContainsRune(m.Prefix+m.Suffix, filepath.Separator) {
+		return errors.New("the output prefix and suffix cannot contain a path separator")
+	}
+
+	return nil
+}
+
+// Map returns where the upsized version of original goes. It only looks at the name of original, transparent ones
+// may be written to its AlphaPath instead, see Resolve.
+func (m Mapper) Map(original string) (string, error) {
+
+	var rel, err = filepath.Rel(m.OriginalsRoot, original)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not in the originals dir: %s", original, m.OriginalsRoot)
+	}
+
+	var name = filepath.Base(rel)
+	var originalExt = filepath.Ext(name)
+	name = m.Prefix + strings.TrimSuffix(name, originalExt) + m.Suffix + m.ext(originalExt)
+
+	if m.Layout == Flatten {
+		return filepath.Join(m.UpsizedRoot, name), nil
+	}
+	return filepath.Join(m.UpsizedRoot, filepath.Dir(rel), name), nil
+}
+
+// Exists reports whether the upsized version of original is already on disk.
+func (m Mapper) Exists(original string) bool {
+	var upsized, err = m.Map(original)
+	if err != nil {
+		return false
+	}
+
+	_, exists := m.Existing(original, upsized)
+	return exists
+}
+
+func (m Mapper) ext(originalExt string) string {
+	if m.Ext != "" {
+		return m.Ext
+	}
+
+	if _, ok := outputExtensions[strings.ToLower(originalExt)]; ok {
+		return originalExt
+	}
+	return defaultExt
+}
@@ -0,0 +1,76 @@
+package pathmap
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMap(t *testing.T) {
+	t.Parallel()
+
+	var m = Mapper{OriginalsRoot: "/photos/", UpsizedRoot: "/upscaled"}
+	assert.NoError(t, m.Validate())
+	assert.Equal(t, Mirror, m.Layout)
+
+	var cases = []struct {
+		mapper   Mapper
+		original string
+		upsized  string
+	}{
+		{m, "/photos/fox.jpg", "/upscaled/fox.jpg"},
+		{m, "/photos/2026/trip/fox.PNG", "/upscaled/2026/trip/fox.PNG"},
+		{m, "/photos/2026/fox.avif", "/upscaled/2026/fox.png"},
+		{Mapper{OriginalsRoot: "/photos", UpsizedRoot: "/upscaled", Ext: "JPG", Suffix: "_4x"}, "/photos/2026/fox.png", "/upscaled/2026/fox_4x.jpg"},
+		{Mapper{OriginalsRoot: "/photos", UpsizedRoot: "/upscaled", Ext: ".webp", Prefix: "big-", Layout: Flatten}, "/photos/2026/trip/fox.jpg", "/upscaled/big-fox.webp"},
+	}
+
+	for _, c := range cases {
+		assert.NoError(t, c.mapper.Validate())
+		var upsized, err = c.mapper.Map(c.original)
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.FromSlash(c.upsized), upsized)
+	}
+
+	var _, err = m.Map("/elsewhere/fox.jpg")
+	assert.Error(t, err)
+	_, err = m.Map("/photos2/fox.jpg")
+	assert.Error(t, err)
+
+	assert.Error(t, (&Mapper{OriginalsRoot: "/photos", UpsizedRoot: "/upscaled", Ext: ".gif"}).Validate())
+	assert.Error(t, (&Mapper{OriginalsRoot: "/photos", UpsizedRoot: "/upscaled", Layout: "tree"}).Validate())
+	assert.Error(t, (&Mapper{OriginalsRoot: "/photos"}).Validate())
+}
+
+func TestExists(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var m = Mapper{OriginalsRoot: filepath.Join(dir, "photos"), UpsizedRoot: filepath.Join(dir, "upscaled"), Ext: ".jpg"}
+	assert.NoError(t, m.Validate())
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "upscaled", "trip"), 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "upscaled", "trip", "fox.jpg"), nil, 0600))
+
+	assert.True(t, m.Exists(filepath.Join(dir, "photos", "trip", "fox.png")))
+	assert.False(t, m.Exists(filepath.Join(dir, "photos", "fox.png")))
+}
+
+func TestRegisterFlags(t *testing.T) {
+	t.Parallel()
+
+	// a command keeps its own naming unless it is asked for another
+	var m = Mapper{Ext: ".jpg", Layout: Flatten}
+	var flags = flag.NewFlagSet("local", flag.ContinueOnError)
+	m.RegisterFlags(flags)
+	assert.NoError(t, flags.Parse(nil))
+	assert.Equal(t, Mapper{Ext: ".jpg", Layout: Flatten}, m)
+
+	flags = flag.NewFlagSet("local", flag.ContinueOnError)
+	m.RegisterFlags(flags)
+	assert.NoError(t, flags.Parse([]string{"-output-ext", "", "-output-layout", "mirror"}))
+	assert.Equal(t, Mapper{Layout: Mirror}, m)
+}
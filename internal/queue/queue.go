@@ -56,6 +56,56 @@ func (q *Queue) NextImage() *realesrgan.ImageConfig {
 	return nextImage
 }
 
+// Peek returns up to n images from the front of the queue without removing them.
+func (q *Queue) Peek(n int) []*realesrgan.ImageConfig {
+
+	q.Lock.RLock()
+	defer q.Lock.RUnlock()
+
+	var images []*realesrgan.ImageConfig
+	for next := q.List.Front(); next != nil && len(images) < n; next = next.Next() {
+		var image, _ = next.Value.(*realesrgan.ImageConfig)
+		images = append(images, image)
+	}
+	return images
+}
+
+// NextImageIf is NextImage for when the image at the front of the queue is accepted, it returns nil otherwise.
+func (q *Queue) NextImageIf(accept func(*realesrgan.ImageConfig) bool) *realesrgan.ImageConfig {
+
+	q.Lock.Lock()
+	defer q.Lock.Unlock()
+
+	var next = q.List.Front()
+	if next == nil {
+		return nil
+	}
+
+	var nextImage, _ = next.Value.(*realesrgan.ImageConfig)
+	if !accept(nextImage) {
+		return nil
+	}
+
+	q.RemovedImages[nextImage.SourceFile] = struct{}{}
+	q.List.Remove(next)
+
+	return nextImage
+}
+
+// Done forgets that the image was taken off the queue, so it can be added again, e.g. to retry it after it failed.
+// It has to be called once the image is finished, whether it was upsized or not.
+func (q *Queue) Done(image *realesrgan.ImageConfig) {
+
+	if q == nil {
+		return
+	}
+
+	q.Lock.Lock()
+	defer q.Lock.Unlock()
+
+	delete(q.RemovedImages, image.SourceFile)
+}
+
 // Add dedup files based on abs path and adds the given image to the list in size order.
 func (q *Queue) Add(newImage *realesrgan.ImageConfig) error {
 
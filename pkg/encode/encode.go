@@ -0,0 +1,241 @@
+package encode
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/png" // register decoder
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The tools used for what the Go encoders cannot do.
+const (
+	DefaultJPEGTran = "jpegtran"
+	DefaultCWebP    = "cwebp"
+)
+
+// defaultQuality is used for lossy formats when encoding is on but no quality was given.
+const defaultQuality = 90
+
+// minQuality is the lowest quality a byte budget can push an image to.
+const minQuality = 10
+
+// compressionLevels are the names PNGCompression can take.
+var compressionLevels = []string{"default", "none", "fast", "best"}
+
+// Options is how upsized images are encoded. The zero value leaves them as realesrgan wrote them.
+type Options struct {
+	// JPEGQuality is 1-100.
+	JPEGQuality     int
+	JPEGProgressive bool
+	// WebPQuality is 1-100 and ignored when WebPLossless is set.
+	WebPQuality  int
+	WebPLossless bool
+	// PNGCompression is one of default, none, fast or best.
+	PNGCompression string
+	// MaxBytes is the most an image may take on disk, 0 for no limit. Lossy images are encoded at the highest quality
+	// that fits, lossless ones can only be warned about.
+	MaxBytes int64
+	JPEGTran string
+	CWebP    string
+}
+
+// RegisterFlags adds the encoding options to flags.
+func (o *Options) RegisterFlags(flags *flag.FlagSet) {
+	flags.IntVar(&o.JPEGQuality, "jpeg-quality", 0, "quality (1-100) of jpg images, 0 keeps what realesrgan writes unless another encoding option is set")
+	flags.BoolVar(&o.JPEGProgressive, "jpeg-progressive", false, "write progressive jpg images, needs jpegtran")
+	flags.IntVar(&o.WebPQuality, "webp-quality", 0, "quality (1-100) of lossy webp images, needs cwebp")
+	flags.BoolVar(&o.WebPLossless, "webp-lossless", false, "write lossless webp images, needs cwebp")
+	flags.StringVar(&o.PNGCompression, "png-compression", "", "compression of png images: "+strings.Join(compressionLevels, ", "))
+	flags.Int64Var(&o.MaxBytes, "max-bytes", 0, "largest size in bytes of an upsized image, lossy images are encoded at the highest quality that fits, 0 for no limit")
+	flags.StringVar(&o.JPEGTran, "jpegtran", DefaultJPEGTran, "the jpegtran binary used for progressive jpgs")
+	flags.StringVar(&o.CWebP, "cwebp", DefaultCWebP, "the cwebp binary used for webp images")
+}
+
+// Enabled reports whether any option was set, otherwise images are kept as realesrgan wrote them.
+func (o Options) Enabled() bool {
+	return o.JPEGQuality != 0 || o.JPEGProgressive || o.WebPQuality != 0 || o.WebPLossless || o.PNGCompression != "" || o.MaxBytes != 0
+}
+
+// Validate checks the options and fills in the defaults.
+func (o *Options) Validate() error {
+
+	if o.JPEGQuality == 0 {
+		o.JPEGQuality = defaultQuality
+	}
+	if o.WebPQuality == 0 {
+		o.WebPQuality = defaultQuality
+	}
+	if o.JPEGQuality < 1 || o.JPEGQuality > 100 || o.WebPQuality < 1 || o.WebPQuality > 100 {
+		return errors.New("quality must be between 1 and 100")
+	}
+
+	if o.PNGCompression == "" {
+		o.PNGCompression = compressionLevels[0]
+	}
+	if _, err := pngLevel(o.PNGCompression); err != nil {
+		return err
+	}
+
+	if o.MaxBytes < 0 {
+		return errors.New("the byte budget cannot be negative")
+	}
+
+	if o.JPEGTran == "" {
+		o.JPEGTran = DefaultJPEGTran
+	}
+	if o.CWebP == "" {
+		o.CWebP = DefaultCWebP
+	}
+	return nil
+}
+
+// Settings is how an image was encoded.
+type Settings struct {
+	Format      string
+	Quality     int
+	Progressive bool
+	Lossless    bool
+	Compression string
+	Bytes       int64
+	// OverBudget is set when the image did not fit in MaxBytes even at the lowest quality.
+	OverBudget bool
+}
+
+// String is the short form kept in sidecars and the cache, e.g. "jpeg quality=85 progressive 4123456 bytes".
+func (s Settings) String() string {
+	var parts = []string{s.Format}
+	if s.Quality > 0 {
+		parts = append(parts, fmt.Sprintf("quality=%d", s.Quality))
+	}
+	if s.Compression != "" {
+		parts = append(parts, "compression="+s.Compression)
+	}
+	if s.Progressive {
+		parts = append(parts, "progressive")
+	}
+	if s.Lossless {
+		parts = append(parts, "lossless")
+	}
+	parts = append(parts, fmt.Sprintf("%d bytes", s.Bytes))
+	if s.OverBudget {
+		parts = append(parts, "over budget")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Encode writes input, a lossless image from realesrgan, to output in the format of its extension. Options must have
+// been validated.
+func (o Options) Encode(input, output string) (Settings, error) {
+
+	var file, err = os.Open(input)
+	if err != nil {
+		return Settings{}, fmt.Errorf("error opening upsized image: %s, err: %w", input, err)
+	}
+	img, _, err := image.Decode(file)
+	file.Close()
+	if err != nil {
+		return Settings{}, fmt.Errorf("error decoding upsized image: %s, err: %w", input, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(output), os.ModePerm); err != nil {
+		return Settings{}, fmt.Errorf("unable to create upsized directory: %w", err)
+	}
+
+	var enc encoder
+	switch ext := strings.ToLower(filepath.Ext(output)); ext {
+	case ".jpg", ".jpeg":
+		enc = jpegEncoder{img: img, progressive: o.JPEGProgressive, jpegtran: o.JPEGTran}
+	case ".webp":
+		enc = webpEncoder{input: input, lossless: o.WebPLossless, cwebp: o.CWebP}
+	case ".png":
+		var level, _ = pngLevel(o.PNGCompression)
+		enc = pngEncoder{img: img, level: level, name: o.PNGCompression}
+	default:
+		return Settings{}, fmt.Errorf("cannot encode %s images", ext)
+	}
+
+	var quality = o.JPEGQuality
+	if _, ok := enc.(webpEncoder); ok {
+		quality = o.WebPQuality
+	}
+
+	settings, err := o.encodeWithin(enc, quality, output)
+	if err != nil {
+		return Settings{}, err
+	}
+	if settings.OverBudget {
+		log.Warnf("%s is %d bytes, over the budget of %d", output, settings.Bytes, o.MaxBytes)
+	}
+	return settings, nil
+}
+
+// encoder writes an image at a quality, which lossless encoders ignore.
+type encoder interface {
+	encode(quality int, output string) (Settings, error)
+	lossy() bool
+}
+
+// encodeWithin encodes at quality, or at the highest quality under it that fits in MaxBytes. Candidates are written
+// next to output and the one that is kept is renamed over it.
+func (o Options) encodeWithin(enc encoder, quality int, output string) (Settings, error) {
+
+	var candidate = func(quality int) (string, Settings, error) {
+		var tmp = filepath.Join(filepath.Dir(output), fmt.Sprintf(".%s.q%d%s", filepath.Base(output), quality, filepath.Ext(output)))
+		var settings, err = enc.encode(quality, tmp)
+		if err != nil {
+			os.Remove(tmp)
+			return "", Settings{}, err
+		}
+
+		info, err := os.Stat(tmp)
+		if err != nil {
+			return "", Settings{}, fmt.Errorf("error stating encoded image: %s, err: %w", tmp, err)
+		}
+		settings.Bytes = info.Size()
+		settings.OverBudget = o.MaxBytes > 0 && settings.Bytes > o.MaxBytes
+		return tmp, settings, nil
+	}
+
+	var best, settings, err = candidate(quality)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	// the size does not always shrink with the quality, but close enough to binary search it
+	if settings.OverBudget && enc.lossy() {
+		var low, high = minQuality, quality - 1
+		for low <= high {
+			var mid = (low + high) / 2
+			var tmp, s, err = candidate(mid)
+			if err != nil {
+				os.Remove(best)
+				return Settings{}, err
+			}
+
+			if !s.OverBudget || settings.OverBudget && s.Bytes < settings.Bytes {
+				os.Remove(best)
+				best, settings = tmp, s
+			} else {
+				os.Remove(tmp)
+			}
+
+			if s.OverBudget {
+				high = mid - 1
+			} else {
+				low = mid + 1
+			}
+		}
+	}
+
+	if err := os.Rename(best, output); err != nil {
+		os.Remove(best)
+		return Settings{}, fmt.Errorf("error moving encoded image to %s: %w", output, err)
+	}
+	return settings, nil
+}
@@ -0,0 +1,97 @@
+package encode
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kmulvey/realesrgan-scheduler/testimages"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCWebP writes 1000 bytes per point of quality, or 50000 for lossless.
+const fakeCWebP = `#!/bin/sh
+size=50000
+while [ $# -gt 0 ]; do
+	case "$1" in
+		-q) size=$(($2 * 1000)) ;;
+		-o) out="$2" ;;
+	esac
+	shift
+done
+head -c $size /dev/zero > "$out"
+`
+
+// fakeJPEGTran copies the jpg it is given to -outfile.
+const fakeJPEGTran = `#!/bin/sh
+while [ $# -gt 0 ]; do
+	[ "$1" = "-outfile" ] && out="$2"
+	shift
+done
+cat > "$out"
+`
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var input = filepath.Join(dir, "upsized.png")
+	assert.NoError(t, os.WriteFile(input, testimages.FoxPNG, 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "cwebp"), []byte(fakeCWebP), 0700))       //nolint:gosec
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "jpegtran"), []byte(fakeJPEGTran), 0700)) //nolint:gosec
+
+	var opts = Options{JPEGQuality: 80, CWebP: filepath.Join(dir, "cwebp"), JPEGTran: filepath.Join(dir, "jpegtran")}
+	assert.True(t, opts.Enabled())
+	assert.NoError(t, opts.Validate())
+	assert.Equal(t, defaultQuality, opts.WebPQuality)
+
+	var full, err = opts.Encode(input, filepath.Join(dir, "out", "fox.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, Settings{Format: "jpeg", Quality: 80, Bytes: full.Bytes}, full)
+
+	data, err := os.ReadFile(filepath.Join(dir, "out", "fox.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), full.Bytes)
+	_, err = jpeg.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+
+	// the budget picks the best quality that fits
+	opts.MaxBytes = full.Bytes - 1
+	opts.JPEGProgressive = true
+	budget, err := opts.Encode(input, filepath.Join(dir, "fox.jpg"))
+	assert.NoError(t, err)
+	assert.Less(t, budget.Quality, 80)
+	assert.LessOrEqual(t, budget.Bytes, opts.MaxBytes)
+	assert.True(t, budget.Progressive)
+	assert.False(t, budget.OverBudget)
+	assert.Equal(t, fmt.Sprintf("jpeg quality=%d progressive %d bytes", budget.Quality, budget.Bytes), budget.String())
+
+	opts.MaxBytes = 45500
+	webp, err := opts.Encode(input, filepath.Join(dir, "fox.webp"))
+	assert.NoError(t, err)
+	assert.Equal(t, Settings{Format: "webp", Quality: 45, Bytes: 45000}, webp)
+
+	// lossless images cannot be made to fit
+	opts.WebPLossless = true
+	webp, err = opts.Encode(input, filepath.Join(dir, "fox.webp"))
+	assert.NoError(t, err)
+	assert.Equal(t, Settings{Format: "webp", Lossless: true, Bytes: 50000, OverBudget: true}, webp)
+
+	opts = Options{PNGCompression: "best"}
+	assert.NoError(t, opts.Validate())
+	png, err := opts.Encode(input, filepath.Join(dir, "fox.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, "best", png.Compression)
+
+	// nothing but the output is left behind
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 7)
+
+	assert.Error(t, (&Options{PNGCompression: "max"}).Validate())
+	assert.Error(t, (&Options{JPEGQuality: 101}).Validate())
+	assert.False(t, Options{}.Enabled())
+}
@@ -0,0 +1,114 @@
+package encode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+type jpegEncoder struct {
+	img         image.Image
+	progressive bool
+	jpegtran    string
+}
+
+func (e jpegEncoder) lossy() bool { return true }
+
+// encode writes a baseline jpg with the Go encoder, which jpegtran losslessly turns progressive when asked to.
+func (e jpegEncoder) encode(quality int, output string) (Settings, error) {
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, e.img, &jpeg.Options{Quality: quality}); err != nil {
+		return Settings{}, fmt.Errorf("error encoding jpg: %w", err)
+	}
+
+	var settings = Settings{Format: "jpeg", Quality: quality, Progressive: e.progressive}
+	if !e.progressive {
+		return settings, writeFile(output, buf.Bytes())
+	}
+
+	// the binary is set by the user, like the realesrgan binary
+	//nolint:gosec
+	var cmd = exec.Command(e.jpegtran, "-progressive", "-optimize", "-copy", "all", "-outfile", output)
+	cmd.Stdin = &buf
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return Settings{}, fmt.Errorf("error running %s: %w, output: %s", e.jpegtran, err, out)
+	}
+	return settings, nil
+}
+
+type webpEncoder struct {
+	// input is encoded straight from the file, cwebp reads png
+	input    string
+	lossless bool
+	cwebp    string
+}
+
+func (e webpEncoder) lossy() bool { return !e.lossless }
+
+func (e webpEncoder) encode(quality int, output string) (Settings, error) {
+
+	// -exact keeps the colors of transparent pixels, which matter if the alpha is edited later
+	var args = []string{"-quiet", "-mt", "-exact", "-metadata", "none"}
+	var settings = Settings{Format: "webp", Lossless: e.lossless}
+	if e.lossless {
+		args = append(args, "-lossless")
+	} else {
+		args = append(args, "-q", strconv.Itoa(quality))
+		settings.Quality = quality
+	}
+	args = append(args, e.input, "-o", output)
+
+	//nolint:gosec
+	var cmd = exec.Command(e.cwebp, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return Settings{}, fmt.Errorf("error running %s: %w, output: %s", e.cwebp, err, out)
+	}
+	return settings, nil
+}
+
+type pngEncoder struct {
+	img   image.Image
+	level png.CompressionLevel
+	name  string
+}
+
+func (e pngEncoder) lossy() bool { return false }
+
+func (e pngEncoder) encode(_ int, output string) (Settings, error) {
+
+	var buf bytes.Buffer
+	var encoder = png.Encoder{CompressionLevel: e.level}
+	if err := encoder.Encode(&buf, e.img); err != nil {
+		return Settings{}, fmt.Errorf("error encoding png: %w", err)
+	}
+	return Settings{Format: "png", Compression: e.name}, writeFile(output, buf.Bytes())
+}
+
+func pngLevel(name string) (png.CompressionLevel, error) {
+	switch name {
+	case "default":
+		return png.DefaultCompression, nil
+	case "none":
+		return png.NoCompression, nil
+	case "fast":
+		return png.BestSpeed, nil
+	case "best":
+		return png.BestCompression, nil
+	}
+	return 0, fmt.Errorf("unknown png compression: %s, must be one of %v", name, compressionLevels)
+}
+
+func writeFile(file string, data []byte) error {
+	// upsized images are as readable as the ones realesrgan writes
+	//nolint:gosec
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return fmt.Errorf("error writing file: %s, err: %w", file, err)
+	}
+	return nil
+}
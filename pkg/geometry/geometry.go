@@ -0,0 +1,124 @@
+package geometry
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Mode is how an image is made to match a target size.
+type Mode string
+
+const (
+	// Fit scales the image to fit inside the target, keeping its aspect ratio.
+	Fit Mode = "fit"
+	// Fill scales the image to cover the target, keeping its aspect ratio, so one side may be larger.
+	Fill Mode = "fill"
+	// Crop fills the target and cuts off what is left over, evenly from both sides, to match it exactly.
+	Crop Mode = "crop"
+)
+
+// Target is the size upsized images should end up, the zero value keeps whatever the model produces.
+type Target struct {
+	Mode   Mode
+	Width  int
+	Height int
+}
+
+// Parse reads a target like fit:3840x2160, fill:3840x2160, crop:3840x2160 or long:6000 for the long edge. An empty
+// string is no target.
+func Parse(target string) (Target, error) {
+
+	target = strings.ToLower(strings.TrimSpace(target))
+	if target == "" {
+		return Target{}, nil
+	}
+
+	var mode, size, found = strings.Cut(target, ":")
+	if !found {
+		return Target{}, fmt.Errorf("invalid target: %s, expected mode:WIDTHxHEIGHT or long:PIXELS", target)
+	}
+
+	if mode == "long" {
+		var edge, err = strconv.Atoi(size)
+		if err != nil || edge <= 0 {
+			return Target{}, fmt.Errorf("invalid long edge: %s", size)
+		}
+		return Target{Mode: Fit, Width: edge, Height: edge}, nil
+	}
+
+	switch Mode(mode) {
+	case Fit, Fill, Crop:
+	default:
+		return Target{}, fmt.Errorf("unknown target mode: %s, must be fit, fill, crop or long", mode)
+	}
+
+	var w, h, ok = strings.Cut(size, "x")
+	width, errW := strconv.Atoi(w)
+	height, errH := strconv.Atoi(h)
+	if !ok || errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return Target{}, fmt.Errorf("invalid target size: %s, expected WIDTHxHEIGHT", size)
+	}
+
+	return Target{Mode: Mode(mode), Width: width, Height: height}, nil
+}
+
+// Enabled reports whether there is a target.
+func (t Target) Enabled() bool {
+	return t.Mode != ""
+}
+
+func (t Target) String() string {
+	if !t.Enabled() {
+		return ""
+	}
+	return fmt.Sprintf("%s:%dx%d", t.Mode, t.Width, t.Height)
+}
+
+// Factor is how much an image of width by height has to be scaled by to reach the target.
+func (t Target) Factor(width, height int) float64 {
+	var x, y = float64(t.Width) / float64(width), float64(t.Height) / float64(height)
+	if t.Mode == Fit {
+		return math.Min(x, y)
+	}
+	return math.Max(x, y)
+}
+
+// Meets reports whether an image of width by height is already as big as the target, so upsizing it is pointless.
+func (t Target) Meets(width, height int) bool {
+	return t.Factor(width, height) <= 1
+}
+
+// Size is how big an image of width by height ends up.
+func (t Target) Size(width, height int) (int, int) {
+	if t.Mode == Crop {
+		return t.Width, t.Height
+	}
+	var factor = t.Factor(width, height)
+	return max(1, int(math.Round(float64(width)*factor))), max(1, int(math.Round(float64(height)*factor)))
+}
+
+// Resample scales img to the target with Catmull-Rom, which is sharp without ringing much when scaling down.
+func (t Target) Resample(img image.Image) image.Image {
+
+	var bounds = img.Bounds()
+	var width, height = t.Size(bounds.Dx(), bounds.Dy())
+
+	var src = bounds
+	if t.Mode == Crop {
+		// the part of img that has the aspect ratio of the target, from the middle
+		var factor = t.Factor(bounds.Dx(), bounds.Dy())
+		var w = min(bounds.Dx(), int(math.Round(float64(width)/factor)))
+		var h = min(bounds.Dy(), int(math.Round(float64(height)/factor)))
+		var origin = bounds.Min.Add(image.Pt((bounds.Dx()-w)/2, (bounds.Dy()-h)/2))
+		src = image.Rectangle{Min: origin, Max: origin.Add(image.Pt(w, h))}
+	}
+
+	var dst = image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,79 @@
+package geometry
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	var cases = map[string]Target{
+		"":               {},
+		"fit:3840x2160":  {Mode: Fit, Width: 3840, Height: 2160},
+		"FILL:100x50":    {Mode: Fill, Width: 100, Height: 50},
+		" crop:640x480 ": {Mode: Crop, Width: 640, Height: 480},
+		"long:6000":      {Mode: Fit, Width: 6000, Height: 6000},
+	}
+	for input, expected := range cases {
+		var target, err = Parse(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, target, input)
+	}
+
+	for _, input := range []string{"3840x2160", "zoom:100x100", "fit:100", "fit:0x10", "long:-1", "crop:axb"} {
+		var _, err = Parse(input)
+		assert.Error(t, err, input)
+	}
+
+	assert.Equal(t, "fit:3840x2160", Target{Mode: Fit, Width: 3840, Height: 2160}.String())
+	assert.False(t, Target{}.Enabled())
+}
+
+func TestSize(t *testing.T) {
+	t.Parallel()
+
+	var fit = Target{Mode: Fit, Width: 3840, Height: 2160}
+	assert.InDelta(t, 2.7, fit.Factor(1204, 800), 0.01)
+	w, h := fit.Size(1204, 800)
+	assert.Equal(t, 3251, w)
+	assert.Equal(t, 2160, h)
+	assert.False(t, fit.Meets(1204, 800))
+	assert.True(t, fit.Meets(4000, 2000))
+
+	var fill = Target{Mode: Fill, Width: 3840, Height: 2160}
+	w, h = fill.Size(1204, 800)
+	assert.Equal(t, 3840, w)
+	assert.Equal(t, 2551, h)
+	assert.False(t, fill.Meets(4000, 2000))
+
+	var crop = Target{Mode: Crop, Width: 3840, Height: 2160}
+	w, h = crop.Size(1204, 800)
+	assert.Equal(t, 3840, w)
+	assert.Equal(t, 2160, h)
+}
+
+func TestResample(t *testing.T) {
+	t.Parallel()
+
+	// a wide image, red on the left third, green in the middle and blue on the right
+	var img = image.NewNRGBA(image.Rect(0, 0, 90, 30))
+	for x := range 90 {
+		var c = []color.NRGBA{{R: 0xff, A: 0xff}, {G: 0xff, A: 0xff}, {B: 0xff, A: 0xff}}[x/30]
+		for y := range 30 {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	var fit = Target{Mode: Fit, Width: 30, Height: 30}.Resample(img)
+	assert.Equal(t, image.Rect(0, 0, 30, 10), fit.Bounds())
+
+	// cropping to a square keeps the middle
+	var crop = Target{Mode: Crop, Width: 20, Height: 20}.Resample(img)
+	assert.Equal(t, image.Rect(0, 0, 20, 20), crop.Bounds())
+	assert.Equal(t, color.NRGBA{G: 0xff, A: 0xff}, crop.At(0, 0))
+	assert.Equal(t, color.NRGBA{G: 0xff, A: 0xff}, crop.At(19, 19))
+}
@@ -0,0 +1,63 @@
+package imagetype
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+)
+
+// hasAlphaChannel reports whether images in model can have transparent pixels at all.
+func hasAlphaChannel(model color.Model) bool {
+	switch model {
+	case color.RGBAModel, color.RGBA64Model, color.NRGBAModel, color.NRGBA64Model, color.AlphaModel, color.Alpha16Model, color.NYCbCrAModel:
+		return true
+	}
+
+	if palette, ok := model.(color.Palette); ok {
+		for _, c := range palette {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasTransparency reports whether any pixel of file is not fully opaque. Only images whose header says they have an
+// alpha channel are decoded, the rest are known to be opaque.
+func HasTransparency(file string) (bool, error) {
+
+	var info, err = Sniff(file)
+	if err != nil {
+		return false, err
+	}
+	if !info.Alpha {
+		return false, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return false, fmt.Errorf("error opening image: %s, err: %w", file, err)
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return false, fmt.Errorf("error decoding image: %s, err: %w", file, err)
+	}
+
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return !opaque.Opaque(), nil
+	}
+
+	var bounds = img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,139 @@
+package imagetype
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// heifHeaderLen is how much of a HEIF file is read for its size, the meta box with the item properties comes right
+// after ftyp and is rarely more than a few KB.
+const heifHeaderLen = 64 << 10
+
+var (
+	avifBrands = [][]byte{[]byte("avif"), []byte("avis")}
+	heicBrands = [][]byte{[]byte("heic"), []byte("heix"), []byte("heim"), []byte("heis"), []byte("hevc"), []byte("hevx")}
+)
+
+// heifFormat reads the brands in the ftyp box, the major brand is often the generic mif1 so the compatible brands
+// are checked too.
+func heifFormat(head []byte) Format {
+	if len(head) < 16 || !bytes.Equal(head[4:8], []byte("ftyp")) {
+		return ""
+	}
+
+	var size = int(binary.BigEndian.Uint32(head[:4]))
+	if size < 16 || size > len(head) {
+		size = len(head)
+	}
+
+	// major brand, minor version, then the compatible brands
+	var brands = [][]byte{head[8:12]}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, head[i:i+4])
+	}
+
+	for _, brand := range brands {
+		if hasBrand(avifBrands, brand) {
+			return AVIF
+		}
+	}
+	for _, brand := range brands {
+		if hasBrand(heicBrands, brand) {
+			return HEIC
+		}
+	}
+	return ""
+}
+
+func hasBrand(brands [][]byte, brand []byte) bool {
+	for _, b := range brands {
+		if bytes.Equal(b, brand) {
+			return true
+		}
+	}
+	return false
+}
+
+// heifSize returns the size of the largest image in the meta/iprp/ipco boxes, smaller ones are thumbnails or tiles.
+func heifSize(data []byte) (int, int, error) {
+
+	var meta, ok = findBox(data, "meta")
+	if !ok || len(meta) < 4 {
+		return 0, 0, errors.New("no meta box")
+	}
+
+	// meta is a full box, skip its version and flags
+	iprp, ok := findBox(meta[4:], "iprp")
+	if !ok {
+		return 0, 0, errors.New("no item properties")
+	}
+	ipco, ok := findBox(iprp, "ipco")
+	if !ok {
+		return 0, 0, errors.New("no item properties")
+	}
+
+	var width, height int
+	for box := ipco; len(box) >= 8; {
+		var name, body, rest, ok = nextBox(box)
+		if !ok {
+			break
+		}
+		if name == "ispe" && len(body) >= 12 {
+			var w = int(binary.BigEndian.Uint32(body[4:8]))
+			var h = int(binary.BigEndian.Uint32(body[8:12]))
+			if w*h > width*height {
+				width, height = w, h
+			}
+		}
+		box = rest
+	}
+
+	if width == 0 || height == 0 {
+		return 0, 0, errors.New("no image size")
+	}
+	return width, height, nil
+}
+
+// findBox returns the body of the first box called name in data.
+func findBox(data []byte, name string) ([]byte, bool) {
+	for len(data) >= 8 {
+		var boxName, body, rest, ok = nextBox(data)
+		if !ok {
+			return nil, false
+		}
+		if boxName == name {
+			return body, true
+		}
+		data = rest
+	}
+	return nil, false
+}
+
+// nextBox splits the first ISO BMFF box off of data. A box that runs past the end of data is cut short, which only
+// happens to the last box read and is fine for the headers we need.
+func nextBox(data []byte) (string, []byte, []byte, bool) {
+	var size = int(binary.BigEndian.Uint32(data[:4]))
+	var name = string(data[4:8])
+	var header = 8
+
+	switch size {
+	case 0:
+		size = len(data)
+	case 1:
+		if len(data) < 16 {
+			return "", nil, nil, false
+		}
+		size = int(binary.BigEndian.Uint64(data[8:16]))
+		header = 16
+	}
+
+	if size < header {
+		return "", nil, nil, false
+	}
+	if size > len(data) {
+		size = len(data)
+	}
+
+	return name, data[header:size], data[size:], true
+}
@@ -0,0 +1,138 @@
+package imagetype
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"  // register decoder
+	_ "image/jpeg" // register decoder
+	_ "image/png"  // register decoder
+	"io"
+	"os"
+
+	_ "golang.org/x/image/bmp"  // register decoder
+	_ "golang.org/x/image/tiff" // register decoder
+	_ "golang.org/x/image/webp" // register decoder
+)
+
+// Format is the kind of image a file holds, as found by its content not its name.
+type Format string
+
+const (
+	JPEG Format = "jpeg"
+	PNG  Format = "png"
+	WebP Format = "webp"
+	GIF  Format = "gif"
+	BMP  Format = "bmp"
+	TIFF Format = "tiff"
+	AVIF Format = "avif"
+	HEIC Format = "heic"
+)
+
+// ErrNotImage is returned for files that are not in any supported image format.
+var ErrNotImage = errors.New("not a supported image")
+
+// Info is what sniffing found out about an image.
+type Info struct {
+	Format Format
+	Width  int
+	Height int
+	// Depth is the bits per channel, 16 for 16 bit PNGs and TIFFs and 8 for everything else.
+	Depth int
+	// Alpha is set when the image has an alpha channel, see HasTransparency for whether it is used. It is never set
+	// for AVIF and HEIC, whose headers are not decoded.
+	Alpha bool
+}
+
+// NeedsConversion reports whether realesrgan cannot read the image as it is, it only reads 8 bit jpeg, png and webp.
+func (i Info) NeedsConversion() bool {
+	switch i.Format {
+	case JPEG, WebP:
+		return false
+	case PNG:
+		return i.Depth > 8
+	}
+	return true
+}
+
+// sniffLen is how much of the file is read to find the format, enough for the header of every format but HEIF.
+const sniffLen = 64
+
+// Sniff finds the format of file from its magic bytes and makes sure its header can be decoded. Files that are not
+// images, or are too damaged to read, return an error wrapping ErrNotImage.
+func Sniff(file string) (Info, error) {
+
+	var f, err = os.Open(file)
+	if err != nil {
+		return Info{}, fmt.Errorf("error opening image: %s, err: %w", file, err)
+	}
+	defer f.Close()
+
+	info, err := sniff(bufio.NewReaderSize(f, heifHeaderLen))
+	if err != nil {
+		return Info{}, fmt.Errorf("%s: %w", file, err)
+	}
+	return info, nil
+}
+
+func sniff(r *bufio.Reader) (Info, error) {
+
+	var head, err = r.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Info{}, fmt.Errorf("error reading header: %w", err)
+	}
+
+	var format = formatOf(head)
+	switch format {
+	case "":
+		return Info{}, ErrNotImage
+
+	case AVIF, HEIC:
+		// the stdlib has no HEIF decoder so read the size from the container instead
+		head, err = r.Peek(heifHeaderLen)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return Info{}, fmt.Errorf("error reading header: %w", err)
+		}
+		width, height, err := heifSize(head)
+		if err != nil {
+			return Info{}, fmt.Errorf("%w: %s", ErrNotImage, err)
+		}
+		return Info{Format: format, Width: width, Height: height, Depth: 8}, nil
+	}
+
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return Info{}, fmt.Errorf("%w: error decoding %s header: %s", ErrNotImage, format, err)
+	}
+
+	return Info{Format: format, Width: config.Width, Height: config.Height, Depth: depth(config), Alpha: hasAlphaChannel(config.ColorModel)}, nil
+}
+
+func formatOf(head []byte) Format {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xff, 0xd8, 0xff}):
+		return JPEG
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return WebP
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return GIF
+	case bytes.HasPrefix(head, []byte("BM")):
+		return BMP
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return TIFF
+	}
+	return heifFormat(head)
+}
+
+func depth(config image.Config) int {
+	switch config.ColorModel {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		return 16
+	}
+	return 8
+}
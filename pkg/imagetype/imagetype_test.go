@@ -0,0 +1,96 @@
+package imagetype
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kmulvey/realesrgan-scheduler/testimages"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSniff(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+
+	var png16 bytes.Buffer
+	var img = image.NewRGBA64(image.Rect(0, 0, 3, 2))
+	img.Set(1, 1, color.RGBA64{R: 0xffff, A: 0xffff})
+	assert.NoError(t, png.Encode(&png16, img))
+
+	var cases = []struct {
+		name            string
+		data            []byte
+		info            Info
+		needsConversion bool
+	}{
+		// the names do not match the content on purpose
+		{"fox.png", testimages.FoxJPG, Info{Format: JPEG, Width: 1204, Height: 800, Depth: 8}, false},
+		{"fox.Jpg", testimages.FoxPNG, Info{Format: PNG, Width: 1204, Height: 800, Depth: 8, Alpha: true}, false},
+		{"fox", testimages.FoxAVIF, Info{Format: AVIF, Width: 1204, Height: 800, Depth: 8}, true},
+		{"deep.png", png16.Bytes(), Info{Format: PNG, Width: 3, Height: 2, Depth: 16, Alpha: true}, true},
+	}
+
+	for _, c := range cases {
+		var file = filepath.Join(dir, c.name)
+		assert.NoError(t, os.WriteFile(file, c.data, 0600))
+
+		var info, err = Sniff(file)
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.info, info, c.name)
+		assert.Equal(t, c.needsConversion, info.NeedsConversion(), c.name)
+	}
+
+	for name, data := range map[string][]byte{
+		"not_an_image.jpg": testimages.NotAnImage,
+		"empty.jpg":        nil,
+		"truncated.jpg":    testimages.FoxJPG[:3],
+		"broken.avif":      testimages.FoxAVIF[:32],
+	} {
+		var file = filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(file, data, 0600))
+
+		var _, err = Sniff(file)
+		assert.True(t, errors.Is(err, ErrNotImage), name)
+	}
+}
+
+func TestHasTransparency(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var img = image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	var opaque, transparent bytes.Buffer
+	assert.NoError(t, png.Encode(&opaque, img))
+	img.SetNRGBA(2, 1, color.NRGBA{A: 0x10})
+	assert.NoError(t, png.Encode(&transparent, img))
+
+	var files = map[string]struct {
+		data        []byte
+		transparent bool
+	}{
+		"fox.jpg":         {testimages.FoxJPG, false},
+		"fox.png":         {testimages.FoxPNG, false},
+		"opaque.png":      {opaque.Bytes(), false},
+		"transparent.png": {transparent.Bytes(), true},
+	}
+
+	for name, f := range files {
+		var file = filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(file, f.data, 0600))
+
+		var transparent, err = HasTransparency(file)
+		assert.NoError(t, err, name)
+		assert.Equal(t, f.transparent, transparent, name)
+	}
+}
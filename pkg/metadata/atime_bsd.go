@@ -0,0 +1,16 @@
+//go:build darwin || freebsd
+
+package metadata
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func accessTimeOf(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atimespec.Unix())
+	}
+	return info.ModTime()
+}
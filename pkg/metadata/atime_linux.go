@@ -0,0 +1,14 @@
+package metadata
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func accessTimeOf(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atim.Unix())
+	}
+	return info.ModTime()
+}
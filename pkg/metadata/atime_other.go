@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package metadata
+
+import (
+	"os"
+	"time"
+)
+
+// accessTimeOf falls back to the modification time where the access time cannot be read.
+func accessTimeOf(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
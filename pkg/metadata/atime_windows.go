@@ -0,0 +1,14 @@
+package metadata
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func accessTimeOf(info os.FileInfo) time.Time {
+	if attributes, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, attributes.LastAccessTime.Nanoseconds())
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,83 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// exifHeader starts the APP1 segment that holds EXIF in a JPEG, WebP files sometimes have it too.
+var exifHeader = []byte("Exif\x00\x00")
+
+const orientationTag = 0x0112
+
+// ReadEXIF returns the raw EXIF of an image, which is a little TIFF file. A file without EXIF returns nil and no error.
+func ReadEXIF(file string) ([]byte, error) {
+	var md, err = Read(file)
+	return md.EXIF, err
+}
+
+// Orientation returns the EXIF orientation, 1 to 8, or 1 if there is none or it cannot be read.
+func Orientation(exif []byte) int {
+
+	var order, ifd, err = tiffHeader(exif)
+	if err != nil {
+		return 1
+	}
+
+	var entry, found = findTag(exif, order, ifd, orientationTag)
+	if !found {
+		return 1
+	}
+
+	var orientation = int(order.Uint16(exif[entry+8 : entry+10]))
+	if orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// tiffHeader returns the byte order and the offset of the first IFD.
+func tiffHeader(exif []byte) (binary.ByteOrder, int, error) {
+
+	if len(exif) < 8 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	var order binary.ByteOrder
+	switch string(exif[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, errors.New("not a tiff header")
+	}
+
+	if order.Uint16(exif[2:4]) != 42 {
+		return nil, 0, errors.New("not a tiff header")
+	}
+
+	return order, int(order.Uint32(exif[4:8])), nil
+}
+
+// findTag returns the offset of the 12 byte IFD entry for tag.
+func findTag(exif []byte, order binary.ByteOrder, ifd int, tag uint16) (int, bool) {
+
+	if ifd < 8 || ifd+2 > len(exif) {
+		return 0, false
+	}
+
+	var count = int(order.Uint16(exif[ifd : ifd+2]))
+	for i := range count {
+		var entry = ifd + 2 + i*12
+		if entry+12 > len(exif) {
+			return 0, false
+		}
+		if order.Uint16(exif[entry:entry+2]) == tag {
+			return entry, true
+		}
+	}
+
+	return 0, false
+}
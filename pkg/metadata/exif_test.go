@@ -0,0 +1,68 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kmulvey/realesrgan-scheduler/testimages"
+	"github.com/stretchr/testify/assert"
+)
+
+// testEXIF builds a minimal EXIF block with one IFD holding the orientation.
+func testEXIF(order binary.ByteOrder, orientation uint16) []byte {
+	var exif = make([]byte, 26)
+	if order == binary.LittleEndian {
+		copy(exif, "II")
+	} else {
+		copy(exif, "MM")
+	}
+	order.PutUint16(exif[2:], 42)
+	order.PutUint32(exif[4:], 8)
+	order.PutUint16(exif[8:], 1)               // one entry
+	order.PutUint16(exif[10:], orientationTag) // tag
+	order.PutUint16(exif[12:], 3)              // SHORT
+	order.PutUint32(exif[14:], 1)              // count
+	order.PutUint16(exif[18:], orientation)
+	return exif
+}
+
+// withJPEGEXIF puts exif in an APP1 segment right after the start of image marker.
+func withJPEGEXIF(jpg, exif []byte) []byte {
+	var segment = append([]byte{0xff, 0xe1, 0, 0}, append(append([]byte{}, exifHeader...), exif...)...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+	return append(append(append([]byte{}, jpg[:2]...), segment...), jpg[2:]...)
+}
+
+func TestReadEXIF(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+
+	var webp = []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	var exif = testEXIF(binary.BigEndian, 8)
+	webp = append(webp, "EXIF"...)
+	webp = binary.LittleEndian.AppendUint32(webp, uint32(len(exif)))
+	webp = append(webp, exif...)
+
+	var cases = map[string]struct {
+		data        []byte
+		orientation int
+	}{
+		"plain.jpg":    {testimages.FoxJPG, 1},
+		"rotated.jpg":  {withJPEGEXIF(testimages.FoxJPG, testEXIF(binary.LittleEndian, 6)), 6},
+		"bad.jpg":      {withJPEGEXIF(testimages.FoxJPG, testEXIF(binary.LittleEndian, 42)), 1},
+		"rotated.webp": {webp, 8},
+		"fox.png":      {testimages.FoxPNG, 1},
+	}
+
+	for name, c := range cases {
+		var file = filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(file, c.data, 0600))
+
+		var exif, err = ReadEXIF(file)
+		assert.NoError(t, err, name)
+		assert.Equal(t, c.orientation, Orientation(exif), name)
+	}
+}
@@ -0,0 +1,172 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+var (
+	xmpHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccHeader = []byte("ICC_PROFILE\x00")
+)
+
+const (
+	app0 = 0xe0
+	app1 = 0xe1
+	app2 = 0xe2
+
+	// maxSegment is the most a segment can hold after its 2 byte length.
+	maxSegment = 0xffff - 2
+)
+
+type jpegSegment struct {
+	marker byte
+	body   []byte
+	// start and end are where the whole segment, marker and all, is in the file.
+	start, end int
+}
+
+// jpegSegments returns the segments before the image data and the offset the rest of the file starts at.
+func jpegSegments(data []byte) ([]jpegSegment, int) {
+
+	var segments []jpegSegment
+	var i = 2
+	for i+4 <= len(data) {
+		if data[i] != 0xff {
+			break
+		}
+
+		var marker = data[i+1]
+		if marker == 0xff { // fill byte
+			i++
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 { // start of scan, end of image
+			break
+		}
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) { // no length
+			segments = append(segments, jpegSegment{marker: marker, start: i, end: i + 2})
+			i += 2
+			continue
+		}
+
+		var length = int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			break
+		}
+		segments = append(segments, jpegSegment{marker: marker, body: data[i+4 : i+2+length], start: i, end: i + 2 + length})
+		i += 2 + length
+	}
+
+	return segments, i
+}
+
+func (s jpegSegment) isEXIF() bool {
+	return s.marker == app1 && bytes.HasPrefix(s.body, exifHeader)
+}
+
+func (s jpegSegment) isXMP() bool {
+	return s.marker == app1 && bytes.HasPrefix(s.body, xmpHeader)
+}
+
+// isICC is true for a piece of an ICC profile, big profiles are split over several segments.
+func (s jpegSegment) isICC() bool {
+	return s.marker == app2 && len(s.body) >= len(iccHeader)+2 && bytes.HasPrefix(s.body, iccHeader)
+}
+
+func readJPEG(data []byte) (Metadata, error) {
+
+	var md Metadata
+	var segments, _ = jpegSegments(data)
+
+	type iccChunk struct {
+		seq  byte
+		data []byte
+	}
+	var chunks []iccChunk
+
+	for _, segment := range segments {
+		switch {
+		case segment.isEXIF() && md.EXIF == nil:
+			md.EXIF = segment.body
+		case segment.isXMP() && md.XMP == nil:
+			md.XMP = segment.body[len(xmpHeader):]
+		case segment.isICC():
+			chunks = append(chunks, iccChunk{seq: segment.body[len(iccHeader)], data: segment.body[len(iccHeader)+2:]})
+		}
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].seq < chunks[j].seq })
+	for _, chunk := range chunks {
+		md.ICC = append(md.ICC, chunk.data...)
+	}
+
+	return md, nil
+}
+
+// writeJPEG puts md right after the JFIF header, replacing any metadata already there.
+func writeJPEG(data []byte, md Metadata) ([]byte, error) {
+
+	var segments, rest = jpegSegments(data)
+
+	var out = bytes.NewBuffer(make([]byte, 0, len(data)+len(md.EXIF)+len(md.XMP)+len(md.ICC)+1024))
+	out.Write(data[:2])
+
+	// APP0 has to come first
+	var i = 0
+	for ; i < len(segments) && segments[i].marker == app0; i++ {
+		out.Write(data[segments[i].start:segments[i].end])
+	}
+
+	if len(md.EXIF) > 0 {
+		if err := writeSegment(out, app1, exifHeader, md.EXIF); err != nil {
+			return nil, err
+		}
+	}
+	if len(md.XMP) > 0 {
+		if err := writeSegment(out, app1, xmpHeader, md.XMP); err != nil {
+			return nil, err
+		}
+	}
+	if len(md.ICC) > 0 {
+		var chunkLen = maxSegment - len(iccHeader) - 2
+		var count = (len(md.ICC) + chunkLen - 1) / chunkLen
+		if count > 255 {
+			return nil, errors.New("icc profile is too big for a jpeg")
+		}
+		for n := range count {
+			var chunk = md.ICC[n*chunkLen : min((n+1)*chunkLen, len(md.ICC))]
+			var header = append(append([]byte{}, iccHeader...), byte(n+1), byte(count))
+			if err := writeSegment(out, app2, header, chunk); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, segment := range segments[i:] {
+		if segment.isEXIF() || segment.isXMP() || segment.isICC() {
+			continue
+		}
+		out.Write(data[segment.start:segment.end])
+	}
+	out.Write(data[rest:])
+
+	return out.Bytes(), nil
+}
+
+func writeSegment(out *bytes.Buffer, marker byte, header, body []byte) error {
+
+	var length = len(header) + len(body)
+	if length > maxSegment {
+		return errors.New("metadata is too big for a jpeg segment")
+	}
+
+	out.Write([]byte{0xff, marker})
+	out.Write(binary.BigEndian.AppendUint16(nil, uint16(length+2)))
+	out.Write(header)
+	out.Write(body)
+
+	return nil
+}
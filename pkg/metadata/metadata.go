@@ -0,0 +1,151 @@
+package metadata
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Metadata is what is carried over from an image to its upsized version.
+type Metadata struct {
+	// EXIF is a little TIFF file, without the "Exif\0\0" header JPEGs put in front of it.
+	EXIF []byte
+	XMP  []byte
+	ICC  []byte
+}
+
+// Empty reports whether there is nothing to write.
+func (m Metadata) Empty() bool {
+	return len(m.EXIF) == 0 && len(m.XMP) == 0 && len(m.ICC) == 0
+}
+
+// Read returns the metadata of a JPEG, PNG or WebP file, other formats have none.
+func Read(file string) (Metadata, error) {
+
+	var data, err = os.ReadFile(file)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("error reading image: %s, err: %w", file, err)
+	}
+
+	var md Metadata
+	switch formatOf(data) {
+	case "jpeg":
+		md, err = readJPEG(data)
+	case "png":
+		md, err = readPNG(data)
+	case "webp":
+		md, err = readWebP(data)
+	}
+	if err != nil {
+		return Metadata{}, fmt.Errorf("error reading metadata from %s: %w", file, err)
+	}
+
+	md.EXIF = bytes.TrimPrefix(md.EXIF, exifHeader)
+	return md, nil
+}
+
+// Write replaces the metadata of a JPEG, PNG or WebP file with md. Fields of md that are empty are left out.
+func Write(file string, md Metadata) error {
+
+	var info, err = os.Stat(file)
+	if err != nil {
+		return fmt.Errorf("error stating image: %s, err: %w", file, err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading image: %s, err: %w", file, err)
+	}
+
+	switch formatOf(data) {
+	case "jpeg":
+		data, err = writeJPEG(data, md)
+	case "png":
+		data, err = writePNG(data, md)
+	case "webp":
+		data, err = writeWebP(data, md)
+	default:
+		return fmt.Errorf("cannot write metadata to %s, only jpeg, png and webp are supported", file)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing metadata to %s: %w", file, err)
+	}
+
+	// write next to it and rename so a failure never leaves a half written image
+	var tmp = file + ".metadata"
+	if err := os.WriteFile(tmp, data, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("error writing image: %s, err: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error replacing image: %s, err: %w", file, err)
+	}
+
+	return nil
+}
+
+func formatOf(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return "jpeg"
+	case bytes.HasPrefix(data, pngSignature):
+		return "png"
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return "webp"
+	}
+	return ""
+}
+
+// Fields are the parts of an image that are copied to its upsized version.
+type Fields struct {
+	EXIF       bool
+	XMP        bool
+	ICC        bool
+	ModTime    bool
+	AccessTime bool
+}
+
+// AllFields copies everything.
+var AllFields = Fields{EXIF: true, XMP: true, ICC: true, ModTime: true, AccessTime: true}
+
+// ParseFields reads a comma separated list of exif, xmp, icc, mtime and atime, or all or none.
+func ParseFields(list string) (Fields, error) {
+
+	var fields Fields
+	for _, field := range strings.Split(list, ",") {
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "", "none":
+		case "all":
+			fields = AllFields
+		case "exif":
+			fields.EXIF = true
+		case "xmp":
+			fields.XMP = true
+		case "icc":
+			fields.ICC = true
+		case "mtime":
+			fields.ModTime = true
+		case "atime":
+			fields.AccessTime = true
+		default:
+			return Fields{}, fmt.Errorf("unknown metadata field: %s, must be exif, xmp, icc, mtime, atime, all or none", field)
+		}
+	}
+
+	return fields, nil
+}
+
+// Select returns only the parts of md that fields includes.
+func (f Fields) Select(md Metadata) Metadata {
+	if !f.EXIF {
+		md.EXIF = nil
+	}
+	if !f.XMP {
+		md.XMP = nil
+	}
+	if !f.ICC {
+		md.ICC = nil
+	}
+	return md
+}
@@ -0,0 +1,115 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kmulvey/realesrgan-scheduler/testimages"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRead(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+
+	// a lossless webp header for a 300x200 image with alpha, the pixels do not matter here
+	var vp8l = []byte{0x2f, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(vp8l[1:], 299|199<<14|1<<28)
+	var webp = []byte("RIFF\x00\x00\x00\x00WEBPVP8L\x05\x00\x00\x00")
+	webp = append(append(webp, vp8l...), 0)
+
+	var md = Metadata{
+		EXIF: testEXIF(binary.BigEndian, 6),
+		XMP:  []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:Description exif:PixelXDimension="1204"/></x:xmpmeta>`),
+		// big enough to be split over several jpeg segments
+		ICC: bytes.Repeat([]byte("icc profile "), 10000),
+	}
+
+	for name, data := range map[string][]byte{"fox.jpg": testimages.FoxJPG, "fox.png": testimages.FoxPNG, "fox.webp": webp} {
+		var file = filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(file, data, 0600))
+
+		assert.NoError(t, Write(file, md), name)
+		var written, err = Read(file)
+		assert.NoError(t, err, name)
+		assert.Equal(t, md, written, name)
+
+		// writing again replaces rather than adds
+		assert.NoError(t, Write(file, Metadata{XMP: md.XMP}), name)
+		written, err = Read(file)
+		assert.NoError(t, err, name)
+		assert.Equal(t, Metadata{XMP: md.XMP}, written, name)
+
+		if name != "fox.webp" {
+			var f, err = os.Open(file)
+			assert.NoError(t, err)
+			img, _, err := image.Decode(f)
+			assert.NoError(t, err, name)
+			assert.NoError(t, f.Close())
+			if img != nil {
+				assert.Equal(t, image.Pt(1204, 800), img.Bounds().Size(), name)
+			}
+		}
+	}
+
+	// the simple webp was turned into an extended one with the canvas size and alpha kept
+	var data, err = os.ReadFile(filepath.Join(dir, "fox.webp"))
+	assert.NoError(t, err)
+	assert.Equal(t, "VP8X", string(data[12:16]))
+	assert.Equal(t, byte(webpAlphaFlag|webpXMPFlag), data[20])
+	assert.Equal(t, []byte{43, 1, 0, 199, 0, 0}, data[24:30])
+	assert.Equal(t, uint32(len(data)-8), binary.LittleEndian.Uint32(data[4:8]))
+}
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+
+	var exif = UpdateEXIF(testEXIF(binary.LittleEndian, 6), 800, 1204, true)
+	assert.Equal(t, 1, Orientation(exif))
+	assert.Equal(t, 6, Orientation(UpdateEXIF(testEXIF(binary.LittleEndian, 6), 800, 1204, false)))
+
+	var xmp = UpdateXMP([]byte(`<rdf:Description exif:PixelXDimension="1204" exif:PixelYDimension='800' tiff:Orientation="6"><tiff:ImageWidth>1204</tiff:ImageWidth></rdf:Description>`), 3200, 4816, true)
+	assert.Equal(t, `<rdf:Description exif:PixelXDimension="3200" exif:PixelYDimension='4816' tiff:Orientation="1"><tiff:ImageWidth>3200</tiff:ImageWidth></rdf:Description>`, string(xmp))
+}
+
+func TestParseFields(t *testing.T) {
+	t.Parallel()
+
+	var fields, err = ParseFields("exif, ICC,mtime")
+	assert.NoError(t, err)
+	assert.Equal(t, Fields{EXIF: true, ICC: true, ModTime: true}, fields)
+	assert.Equal(t, Metadata{EXIF: []byte("e"), ICC: []byte("i")}, fields.Select(Metadata{EXIF: []byte("e"), XMP: []byte("x"), ICC: []byte("i")}))
+
+	fields, err = ParseFields("all")
+	assert.NoError(t, err)
+	assert.Equal(t, AllFields, fields)
+
+	_, err = ParseFields("exif,gps")
+	assert.Error(t, err)
+}
+
+func TestCopyTimes(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var src, dst = filepath.Join(dir, "src.jpg"), filepath.Join(dir, "dst.jpg")
+	assert.NoError(t, os.WriteFile(src, nil, 0600))
+	assert.NoError(t, os.WriteFile(dst, nil, 0600))
+
+	var taken = time.Date(2019, 7, 4, 12, 30, 0, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(src, taken.Add(time.Hour), taken))
+
+	assert.NoError(t, CopyTimes(src, dst, true, true))
+	var info, err = os.Stat(dst)
+	assert.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(taken))
+	assert.True(t, accessTimeOf(info).Equal(taken.Add(time.Hour)))
+}
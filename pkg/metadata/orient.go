@@ -0,0 +1,51 @@
+package metadata
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Orient returns img turned the way EXIF orientation says it should be shown, so it looks right without the tag.
+func Orient(img image.Image, orientation int) image.Image {
+
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	var src = image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	var w, h = src.Bounds().Dx(), src.Bounds().Dy()
+	var dst *image.NRGBA
+	if orientation >= 5 {
+		// 5 to 8 are turned on their side
+		dst = image.NewNRGBA(image.Rect(0, 0, h, w))
+	} else {
+		dst = image.NewNRGBA(image.Rect(0, 0, w, h))
+	}
+
+	for y := range h {
+		for x := range w {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // upside down and mirrored
+				dx, dy = x, h-1-y
+			case 5: // mirrored and turned left
+				dx, dy = y, x
+			case 6: // turned left, so rotate clockwise
+				dx, dy = h-1-y, x
+			case 7: // mirrored and turned right
+				dx, dy = h-1-y, w-1-x
+			case 8: // turned right, so rotate counter clockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+
+	return dst
+}
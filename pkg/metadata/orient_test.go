@@ -0,0 +1,40 @@
+package metadata
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrient(t *testing.T) {
+	t.Parallel()
+
+	// a 3x2 image with a marked top left corner
+	var img = image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	var red = color.NRGBA{R: 255, A: 255}
+	img.Set(0, 0, red)
+
+	// where the marked corner should end up once the image is shown the right way
+	var cases = []struct {
+		orientation int
+		size        image.Point
+		corner      image.Point
+	}{
+		{1, image.Pt(3, 2), image.Pt(0, 0)},
+		{2, image.Pt(3, 2), image.Pt(2, 0)},
+		{3, image.Pt(3, 2), image.Pt(2, 1)},
+		{4, image.Pt(3, 2), image.Pt(0, 1)},
+		{5, image.Pt(2, 3), image.Pt(0, 0)},
+		{6, image.Pt(2, 3), image.Pt(1, 0)},
+		{7, image.Pt(2, 3), image.Pt(1, 2)},
+		{8, image.Pt(2, 3), image.Pt(0, 2)},
+	}
+
+	for _, c := range cases {
+		var oriented = Orient(img, c.orientation)
+		assert.Equal(t, c.size, oriented.Bounds().Size(), c.orientation)
+		assert.Equal(t, red, color.NRGBAModel.Convert(oriented.At(c.corner.X, c.corner.Y)), c.orientation)
+	}
+}
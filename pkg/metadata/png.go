@@ -0,0 +1,171 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// xmpKeyword is the iTXt keyword XMP is stored under.
+const xmpKeyword = "XML:com.adobe.xmp"
+
+type pngChunk struct {
+	name string
+	data []byte
+}
+
+func pngChunks(data []byte) ([]pngChunk, error) {
+
+	var chunks []pngChunk
+	for i := len(pngSignature); i < len(data); {
+		if i+8 > len(data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		var length = int(binary.BigEndian.Uint32(data[i : i+4]))
+		if length < 0 || i+12+length > len(data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		chunks = append(chunks, pngChunk{name: string(data[i+4 : i+8]), data: data[i+8 : i+8+length]})
+		i += 12 + length
+	}
+
+	if len(chunks) == 0 || chunks[0].name != "IHDR" {
+		return nil, errors.New("png does not start with IHDR")
+	}
+	return chunks, nil
+}
+
+func (c pngChunk) isXMP() bool {
+	return c.name == "iTXt" && bytes.HasPrefix(c.data, []byte(xmpKeyword+"\x00"))
+}
+
+func readPNG(data []byte) (Metadata, error) {
+
+	var chunks, err = pngChunks(data)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	var md Metadata
+	for _, chunk := range chunks {
+		switch {
+		case chunk.name == "eXIf":
+			md.EXIF = chunk.data
+
+		case chunk.name == "iCCP":
+			// profile name, 0, compression method, then the zlib compressed profile
+			var nameEnd = bytes.IndexByte(chunk.data, 0)
+			if nameEnd < 0 || nameEnd+2 > len(chunk.data) {
+				return Metadata{}, errors.New("malformed iCCP chunk")
+			}
+			if md.ICC, err = inflate(chunk.data[nameEnd+2:]); err != nil {
+				return Metadata{}, err
+			}
+
+		case chunk.isXMP():
+			if md.XMP, err = readITXt(chunk.data); err != nil {
+				return Metadata{}, err
+			}
+		}
+	}
+
+	return md, nil
+}
+
+// readITXt returns the text of an iTXt chunk: keyword, 0, compression flag and method, language, 0, translated
+// keyword, 0, text.
+func readITXt(data []byte) ([]byte, error) {
+
+	var rest = data[len(xmpKeyword)+1:]
+	if len(rest) < 2 {
+		return nil, errors.New("malformed iTXt chunk")
+	}
+	var compressed = rest[0] == 1
+	rest = rest[2:]
+
+	for range 2 { // language and translated keyword
+		var end = bytes.IndexByte(rest, 0)
+		if end < 0 {
+			return nil, errors.New("malformed iTXt chunk")
+		}
+		rest = rest[end+1:]
+	}
+
+	if compressed {
+		return inflate(rest)
+	}
+	return rest, nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	var r, err = zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// writePNG puts md right after IHDR, replacing any metadata already there.
+func writePNG(data []byte, md Metadata) ([]byte, error) {
+
+	var chunks, err = pngChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var out = bytes.NewBuffer(make([]byte, 0, len(data)+len(md.EXIF)+len(md.XMP)+len(md.ICC)+1024))
+	out.Write(pngSignature)
+	writeChunk(out, chunks[0])
+
+	if len(md.ICC) > 0 {
+		var profile bytes.Buffer
+		profile.WriteString("ICC Profile\x00\x00")
+		var w = zlib.NewWriter(&profile)
+		w.Write(md.ICC)
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		writeChunk(out, pngChunk{name: "iCCP", data: profile.Bytes()})
+	}
+	if len(md.EXIF) > 0 {
+		writeChunk(out, pngChunk{name: "eXIf", data: md.EXIF})
+	}
+	if len(md.XMP) > 0 {
+		// uncompressed with no language or translated keyword
+		var text = append([]byte(xmpKeyword+"\x00\x00\x00\x00\x00"), md.XMP...)
+		writeChunk(out, pngChunk{name: "iTXt", data: text})
+	}
+
+	for _, chunk := range chunks[1:] {
+		switch {
+		case chunk.name == "iCCP", chunk.name == "eXIf", chunk.isXMP():
+			continue
+		case chunk.name == "sRGB" && len(md.ICC) > 0:
+			// a png can have an icc profile or be srgb, not both
+			continue
+		}
+		writeChunk(out, chunk)
+	}
+
+	return out.Bytes(), nil
+}
+
+func writeChunk(out *bytes.Buffer, chunk pngChunk) {
+	out.Write(binary.BigEndian.AppendUint32(nil, uint32(len(chunk.data))))
+
+	var crc = crc32.NewIEEE()
+	crc.Write([]byte(chunk.name))
+	crc.Write(chunk.data)
+
+	out.WriteString(chunk.name)
+	out.Write(chunk.data)
+	out.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+}
@@ -0,0 +1,36 @@
+package metadata
+
+import (
+	"fmt"
+	"os"
+)
+
+// CopyTimes sets the modification and access times of dst to those of src, each only if asked to.
+func CopyTimes(src, dst string, modTime, accessTime bool) error {
+
+	if !modTime && !accessTime {
+		return nil
+	}
+
+	var srcInfo, err = os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("error stating file: %s, err: %w", src, err)
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return fmt.Errorf("error stating file: %s, err: %w", dst, err)
+	}
+
+	var mtime, atime = dstInfo.ModTime(), accessTimeOf(dstInfo)
+	if modTime {
+		mtime = srcInfo.ModTime()
+	}
+	if accessTime {
+		atime = accessTimeOf(srcInfo)
+	}
+
+	if err := os.Chtimes(dst, atime, mtime); err != nil {
+		return fmt.Errorf("error setting times of %s: %w", dst, err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"regexp"
+	"strconv"
+)
+
+const (
+	imageWidthTag      = 0x0100
+	imageLengthTag     = 0x0101
+	exifIFDTag         = 0x8769
+	pixelXDimensionTag = 0xa002
+	pixelYDimensionTag = 0xa003
+
+	typeShort = 3
+	typeLong  = 4
+)
+
+// UpdateEXIF returns a copy of exif with its pixel dimensions set to width and height. With resetOrientation the
+// orientation is set to 1, for images whose pixels have already been turned.
+func UpdateEXIF(exif []byte, width, height int, resetOrientation bool) []byte {
+
+	exif = append([]byte{}, exif...)
+
+	var order, ifd, err = tiffHeader(exif)
+	if err != nil {
+		return exif
+	}
+
+	setTag(exif, order, ifd, imageWidthTag, width)
+	setTag(exif, order, ifd, imageLengthTag, height)
+	if resetOrientation {
+		setTag(exif, order, ifd, orientationTag, 1)
+	}
+
+	if entry, found := findTag(exif, order, ifd, exifIFDTag); found {
+		var exifIFD = int(order.Uint32(exif[entry+8 : entry+12]))
+		setTag(exif, order, exifIFD, pixelXDimensionTag, width)
+		setTag(exif, order, exifIFD, pixelYDimensionTag, height)
+	}
+
+	return exif
+}
+
+// setTag overwrites the value of a tag that is already there, tags are never added so offsets stay valid.
+func setTag(exif []byte, order binary.ByteOrder, ifd int, tag uint16, value int) {
+
+	var entry, found = findTag(exif, order, ifd, tag)
+	if !found || order.Uint32(exif[entry+4:entry+8]) != 1 {
+		return
+	}
+
+	switch order.Uint16(exif[entry+2 : entry+4]) {
+	case typeShort:
+		if value <= 0xffff {
+			order.PutUint16(exif[entry+8:entry+10], uint16(value))
+		}
+	case typeLong:
+		order.PutUint32(exif[entry+8:entry+12], uint32(value))
+	}
+}
+
+var (
+	xmpWidth       = xmpPropertyRegex("exif:PixelXDimension|tiff:ImageWidth")
+	xmpHeight      = xmpPropertyRegex("exif:PixelYDimension|tiff:ImageLength")
+	xmpOrientation = xmpPropertyRegex("tiff:Orientation")
+)
+
+// xmpPropertyRegex matches the properties as attributes, name="1", or as elements, <name>1</name>.
+func xmpPropertyRegex(names string) *regexp.Regexp {
+	return regexp.MustCompile(`((?:` + names + `)\s*=\s*["']|<(?:` + names + `)>)\d+`)
+}
+
+// UpdateXMP is UpdateEXIF for XMP.
+func UpdateXMP(xmp []byte, width, height int, resetOrientation bool) []byte {
+
+	xmp = xmpWidth.ReplaceAll(xmp, []byte("${1}"+strconv.Itoa(width)))
+	xmp = xmpHeight.ReplaceAll(xmp, []byte("${1}"+strconv.Itoa(height)))
+	if resetOrientation {
+		xmp = xmpOrientation.ReplaceAll(xmp, []byte("${1}1"))
+	}
+	return xmp
+}
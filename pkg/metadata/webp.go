@@ -0,0 +1,176 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// VP8X flags for the optional chunks.
+const (
+	webpICCFlag   = 0x20
+	webpAlphaFlag = 0x10
+	webpEXIFFlag  = 0x08
+	webpXMPFlag   = 0x04
+)
+
+type riffChunk struct {
+	name string
+	data []byte
+}
+
+func webpChunks(data []byte) ([]riffChunk, error) {
+
+	var chunks []riffChunk
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		var size = int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		if size < 0 || i+8+size > len(data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		chunks = append(chunks, riffChunk{name: string(data[i : i+4]), data: data[i+8 : i+8+size]})
+		i += 8 + size + size%2 // chunks are padded to an even size
+	}
+
+	if len(chunks) == 0 {
+		return nil, errors.New("webp has no chunks")
+	}
+	return chunks, nil
+}
+
+func readWebP(data []byte) (Metadata, error) {
+
+	var chunks, err = webpChunks(data)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	var md Metadata
+	for _, chunk := range chunks {
+		switch chunk.name {
+		case "EXIF":
+			md.EXIF = chunk.data
+		case "XMP ":
+			md.XMP = chunk.data
+		case "ICCP":
+			md.ICC = chunk.data
+		}
+	}
+
+	return md, nil
+}
+
+// writeWebP rewrites the file in the extended format, which is the only one that can hold metadata.
+func writeWebP(data []byte, md Metadata) ([]byte, error) {
+
+	var chunks, err = webpChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var header [10]byte
+	switch chunks[0].name {
+	case "VP8X":
+		if len(chunks[0].data) < 10 {
+			return nil, errors.New("malformed VP8X chunk")
+		}
+		copy(header[:], chunks[0].data)
+		chunks = chunks[1:]
+
+	case "VP8 ", "VP8L":
+		var width, height, alpha, err = webpSize(chunks[0])
+		if err != nil {
+			return nil, err
+		}
+		if alpha {
+			header[0] = webpAlphaFlag
+		}
+		putUint24(header[4:7], width-1)
+		putUint24(header[7:10], height-1)
+
+	default:
+		return nil, errors.New("unknown webp layout")
+	}
+
+	header[0] &^= webpICCFlag | webpEXIFFlag | webpXMPFlag
+	if len(md.ICC) > 0 {
+		header[0] |= webpICCFlag
+	}
+	if len(md.EXIF) > 0 {
+		header[0] |= webpEXIFFlag
+	}
+	if len(md.XMP) > 0 {
+		header[0] |= webpXMPFlag
+	}
+
+	// the order is fixed: VP8X, ICCP, the image, EXIF, XMP
+	var out = []riffChunk{{name: "VP8X", data: header[:]}}
+	if len(md.ICC) > 0 {
+		out = append(out, riffChunk{name: "ICCP", data: md.ICC})
+	}
+	for _, chunk := range chunks {
+		if chunk.name != "ICCP" && chunk.name != "EXIF" && chunk.name != "XMP " {
+			out = append(out, chunk)
+		}
+	}
+	if len(md.EXIF) > 0 {
+		out = append(out, riffChunk{name: "EXIF", data: md.EXIF})
+	}
+	if len(md.XMP) > 0 {
+		out = append(out, riffChunk{name: "XMP ", data: md.XMP})
+	}
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	for _, chunk := range out {
+		body.WriteString(chunk.name)
+		body.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(chunk.data))))
+		body.Write(chunk.data)
+		if len(chunk.data)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+
+	var file = bytes.NewBuffer(make([]byte, 0, body.Len()+8))
+	file.WriteString("RIFF")
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(body.Len())))
+	file.Write(body.Bytes())
+
+	return file.Bytes(), nil
+}
+
+// webpSize reads the canvas size from a simple lossy or lossless webp.
+func webpSize(chunk riffChunk) (int, int, bool, error) {
+
+	switch chunk.name {
+	case "VP8 ":
+		// 3 byte frame tag, 3 byte start code, then 14 bits each of width and height
+		if len(chunk.data) < 10 || !bytes.Equal(chunk.data[3:6], []byte{0x9d, 0x01, 0x2a}) {
+			return 0, 0, false, errors.New("malformed VP8 chunk")
+		}
+		var width = int(binary.LittleEndian.Uint16(chunk.data[6:8]) & 0x3fff)
+		var height = int(binary.LittleEndian.Uint16(chunk.data[8:10]) & 0x3fff)
+		return width, height, false, nil
+
+	case "VP8L":
+		// signature byte, then 14 bits each of width-1 and height-1 and the alpha bit
+		if len(chunk.data) < 5 || chunk.data[0] != 0x2f {
+			return 0, 0, false, errors.New("malformed VP8L chunk")
+		}
+		var bits = binary.LittleEndian.Uint32(chunk.data[1:5])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, bits>>28&1 == 1, nil
+	}
+
+	return 0, 0, false, errors.New("not a simple webp")
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
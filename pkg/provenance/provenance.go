@@ -0,0 +1,191 @@
+package provenance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Format is how a sidecar is written.
+type Format string
+
+const (
+	// None writes no sidecars.
+	None Format = ""
+	JSON Format = "json"
+	XMP  Format = "xmp"
+)
+
+// Formats is every format a sidecar can be read from, in the order they are looked for.
+var Formats = []Format{JSON, XMP}
+
+// Record is how an upsized image was made.
+type Record struct {
+	SourceFile string `json:"source_file"`
+	// SourceSHA256 is the hex sha256 of the original when it was upsized.
+	SourceSHA256 string `json:"source_sha256"`
+	ModelName    string `json:"model_name"`
+	Scale        int    `json:"scale"`
+	// TileSize is the size of the tiles the original was split into, 0 when it was upsized whole.
+	TileSize       int    `json:"tile_size"`
+	Backend        string `json:"backend"`
+	BackendVersion string `json:"backend_version"`
+	GPU            string `json:"gpu"`
+	// Passes are the runs of realesrgan when it took more than one, each on the output of the last.
+	Passes []Pass `json:"passes,omitempty"`
+	// Target is the size the image was resampled to after upsizing, empty when it was not.
+	Target string `json:"target,omitempty"`
+	// Encoding is how the image was encoded, empty when realesrgan wrote it.
+	Encoding string `json:"encoding,omitempty"`
+	// Duration is how long realesrgan ran.
+	Duration         time.Duration `json:"duration"`
+	SchedulerVersion string        `json:"scheduler_version"`
+	UpsizedAt        time.Time     `json:"upsized_at"`
+}
+
+// Pass is one run of realesrgan in a chain.
+type Pass struct {
+	ModelName string        `json:"model_name"`
+	Scale     int           `json:"scale"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// ParseFormat parses the -sidecar flag, none or an empty string turns sidecars off.
+func ParseFormat(format string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(format))); f {
+	case None, "none":
+		return None, nil
+	case JSON, XMP:
+		return f, nil
+	default:
+		return None, fmt.Errorf("unknown sidecar format: %s, expected json, xmp or none", format)
+	}
+}
+
+// Path returns the sidecar of upsizedFile in format, the image name with the format as an extra extension so images
+// that only differ by extension do not share one, e.g. fox_4x.png.json
+func Path(upsizedFile string, format Format) string {
+	return upsizedFile + "." + string(format)
+}
+
+// IsSidecar reports whether file is named like a sidecar and returns the image it belongs to.
+func IsSidecar(file string) (string, bool) {
+	for _, format := range Formats {
+		if image, found := strings.CutSuffix(file, "."+string(format)); found && strings.Contains(image, ".") {
+			return image, true
+		}
+	}
+	return "", false
+}
+
+// Write writes the sidecar of upsizedFile, replacing any sidecar it had in either format.
+func Write(upsizedFile string, format Format, record Record) error {
+
+	var data []byte
+	var err error
+	switch format {
+	case None:
+		return nil
+	case JSON:
+		data, err = json.MarshalIndent(record, "", "  ")
+	case XMP:
+		data, err = marshalXMP(record)
+	default:
+		return fmt.Errorf("unknown sidecar format: %s", format)
+	}
+	if err != nil {
+		return fmt.Errorf("error encoding sidecar of %s: %w", upsizedFile, err)
+	}
+
+	if err := Remove(upsizedFile); err != nil {
+		return err
+	}
+
+	var file = Path(upsizedFile, format)
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		return fmt.Errorf("error writing sidecar: %s, err: %w", file, err)
+	}
+	return nil
+}
+
+// Read reads the sidecar of upsizedFile in whichever format it was written. The error wraps os.ErrNotExist when there
+// is none.
+func Read(upsizedFile string) (Record, error) {
+
+	for _, format := range Formats {
+		var file = Path(upsizedFile, format)
+		var data, err = os.ReadFile(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return Record{}, fmt.Errorf("error reading sidecar: %s, err: %w", file, err)
+		}
+
+		var record Record
+		if format == JSON {
+			err = json.Unmarshal(data, &record)
+		} else {
+			record, err = unmarshalXMP(data)
+		}
+		if err != nil {
+			return Record{}, fmt.Errorf("error decoding sidecar: %s, err: %w", file, err)
+		}
+		return record, nil
+	}
+
+	return Record{}, fmt.Errorf("no sidecar for %s: %w", upsizedFile, os.ErrNotExist)
+}
+
+// Rename moves the sidecar of oldFile, if it has one, to go with newFile.
+func Rename(oldFile, newFile string) error {
+
+	for _, format := range Formats {
+		if err := os.Rename(Path(oldFile, format), Path(newFile, format)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error moving sidecar of %s: %w", oldFile, err)
+		}
+	}
+	return nil
+}
+
+// Remove deletes the sidecar of upsizedFile, if it has one.
+func Remove(upsizedFile string) error {
+
+	for _, format := range Formats {
+		if err := os.Remove(Path(upsizedFile, format)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing sidecar of %s: %w", upsizedFile, err)
+		}
+	}
+	return nil
+}
+
+// HashFile returns the hex sha256 of file.
+func HashFile(file string) (string, error) {
+
+	var f, err = os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("error opening file: %s, err: %w", file, err)
+	}
+	defer f.Close()
+
+	var hash = sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("error hashing file: %s, err: %w", file, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// SourceChanged reports whether the original of record no longer has the content it was upsized from.
+func (r Record) SourceChanged() (bool, error) {
+
+	var hash, err = HashFile(r.SourceFile)
+	if err != nil {
+		return false, err
+	}
+	return hash != r.SourceSHA256, nil
+}
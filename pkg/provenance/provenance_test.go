@@ -0,0 +1,101 @@
+package provenance
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSidecar(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var original, upsized = filepath.Join(dir, "fox.jpg"), filepath.Join(dir, "fox_4x.png")
+	assert.NoError(t, os.WriteFile(original, []byte("fox"), 0600))
+
+	var hash, err = HashFile(original)
+	assert.NoError(t, err)
+	assert.Equal(t, "776cb326ab0cd5f0a974c1b9606044d8485201f2db19cf8e3749bdee5f36e200", hash)
+
+	var record = Record{
+		SourceFile:       original,
+		SourceSHA256:     hash,
+		ModelName:        "realesrgan-x4plus",
+		Scale:            4,
+		Backend:          "/usr/bin/realesrgan-ncnn-vulkan",
+		BackendVersion:   "sha256:0123456789ab",
+		GPU:              "0",
+		Passes:           []Pass{{ModelName: "realesrgan-x4plus", Scale: 4, Duration: 10 * time.Second}, {ModelName: "realesr-animevideov3", Scale: 2, Duration: 2500 * time.Millisecond}},
+		Target:           "fit:3840x2160",
+		Encoding:         "jpeg quality=85 1234 bytes",
+		Duration:         12500 * time.Millisecond,
+		SchedulerVersion: "v1.2.3",
+		UpsizedAt:        time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+	}
+
+	for _, format := range Formats {
+		assert.NoError(t, Write(upsized, format, record), format)
+		assert.FileExists(t, Path(upsized, format))
+
+		var read, err = Read(upsized)
+		assert.NoError(t, err, format)
+		assert.Equal(t, record, read, format)
+	}
+
+	// writing one format replaces the other
+	assert.NoFileExists(t, Path(upsized, JSON))
+
+	var changed bool
+	changed, err = record.SourceChanged()
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	assert.NoError(t, os.WriteFile(original, []byte("a different fox"), 0600))
+	changed, err = record.SourceChanged()
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	var moved = filepath.Join(dir, "fox_4x.realesrgan-x4plus.png")
+	assert.NoError(t, Rename(upsized, moved))
+	_, err = Read(upsized)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = Read(moved)
+	assert.NoError(t, err)
+
+	assert.NoError(t, Remove(moved))
+	assert.NoError(t, Remove(moved))
+	assert.NoFileExists(t, Path(moved, XMP))
+}
+
+func TestIsSidecar(t *testing.T) {
+	t.Parallel()
+
+	var image, ok = IsSidecar("/upsized/fox_4x.png.xmp")
+	assert.True(t, ok)
+	assert.Equal(t, "/upsized/fox_4x.png", image)
+
+	image, ok = IsSidecar("/upsized/fox_4x.png.json")
+	assert.True(t, ok)
+	assert.Equal(t, "/upsized/fox_4x.png", image)
+
+	_, ok = IsSidecar("/upsized/fox_4x.png")
+	assert.False(t, ok)
+	_, ok = IsSidecar("/upsized/settings.json")
+	assert.False(t, ok)
+}
+
+func TestParseFormat(t *testing.T) {
+	t.Parallel()
+
+	for input, expected := range map[string]Format{"": None, "none": None, "JSON": JSON, " xmp": XMP} {
+		var format, err = ParseFormat(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, format, input)
+	}
+
+	_, err := ParseFormat("yaml")
+	assert.Error(t, err)
+}
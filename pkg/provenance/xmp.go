@@ -0,0 +1,158 @@
+package provenance
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// namespace holds the properties of an XMP sidecar, under the rs prefix.
+const namespace = "https://github.com/kmulvey/realesrgan-scheduler/ns/provenance/1.0/"
+
+// properties returns the XMP property names and values of record, in the order they are written.
+func (r Record) properties() [][2]string {
+	return [][2]string{
+		{"SourceFile", r.SourceFile},
+		{"SourceSHA256", r.SourceSHA256},
+		{"ModelName", r.ModelName},
+		{"Scale", strconv.Itoa(r.Scale)},
+		{"TileSize", strconv.Itoa(r.TileSize)},
+		{"Backend", r.Backend},
+		{"BackendVersion", r.BackendVersion},
+		{"GPU", r.GPU},
+		{"Passes", formatPasses(r.Passes)},
+		{"Target", r.Target},
+		{"Encoding", r.Encoding},
+		{"Duration", r.Duration.String()},
+		{"SchedulerVersion", r.SchedulerVersion},
+		{"UpsizedAt", r.UpsizedAt.Format(time.RFC3339Nano)},
+	}
+}
+
+// marshalXMP writes record as the attributes of a single rdf:Description so any XMP reader can show it.
+func marshalXMP(record Record) ([]byte, error) {
+
+	var buf bytes.Buffer
+	buf.WriteString(`<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>` + "\n")
+	buf.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` + "\n")
+	buf.WriteString(` <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` + "\n")
+	buf.WriteString(`  <rdf:Description rdf:about="" xmlns:rs="` + namespace + `"`)
+
+	for _, property := range record.properties() {
+		buf.WriteString("\n   rs:" + property[0] + `="`)
+		if err := xml.EscapeText(&buf, []byte(property[1])); err != nil {
+			return nil, err
+		}
+		buf.WriteString(`"`)
+	}
+
+	buf.WriteString("/>\n </rdf:RDF>\n</x:xmpmeta>\n")
+	buf.WriteString(`<?xpacket end="w"?>` + "\n")
+	return buf.Bytes(), nil
+}
+
+// unmarshalXMP reads back what marshalXMP wrote, the attributes are found by namespace so other prefixes work too.
+func unmarshalXMP(data []byte) (Record, error) {
+
+	var decoder = xml.NewDecoder(bytes.NewReader(data))
+	for {
+		var token, err = decoder.Token()
+		if err != nil {
+			return Record{}, fmt.Errorf("no provenance in xmp: %w", err)
+		}
+
+		var element, ok = token.(xml.StartElement)
+		if !ok || element.Name.Local != "Description" {
+			continue
+		}
+
+		var values = make(map[string]string, len(element.Attr))
+		for _, attr := range element.Attr {
+			if attr.Name.Space == namespace {
+				values[attr.Name.Local] = attr.Value
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		return recordFromProperties(values)
+	}
+}
+
+func recordFromProperties(values map[string]string) (Record, error) {
+
+	var record = Record{
+		SourceFile:       values["SourceFile"],
+		SourceSHA256:     values["SourceSHA256"],
+		ModelName:        values["ModelName"],
+		Backend:          values["Backend"],
+		BackendVersion:   values["BackendVersion"],
+		GPU:              values["GPU"],
+		Target:           values["Target"],
+		Encoding:         values["Encoding"],
+		SchedulerVersion: values["SchedulerVersion"],
+	}
+
+	var errs []error
+	var parseInt = func(name string) int {
+		var n, err = strconv.Atoi(values[name])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", name, err))
+		}
+		return n
+	}
+	record.Scale = parseInt("Scale")
+	record.TileSize = parseInt("TileSize")
+
+	var err error
+	if record.Passes, err = parsePasses(values["Passes"]); err != nil {
+		errs = append(errs, fmt.Errorf("invalid Passes: %w", err))
+	}
+	if record.Duration, err = time.ParseDuration(values["Duration"]); err != nil {
+		errs = append(errs, fmt.Errorf("invalid Duration: %w", err))
+	}
+	if record.UpsizedAt, err = time.Parse(time.RFC3339Nano, values["UpsizedAt"]); err != nil {
+		errs = append(errs, fmt.Errorf("invalid UpsizedAt: %w", err))
+	}
+
+	return record, errors.Join(errs...)
+}
+
+// formatPasses writes passes as model:scale:duration separated by semicolons, e.g. realesrgan-x4plus:4:1m2s
+func formatPasses(passes []Pass) string {
+	var parts = make([]string, len(passes))
+	for i, p := range passes {
+		parts[i] = fmt.Sprintf("%s:%d:%s", p.ModelName, p.Scale, p.Duration)
+	}
+	return strings.Join(parts, ";")
+}
+
+func parsePasses(value string) ([]Pass, error) {
+
+	if value == "" {
+		return nil, nil
+	}
+
+	var parts = strings.Split(value, ";")
+	var passes = make([]Pass, len(parts))
+	for i, part := range parts {
+		var fields = strings.Split(part, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("expected model:scale:duration, got: %s", part)
+		}
+
+		var err error
+		passes[i].ModelName = fields[0]
+		if passes[i].Scale, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, err
+		}
+		if passes[i].Duration, err = time.ParseDuration(fields[2]); err != nil {
+			return nil, err
+		}
+	}
+	return passes, nil
+}
@@ -0,0 +1,113 @@
+package realesrgan
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// BatchConfig is a dir of images upsized by a single run of realesrgan, so the model is loaded and the gpu set up
+// once for all of them rather than once per image.
+type BatchConfig struct {
+	InputDir  string
+	OutputDir string
+	// Format is the extension of the upsized images without the dot, each is named after its input.
+	Format string
+	// Scale is passed to realesrgan as -s, 0 leaves it to realesrgan.
+	Scale          int
+	ModelName      string
+	RealesrganPath string
+	GpuId          uint8
+	// Progress gets the progress of the whole batch.
+	Progress chan string
+}
+
+// realesrgan prints these for each image when it is run with -v, the path is the input.
+var batchFailedRegex = regexp.MustCompile(`^(?:decode|encode) image (.+) failed`)
+var batchDoneRegex = regexp.MustCompile(`^(.+) -> .+ done$`)
+
+// UpsizeBatch runs realesrgan on every image in InputDir. An image that fails does not stop the others, the errors
+// of the images that failed are returned by the name of their input. The error is for the batch as a whole, e.g.
+// realesrgan crashing, which leaves the images it had not got to without an output.
+func UpsizeBatch(b BatchConfig) (map[string]error, error) {
+
+	var inputs, err = os.ReadDir(b.InputDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading batch dir: %s, err: %w", b.InputDir, err)
+	}
+
+	if err := os.MkdirAll(b.OutputDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("unable to create upsized directory: %w", err)
+	}
+
+	var args = []string{"-v", "-f", b.Format, "-g", strconv.Itoa(int(b.GpuId)), "-n", b.ModelName, "-i", b.InputDir, "-o", b.OutputDir}
+	if b.Scale > 0 {
+		args = append(args, "-s", strconv.Itoa(b.Scale))
+	}
+
+	// these variables were linted up the chain
+	//nolint:gosec
+	var cmd = exec.Command(b.RealesrganPath, args...)
+	stdoutIn, _ := cmd.StdoutPipe()
+	stderrIn, _ := cmd.StderrPipe()
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting realesrgan: %w", err)
+	}
+
+	var progress = batchProgress{total: len(inputs), progress: b.Progress, failures: make(map[string]error)}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, r := range []io.Reader{stdoutIn, stderrIn} {
+		go func(r io.Reader) {
+			defer wg.Done()
+			progress.scan(r)
+		}(r)
+	}
+	wg.Wait()
+
+	if err := cmd.Wait(); err != nil {
+		return progress.failures, fmt.Errorf("error running cmd: %w", err)
+	}
+
+	if b.Progress != nil {
+		b.Progress <- "100%"
+	}
+	return progress.failures, nil
+}
+
+// batchProgress follows the output of realesrgan, turning the progress of each image into the progress of the batch.
+type batchProgress struct {
+	lock     sync.Mutex
+	total    int
+	done     int
+	progress chan string
+	failures map[string]error
+}
+
+func (p *batchProgress) scan(r io.Reader) {
+
+	var scanner = bufio.NewScanner(r)
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+
+		p.lock.Lock()
+		if match := batchFailedRegex.FindStringSubmatch(line); match != nil {
+			p.failures[filepath.Base(match[1])] = errors.New(line)
+			p.done++
+		} else if batchDoneRegex.MatchString(line) {
+			p.done++
+		} else if percent, err := strconv.ParseFloat(strings.TrimSuffix(line, "%"), 64); err == nil && p.progress != nil && p.total > 0 {
+			var overall = (float64(min(p.done, p.total-1)) + percent/100) / float64(p.total) * 100
+			p.progress <- fmt.Sprintf("%.2f%%", overall)
+		}
+		p.lock.Unlock()
+	}
+}
@@ -0,0 +1,149 @@
+package realesrgan
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Device is a vulkan device as realesrgan lists it when it starts.
+type Device struct {
+	// ID is what is passed to realesrgan as -g.
+	ID   uint8
+	Name string
+	// ComputeQueues is how many images the device can work on at once.
+	ComputeQueues int
+	// FP16 is whether the device can store weights in half precision, which makes it faster.
+	FP16 bool
+}
+
+// softwareRenderers are the names of vulkan drivers that run on the cpu, they are far too slow to upsize with.
+var softwareRenderers = []string{"llvmpipe", "lavapipe", "swiftshader", "softpipe"}
+
+// Software reports whether the device is a vulkan driver that runs on the cpu rather than a gpu.
+func (d Device) Software() bool {
+	var name = strings.ToLower(d.Name)
+	for _, renderer := range softwareRenderers {
+		if strings.Contains(name, renderer) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d Device) String() string {
+	return fmt.Sprintf("%d %s", d.ID, d.Name)
+}
+
+// deviceLineRegex matches the banner lines, e.g. [0 AMD RADV POLARIS10]  queueC=1[4]  queueG=0[1]  queueT=0[1]
+var deviceLineRegex = regexp.MustCompile(`^\[(\d+) ([^\]]+)\]\s+(.*)$`)
+
+// ParseDevices reads the devices out of the banner realesrgan prints when it starts, the rest of its output is
+// ignored.
+func ParseDevices(r io.Reader) ([]Device, error) {
+
+	var devices []Device
+	var byID = make(map[uint8]int)
+	var scanner = bufio.NewScanner(r)
+	for scanner.Scan() {
+		var match = deviceLineRegex.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match == nil {
+			continue
+		}
+
+		var id, err = strconv.ParseUint(match[1], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing device id: %s, err: %w", match[1], err)
+		}
+
+		var i, ok = byID[uint8(id)]
+		if !ok {
+			i = len(devices)
+			byID[uint8(id)] = i
+			devices = append(devices, Device{ID: uint8(id), Name: match[2]})
+		}
+
+		for _, field := range strings.Fields(match[3]) {
+			var key, value, _ = strings.Cut(field, "=")
+			switch key {
+			case "queueC":
+				// family[count]
+				if _, count, ok := strings.Cut(strings.TrimSuffix(value, "]"), "["); ok {
+					devices[i].ComputeQueues, _ = strconv.Atoi(count)
+				}
+			case "fp16-p/s/a":
+				// packed/storage/arithmetic
+				var support = strings.Split(value, "/")
+				devices[i].FP16 = len(support) == 3 && support[1] == "1"
+			}
+		}
+	}
+
+	return devices, scanner.Err()
+}
+
+// ProbeDevices runs realesrgan on a one pixel image with modelName to get it to list the vulkan devices it can see.
+func ProbeDevices(realesrganPath, modelName string) ([]Device, error) {
+
+	var dir, err = os.MkdirTemp("", "realesrgan-probe-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating probe dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var input = filepath.Join(dir, "probe.png")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		return nil, fmt.Errorf("error encoding probe image: %w", err)
+	}
+	if err := os.WriteFile(input, buf.Bytes(), 0600); err != nil {
+		return nil, fmt.Errorf("error writing probe image: %w", err)
+	}
+
+	// realesrgan prints the devices before it gets to the image, so whether it managed to upsize it does not matter
+	//nolint:gosec
+	var cmd = exec.Command(realesrganPath, "-i", input, "-o", filepath.Join(dir, "probe_upsized.png"), "-n", modelName, "-g", "0")
+	var output, runErr = cmd.CombinedOutput()
+
+	devices, err := ParseDevices(bytes.NewReader(output))
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, errors.Join(fmt.Errorf("realesrgan did not list any vulkan devices: %s", strings.TrimSpace(string(output))), runErr)
+	}
+	return devices, nil
+}
+
+// DiscoverDevices returns the vulkan devices realesrgan can upsize on, without software renderers unless
+// includeSoftware is set.
+func DiscoverDevices(realesrganPath, modelName string, includeSoftware bool) ([]Device, error) {
+
+	var probed, err = ProbeDevices(realesrganPath, modelName)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []Device
+	for _, device := range probed {
+		if device.Software() && !includeSoftware {
+			continue
+		}
+		devices = append(devices, device)
+	}
+
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("realesrgan only found software renderers: %v", probed)
+	}
+	return devices, nil
+}
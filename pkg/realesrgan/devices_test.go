@@ -0,0 +1,68 @@
+package realesrgan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const banner = `[0 AMD RADV POLARIS10]  queueC=1[4]  queueG=0[1]  queueT=0[1]
+[0 AMD RADV POLARIS10]  bugsbn1=0  bugbilz=0  bugcopc=0  bugihfa=0
+[0 AMD RADV POLARIS10]  fp16-p/s/a=1/1/0  int8-p/s/a=1/1/1
+[0 AMD RADV POLARIS10]  subgroup=64  basic=1  vote=1  ballot=1  shuffle=1
+[1 llvmpipe (LLVM 14.0.0, 256 bits)]  queueC=0[1]  queueG=0[1]  queueT=0[1]
+[1 llvmpipe (LLVM 14.0.0, 256 bits)]  bugsbn1=0  bugbilz=0  bugcopc=0  bugihfa=0
+[1 llvmpipe (LLVM 14.0.0, 256 bits)]  fp16-p/s/a=1/0/1  int8-p/s/a=1/1/1
+[1 llvmpipe (LLVM 14.0.0, 256 bits)]  subgroup=8  basic=1  vote=1  ballot=1  shuffle=1
+0.00%
+100.00%
+`
+
+func TestParseDevices(t *testing.T) {
+	t.Parallel()
+
+	var devices, err = ParseDevices(strings.NewReader(banner))
+	assert.NoError(t, err)
+	assert.Equal(t, []Device{
+		{ID: 0, Name: "AMD RADV POLARIS10", ComputeQueues: 4, FP16: true},
+		{ID: 1, Name: "llvmpipe (LLVM 14.0.0, 256 bits)", ComputeQueues: 1, FP16: false},
+	}, devices)
+	assert.False(t, devices[0].Software())
+	assert.True(t, devices[1].Software())
+	assert.Equal(t, "0 AMD RADV POLARIS10", devices[0].String())
+
+	devices, err = ParseDevices(strings.NewReader("decode image x.png failed\n"))
+	assert.NoError(t, err)
+	assert.Empty(t, devices)
+}
+
+func TestDiscoverDevices(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+	var realesrganPath = filepath.Join(dir, "realesrgan")
+	assert.NoError(t, os.WriteFile(realesrganPath, []byte("#!/bin/sh\ncat <<'EOF' >&2\n"+banner+"EOF\n"), 0700)) //nolint:gosec
+
+	var devices, err = DiscoverDevices(realesrganPath, "realesrgan-x4plus", false)
+	assert.NoError(t, err)
+	assert.Len(t, devices, 1)
+	assert.Equal(t, "AMD RADV POLARIS10", devices[0].Name)
+
+	devices, err = DiscoverDevices(realesrganPath, "realesrgan-x4plus", true)
+	assert.NoError(t, err)
+	assert.Len(t, devices, 2)
+
+	// a binary that does not list any devices is an error, as is one with only software renderers
+	var silentPath = filepath.Join(dir, "silent")
+	assert.NoError(t, os.WriteFile(silentPath, []byte("#!/bin/sh\necho vkCreateInstance failed >&2\nexit 1\n"), 0700)) //nolint:gosec
+	_, err = DiscoverDevices(silentPath, "realesrgan-x4plus", false)
+	assert.ErrorContains(t, err, "vkCreateInstance failed")
+
+	var softwarePath = filepath.Join(dir, "software")
+	assert.NoError(t, os.WriteFile(softwarePath, []byte("#!/bin/sh\necho '[0 llvmpipe (LLVM 14.0.0, 256 bits)]  queueC=0[1]'\n"), 0700)) //nolint:gosec
+	_, err = DiscoverDevices(softwarePath, "realesrgan-x4plus", false)
+	assert.ErrorContains(t, err, "only found software renderers")
+}
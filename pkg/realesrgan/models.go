@@ -0,0 +1,31 @@
+package realesrgan
+
+// modelScales are the scales each of the models shipped with realesrgan-ncnn-vulkan can upsize by.
+var modelScales = map[string][]int{
+	"realesrgan-x4plus":       {4},
+	"realesrgan-x4plus-anime": {4},
+	"realesrnet-x4plus":       {4},
+	"realesr-animevideov3":    {2, 3, 4},
+}
+
+// defaultScale is what realesrgan upsizes by when it is not given -s, and what other models are assumed to do.
+const defaultScale = 4
+
+// ModelScales returns the scales model supports, smallest first.
+func ModelScales(model string) []int {
+	if scales, ok := modelScales[model]; ok {
+		return scales
+	}
+	return []int{defaultScale}
+}
+
+// ScaleFor returns the smallest scale of model that upsizes by at least factor, or the largest if none do.
+func ScaleFor(model string, factor float64) int {
+	var scales = ModelScales(model)
+	for _, scale := range scales {
+		if float64(scale) >= factor {
+			return scale
+		}
+	}
+	return scales[len(scales)-1]
+}
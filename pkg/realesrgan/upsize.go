@@ -15,6 +15,8 @@ import (
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/kmulvey/realesrgan-scheduler/pkg/geometry"
+	"github.com/kmulvey/realesrgan-scheduler/pkg/imagetype"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -22,8 +24,17 @@ import (
 THIS CAME FROM WORKER.GO
 */
 type ImageConfig struct {
-	SourceFile     string
-	UpsizedFile    string
+	SourceFile  string
+	UpsizedFile string
+	// ReplaceFile is set when re-processing an existing upsized image, UpsizedFile is then a staging path
+	// that is moved over ReplaceFile once it has been verified.
+	ReplaceFile string
+	// Source is what SourceFile was found to be when it was discovered.
+	Source imagetype.Info
+	// Target is the size the upsized image is resampled to, none if it is not enabled.
+	Target geometry.Target
+	// Scale is passed to realesrgan as -s, 0 leaves it to realesrgan.
+	Scale          int
 	ModelName      string
 	RealesrganPath string
 	GpuId          uint8
@@ -47,7 +58,7 @@ func Upsize(img ImageConfig) error {
 	}
 
 	// upsize it !
-	var err = runCmdAndCaptureOutput(img.RealesrganPath, img.SourceFile, img.UpsizedFile, img.ModelName, img.GpuId, img.Progress)
+	var err = runCmdAndCaptureOutput(img.RealesrganPath, img.SourceFile, img.UpsizedFile, img.ModelName, img.GpuId, img.Scale, img.Progress)
 	if err != nil {
 		return fmt.Errorf("error running upsize command on file %s, err: %w", img.SourceFile, err)
 	}
@@ -56,11 +67,16 @@ func Upsize(img ImageConfig) error {
 }
 
 // runCmdAndCaptureOutput runs the realesrgan command and captures stdout and passes it to logProgress for single line logging.
-func runCmdAndCaptureOutput(cmdPath, inputImagePath, upsizedImagePath, modelName string, gpuID uint8, progress chan string) error {
+func runCmdAndCaptureOutput(cmdPath, inputImagePath, upsizedImagePath, modelName string, gpuID uint8, scale int, progress chan string) error {
+
+	var args = []string{"-f", filepath.Ext(upsizedImagePath), "-g", strconv.Itoa(int(gpuID)), "-n", modelName, "-i", inputImagePath, "-o", upsizedImagePath}
+	if scale > 0 {
+		args = append(args, "-s", strconv.Itoa(scale))
+	}
 
 	// these variables were linted up the chain
 	//nolint:gosec
-	var cmd = exec.Command(cmdPath, "-f", filepath.Ext(upsizedImagePath), "-g", strconv.Itoa(int(gpuID)), "-n", modelName, "-i", inputImagePath, "-o", upsizedImagePath)
+	var cmd = exec.Command(cmdPath, args...)
 	stdoutIn, _ := cmd.StdoutPipe()
 	stderrIn, _ := cmd.StderrPipe()
 	var errStdout error
@@ -0,0 +1,52 @@
+package realesrgan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// versions caches BinaryVersion by the path it was given, the binary is not expected to change while we run.
+var versions sync.Map
+
+type binaryVersion struct {
+	path, version string
+}
+
+// BinaryVersion resolves realesrganPath like exec does and identifies the build found there. realesrgan-ncnn-vulkan
+// has no version flag, so the version is the start of the sha256 of the binary.
+func BinaryVersion(realesrganPath string) (string, string, error) {
+
+	if cached, ok := versions.Load(realesrganPath); ok {
+		var v = cached.(binaryVersion)
+		return v.path, v.version, nil
+	}
+
+	var path, err = exec.LookPath(realesrganPath)
+	if err != nil {
+		return "", "", fmt.Errorf("error finding realesrgan: %s, err: %w", realesrganPath, err)
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return "", "", fmt.Errorf("error resolving realesrgan: %s, err: %w", realesrganPath, err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", fmt.Errorf("error opening realesrgan: %s, err: %w", path, err)
+	}
+	defer file.Close()
+
+	var hash = sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", "", fmt.Errorf("error hashing realesrgan: %s, err: %w", path, err)
+	}
+
+	var v = binaryVersion{path: path, version: "sha256:" + hex.EncodeToString(hash.Sum(nil))[:12]}
+	versions.Store(realesrganPath, v)
+	return v.path, v.version, nil
+}
@@ -1,25 +1,347 @@
 package semaphore
 
-type Semaphore struct {
-	resources chan any
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoResource is returned when a resource that is not in the semaphore is used.
+var ErrNoResource = errors.New("resource is not in the semaphore")
+
+// Semaphore is a pool of resources that are each shared by up to their capacity in weight. Waiters are served in the
+// order they arrived: once one is waiting, later ones wait behind it even if there would be room for them.
+type Semaphore[T comparable] struct {
+	lock      sync.Mutex
+	resources []*resource[T]
+	waiters   list.List
+	leases    uint64
 }
 
-func New(resources ...any) *Semaphore {
-	var s = &Semaphore{
-		resources: make(chan any, len(resources)),
-	}
+type resource[T comparable] struct {
+	value    T
+	capacity int
+	used     int
+	disabled bool
+	removed  bool
+	leases   map[uint64]Lease[T]
+}
+
+// Lease is the weight of a resource that a holder has taken, it is handed back with Release.
+type Lease[T comparable] struct {
+	Resource T
+	Weight   int
+	// Holder is who took it, see WithHolder.
+	Holder string
+	Since  time.Time
+	id     uint64
+}
 
-	for _, resource := range resources {
-		s.resources <- resource
+// Resource is the state of a resource, for picking one to acquire and for inspecting the semaphore.
+type Resource[T comparable] struct {
+	Value    T
+	Capacity int
+	Used     int
+	Disabled bool
+	Leases   []Lease[T]
+}
+
+// Free is how much weight can still be taken from the resource.
+func (r Resource[T]) Free() int {
+	if r.Disabled {
+		return 0
 	}
+	return r.Capacity - r.Used
+}
+
+// Pick chooses which of resources to take and how much of it, it reports false when none will do yet. It is called
+// with the semaphore locked, so it must not use it.
+type Pick[T comparable] func(resources []Resource[T]) (index, weight int, ok bool)
+
+type waiter[T comparable] struct {
+	pick   Pick[T]
+	holder string
+	lease  chan Lease[T]
+}
 
+type holderKey struct{}
+
+// WithHolder names who acquires with ctx, it shows up in the leases.
+func WithHolder(ctx context.Context, holder string) context.Context {
+	return context.WithValue(ctx, holderKey{}, holder)
+}
+
+func holderOf(ctx context.Context) string {
+	var holder, _ = ctx.Value(holderKey{}).(string)
+	return holder
+}
+
+// New makes a semaphore of resources that can each be held by one at a time.
+func New[T comparable](resources ...T) *Semaphore[T] {
+	var s = &Semaphore[T]{}
+	for _, r := range resources {
+		s.Add(r, 1)
+	}
 	return s
 }
 
-func (s *Semaphore) Barrow() any {
-	return <-s.resources
+// Add puts a resource in the semaphore that can be shared up to capacity, or changes the capacity of one that is
+// already there.
+func (s *Semaphore[T]) Add(value T, capacity int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if r := s.find(value); r != nil {
+		r.capacity = capacity
+		r.removed = false
+	} else {
+		s.resources = append(s.resources, &resource[T]{value: value, capacity: capacity, leases: make(map[uint64]Lease[T])})
+	}
+	s.notify()
+}
+
+// Remove takes a resource out of the semaphore, it is gone once its holders have released it.
+func (s *Semaphore[T]) Remove(value T) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var r = s.find(value)
+	if r == nil {
+		return ErrNoResource
+	}
+	r.removed = true
+	s.prune(r)
+	return nil
+}
+
+// Disable stops a resource from being acquired until it is enabled again, its holders keep it until they release it.
+func (s *Semaphore[T]) Disable(value T) error {
+	return s.setDisabled(value, true)
+}
+
+// Enable lets a disabled resource be acquired again.
+func (s *Semaphore[T]) Enable(value T) error {
+	return s.setDisabled(value, false)
+}
+
+func (s *Semaphore[T]) setDisabled(value T, disabled bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var r = s.find(value)
+	if r == nil {
+		return ErrNoResource
+	}
+	r.disabled = disabled
+	s.notify()
+	return nil
+}
+
+// Acquire waits for weight of any resource to be free and takes it from the first one that has it. It gives up when
+// ctx is done.
+func (s *Semaphore[T]) Acquire(ctx context.Context, weight int) (Lease[T], error) {
+	return s.AcquireFunc(ctx, firstFit[T](weight))
+}
+
+// TryAcquire is Acquire for when the weight is free now and nobody is waiting, it reports false otherwise.
+func (s *Semaphore[T]) TryAcquire(ctx context.Context, weight int) (Lease[T], bool) {
+	return s.TryAcquireFunc(ctx, firstFit[T](weight))
+}
+
+// AcquireFunc is Acquire for when the weight depends on the resource or not all of them will do, pick chooses.
+func (s *Semaphore[T]) AcquireFunc(ctx context.Context, pick Pick[T]) (Lease[T], error) {
+
+	s.lock.Lock()
+	if s.waiters.Len() == 0 {
+		if lease, ok := s.take(pick, holderOf(ctx)); ok {
+			s.lock.Unlock()
+			return lease, nil
+		}
+	}
+
+	var w = &waiter[T]{pick: pick, holder: holderOf(ctx), lease: make(chan Lease[T], 1)}
+	var element = s.waiters.PushBack(w)
+	s.lock.Unlock()
+
+	select {
+	case lease := <-w.lease:
+		return lease, nil
+
+	case <-ctx.Done():
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		select {
+		case lease := <-w.lease:
+			// it was served while giving up, hand it back
+			s.release(lease)
+		default:
+			s.waiters.Remove(element)
+		}
+		// the waiters behind this one may fit now
+		s.notify()
+		return Lease[T]{}, ctx.Err()
+	}
 }
 
-func (s *Semaphore) Return(resource any) {
-	s.resources <- resource
+// TryAcquireFunc is AcquireFunc for when pick can be satisfied now and nobody is waiting, it reports false otherwise.
+func (s *Semaphore[T]) TryAcquireFunc(ctx context.Context, pick Pick[T]) (Lease[T], bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.waiters.Len() > 0 {
+		return Lease[T]{}, false
+	}
+	return s.take(pick, holderOf(ctx))
+}
+
+// Release hands back a lease, releasing it again does nothing.
+func (s *Semaphore[T]) Release(lease Lease[T]) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.release(lease)
+	s.notify()
+}
+
+// Resources returns the state of every resource and who holds them.
+func (s *Semaphore[T]) Resources() []Resource[T] {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var resources = make([]Resource[T], len(s.resources))
+	for i, r := range s.resources {
+		resources[i] = r.state()
+	}
+	return resources
+}
+
+// Barrow waits for a resource and takes all of it.
+func (s *Semaphore[T]) Barrow() T {
+	var lease, _ = s.AcquireFunc(context.Background(), func(resources []Resource[T]) (int, int, bool) {
+		for i, r := range resources {
+			if !r.Disabled && r.Used == 0 {
+				return i, r.Capacity, true
+			}
+		}
+		return 0, 0, false
+	})
+	return lease.Resource
+}
+
+// Return hands back a resource taken with Barrow.
+func (s *Semaphore[T]) Return(value T) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if r := s.find(value); r != nil {
+		for _, lease := range r.leases {
+			s.release(lease)
+			break
+		}
+	}
+	s.notify()
+}
+
+// firstFit picks the first resource with weight free.
+func firstFit[T comparable](weight int) Pick[T] {
+	return func(resources []Resource[T]) (int, int, bool) {
+		for i, r := range resources {
+			if r.Free() >= weight {
+				return i, weight, true
+			}
+		}
+		return 0, 0, false
+	}
+}
+
+// take leases what pick chooses if it can, the lock must be held.
+func (s *Semaphore[T]) take(pick Pick[T], holder string) (Lease[T], bool) {
+
+	var available []*resource[T]
+	for _, r := range s.resources {
+		if !r.removed {
+			available = append(available, r)
+		}
+	}
+	var resources = make([]Resource[T], len(available))
+	for i, r := range available {
+		resources[i] = r.state()
+	}
+
+	var i, weight, ok = pick(resources)
+	if !ok || i < 0 || i >= len(available) {
+		return Lease[T]{}, false
+	}
+	var r = available[i]
+	if r.disabled || r.used+weight > r.capacity {
+		return Lease[T]{}, false
+	}
+
+	s.leases++
+	var lease = Lease[T]{Resource: r.value, Weight: weight, Holder: holder, Since: time.Now(), id: s.leases}
+	r.used += weight
+	r.leases[lease.id] = lease
+	return lease, true
+}
+
+// notify serves the waiters in order until one cannot be, the lock must be held.
+func (s *Semaphore[T]) notify() {
+	for element := s.waiters.Front(); element != nil; element = s.waiters.Front() {
+		var w = element.Value.(*waiter[T])
+		var lease, ok = s.take(w.pick, w.holder)
+		if !ok {
+			return
+		}
+		s.waiters.Remove(element)
+		w.lease <- lease
+	}
+}
+
+// release hands back a lease, the lock must be held.
+func (s *Semaphore[T]) release(lease Lease[T]) {
+	var r = s.find(lease.Resource)
+	if r == nil {
+		return
+	}
+	if _, ok := r.leases[lease.id]; !ok {
+		return
+	}
+	delete(r.leases, lease.id)
+	r.used -= lease.Weight
+	s.prune(r)
+}
+
+// prune drops a removed resource once nobody holds it, the lock must be held.
+func (s *Semaphore[T]) prune(r *resource[T]) {
+	if !r.removed || r.used > 0 {
+		return
+	}
+	for i, other := range s.resources {
+		if other == r {
+			s.resources = append(s.resources[:i], s.resources[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *Semaphore[T]) find(value T) *resource[T] {
+	for _, r := range s.resources {
+		if r.value == value {
+			return r
+		}
+	}
+	return nil
+}
+
+func (r *resource[T]) state() Resource[T] {
+	var state = Resource[T]{Value: r.value, Capacity: r.capacity, Used: r.used, Disabled: r.disabled}
+	for _, lease := range r.leases {
+		state.Leases = append(state.Leases, lease)
+	}
+	sort.Slice(state.Leases, func(i, j int) bool { return state.Leases[i].id < state.Leases[j].id })
+	return state
 }
@@ -1,7 +1,9 @@
 package semaphore
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -25,3 +27,123 @@ func TestSemaphore(t *testing.T) {
 	s.Return(1)
 	<-done
 }
+
+func TestWeighted(t *testing.T) {
+	t.Parallel()
+
+	var ctx = context.Background()
+	var s = New[string]()
+	s.Add("small", 2)
+	s.Add("big", 4)
+
+	var a, err = s.Acquire(WithHolder(ctx, "a"), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "big", a.Resource)
+	assert.Equal(t, "a", a.Holder)
+
+	b, ok := s.TryAcquire(ctx, 2)
+	assert.True(t, ok)
+	assert.Equal(t, "small", b.Resource)
+
+	_, ok = s.TryAcquire(ctx, 2)
+	assert.False(t, ok)
+
+	// the state shows who holds what
+	var resources = s.Resources()
+	assert.Len(t, resources, 2)
+	assert.Equal(t, 2, resources[0].Used)
+	assert.Equal(t, 0, resources[0].Free())
+	assert.Equal(t, []Lease[string]{a}, resources[1].Leases)
+
+	// releasing twice only counts once
+	s.Release(b)
+	s.Release(b)
+	assert.Equal(t, 0, s.Resources()[0].Used)
+	s.Release(a)
+}
+
+func TestFairness(t *testing.T) {
+	t.Parallel()
+
+	var ctx = context.Background()
+	var s = New[int]()
+	s.Add(1, 4)
+
+	var a, err = s.Acquire(ctx, 2)
+	assert.NoError(t, err)
+
+	// the heavy waiter is first, so the light ones wait behind it even though they would fit
+	var order = make(chan int, 2)
+	go func() {
+		var lease, _ = s.Acquire(ctx, 4)
+		order <- lease.Weight
+		s.Release(lease)
+	}()
+	assert.Eventually(t, func() bool { s.lock.Lock(); defer s.lock.Unlock(); return s.waiters.Len() == 1 }, time.Second, time.Millisecond)
+
+	_, ok := s.TryAcquire(ctx, 1)
+	assert.False(t, ok)
+	go func() {
+		var lease, _ = s.Acquire(ctx, 1)
+		order <- lease.Weight
+		s.Release(lease)
+	}()
+	assert.Eventually(t, func() bool { s.lock.Lock(); defer s.lock.Unlock(); return s.waiters.Len() == 2 }, time.Second, time.Millisecond)
+
+	s.Release(a)
+	assert.Equal(t, 4, <-order)
+	assert.Equal(t, 1, <-order)
+}
+
+func TestCancel(t *testing.T) {
+	t.Parallel()
+
+	var s = New("gpu")
+	var a, err = s.Acquire(context.Background(), 1)
+	assert.NoError(t, err)
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	var done = make(chan error)
+	go func() {
+		var _, err = s.Acquire(ctx, 1)
+		done <- err
+	}()
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	// the waiter that gave up is not in the way
+	s.Release(a)
+	_, ok := s.TryAcquire(context.Background(), 1)
+	assert.True(t, ok)
+}
+
+func TestDisableRemove(t *testing.T) {
+	t.Parallel()
+
+	var ctx = context.Background()
+	var s = New("a", "b")
+
+	assert.NoError(t, s.Disable("a"))
+	var lease, ok = s.TryAcquire(ctx, 1)
+	assert.True(t, ok)
+	assert.Equal(t, "b", lease.Resource)
+	_, ok = s.TryAcquire(ctx, 1)
+	assert.False(t, ok)
+
+	// enabling wakes the waiters up
+	var acquired = make(chan string)
+	go func() {
+		var lease, _ = s.Acquire(ctx, 1)
+		acquired <- lease.Resource
+	}()
+	assert.NoError(t, s.Enable("a"))
+	assert.Equal(t, "a", <-acquired)
+
+	// a removed resource stays until it is released
+	assert.NoError(t, s.Remove("b"))
+	assert.Len(t, s.Resources(), 2)
+	s.Release(lease)
+	assert.Len(t, s.Resources(), 1)
+	assert.ErrorIs(t, s.Remove("b"), ErrNoResource)
+	assert.ErrorIs(t, s.Enable("c"), ErrNoResource)
+}